@@ -0,0 +1,358 @@
+package koryxserv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Auth schemes an auth rule can require
+const (
+	AuthNone   = "none"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthOIDC   = "oidc"
+	AuthSigned = "signed"
+	// AuthClientCert accepts verified TLS client certificates (tls.client_auth)
+	AuthClientCert = "client_cert"
+)
+
+// Identity is the authenticated client of a request
+type Identity struct {
+	User   string         // username or token subject
+	Groups []string       // groups matched by auth rules
+	Method string         // auth scheme that accepted the request, e.g. "basic"
+	Claims map[string]any // verified token claims (bearer tokens, OIDC) or certificate names
+}
+
+// InGroup reports whether the identity belongs to the group
+func (id *Identity) InGroup(group string) bool {
+	return slices.Contains(id.Groups, group)
+}
+
+// identityKey stores the authenticated identity in the request context
+type identityKey struct{}
+
+// AuthenticatedIdentity returns the identity authentication middlewares
+// accepted for the request, or nil for anonymous requests
+func AuthenticatedIdentity(r *http.Request) *Identity {
+	id, _ := r.Context().Value(identityKey{}).(*Identity)
+	return id
+}
+
+// AuthenticatedUser returns the username authentication middlewares
+// accepted for the request, or "" for anonymous requests
+func AuthenticatedUser(r *http.Request) string {
+	if id := AuthenticatedIdentity(r); id != nil {
+		return id.User
+	}
+	return ""
+}
+
+// withIdentity records the identity on the request and in the access log
+func withIdentity(r *http.Request, id *Identity) *http.Request {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.user = id.User
+	}
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// authenticator checks the credentials of one auth scheme
+type authenticator interface {
+	// authenticate returns the identity of a request with valid credentials
+	authenticate(r *http.Request) (*Identity, bool)
+	// challenge answers a request without valid credentials
+	challenge(w http.ResponseWriter, r *http.Request)
+}
+
+// authRoutes is implemented by authenticators that serve endpoints of
+// their own, such as a login callback
+type authRoutes interface {
+	// serveAuthRoute handles the request when it is for such an endpoint
+	serveAuthRoute(w http.ResponseWriter, r *http.Request) bool
+}
+
+// authRule is a compiled auth rule
+type authRule struct {
+	paths   []*pathGlob
+	schemes []string // any of them authenticates; the first one challenges
+	users   []string
+	groups  []string
+	claims  map[string]string
+}
+
+// allows reports whether the identity passes the rule's user and group
+// lists (a rule without lists allows any authenticated client) and has
+// the required claims
+func (rule *authRule) allows(id *Identity) bool {
+	for name, want := range rule.claims {
+		if !claimMatches(id.Claims[name], want) {
+			return false
+		}
+	}
+	if len(rule.users) == 0 && len(rule.groups) == 0 {
+		return true
+	}
+	if slices.Contains(rule.users, id.User) {
+		return true
+	}
+	return slices.ContainsFunc(rule.groups, id.InGroup)
+}
+
+// ValidateAuthRules checks public_paths and auth_rules, and that every
+// scheme a rule requires is configured
+func ValidateAuthRules(config *SecurityConfig) error {
+	if err := ValidatePathPatterns(config.PublicPaths); err != nil {
+		return fmt.Errorf("public_paths: %w", err)
+	}
+	rules, err := compileAuthRules(config.AuthRules)
+	if err != nil {
+		return err
+	}
+	checked := make(map[string]bool)
+	for _, rule := range rules {
+		scheme := rule.schemes[0]
+		if checked[scheme] {
+			continue
+		}
+		checked[scheme] = true
+		if err := validateAuthScheme(config, scheme); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileAuthRules(rules []AuthRuleConfig) ([]*authRule, error) {
+	compiled := make([]*authRule, 0, len(rules))
+	for i, rule := range rules {
+		r, err := compileAuthRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("auth rule %d: %w", i+1, err)
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+func compileAuthRule(rule AuthRuleConfig) (*authRule, error) {
+	if len(rule.Paths) == 0 {
+		return nil, fmt.Errorf("no paths specified")
+	}
+	paths, err := compilePathGlobs(rule.Paths)
+	if err != nil {
+		return nil, err
+	}
+	switch rule.Auth {
+	case AuthNone, AuthSigned:
+		if len(rule.Users) > 0 || len(rule.Groups) > 0 || len(rule.Claims) > 0 {
+			return nil, fmt.Errorf("users, groups and claims cannot be used with auth %q", rule.Auth)
+		}
+	case AuthBasic, AuthBearer, AuthOIDC, AuthClientCert:
+	case "":
+		return nil, fmt.Errorf("no auth scheme specified")
+	default:
+		return nil, fmt.Errorf("unknown auth scheme %q", rule.Auth)
+	}
+	return &authRule{
+		paths:   paths,
+		schemes: []string{rule.Auth},
+		users:   rule.Users,
+		groups:  rule.Groups,
+		claims:  rule.Claims,
+	}, nil
+}
+
+// validateAuthScheme checks that the settings a scheme needs are present
+func validateAuthScheme(config *SecurityConfig, scheme string) error {
+	switch scheme {
+	case AuthBasic:
+		if config.BasicAuth == nil {
+			return fmt.Errorf("auth rules require basic auth, but basic_auth is not configured")
+		}
+		return ValidateBasicAuth(config.BasicAuth)
+	case AuthBearer:
+		if config.BearerAuth == nil {
+			return fmt.Errorf("auth rules require bearer auth, but bearer_auth is not configured")
+		}
+		return ValidateBearerAuth(config.BearerAuth)
+	case AuthOIDC:
+		if config.OIDC == nil {
+			return fmt.Errorf("auth rules require oidc, but oidc is not configured")
+		}
+		return ValidateOIDC(config.OIDC)
+	case AuthSigned:
+		if config.SignedURLs == nil {
+			return fmt.Errorf("auth rules require signed URLs, but signed_urls is not configured")
+		}
+		return ValidateSignedURLs(config.SignedURLs)
+	}
+	return nil
+}
+
+// newAuthenticators creates the authenticators of the configured schemes
+func newAuthenticators(config *SecurityConfig, logger *Logger) map[string]authenticator {
+	authenticators := make(map[string]authenticator)
+	if config.BasicAuth != nil {
+		authenticators[AuthBasic] = newBasicAuthenticator(config.BasicAuth, logger)
+	}
+	if config.BearerAuth != nil {
+		authenticators[AuthBearer] = newBearerAuthenticator(config.BearerAuth, logger)
+	}
+	if config.OIDC != nil {
+		authenticators[AuthOIDC] = newOIDCAuthenticator(config.OIDC, logger, authRuleClaims(config.AuthRules, AuthOIDC))
+	}
+	if config.SignedURLs != nil {
+		authenticators[AuthSigned] = newSignedURLAuthenticator(config.SignedURLs, logger)
+	}
+	// Client certificates are verified in the TLS handshake
+	authenticators[AuthClientCert] = clientCertAuthenticator{}
+	return authenticators
+}
+
+// authRuleClaims returns the names of the claims the auth rules of a
+// scheme require
+func authRuleClaims(rules []AuthRuleConfig, scheme string) []string {
+	var names []string
+	for _, rule := range rules {
+		if rule.Auth == scheme {
+			for name := range rule.Claims {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// AuthEnabled reports whether requests may need authentication
+func (s *SecurityConfig) AuthEnabled() bool {
+	return len(s.AuthRules) > 0 || len(s.defaultAuthSchemes()) > 0 ||
+		(s.SignedURLs != nil && s.SignedURLs.Enabled && len(s.SignedURLs.Paths) > 0)
+}
+
+// defaultAuthSchemes returns the enabled schemes, which protect paths
+// without an auth rule
+func (s *SecurityConfig) defaultAuthSchemes() []string {
+	var schemes []string
+	if s.BasicAuth != nil && s.BasicAuth.Enabled {
+		schemes = append(schemes, AuthBasic)
+	}
+	if s.BearerAuth != nil && s.BearerAuth.Enabled {
+		schemes = append(schemes, AuthBearer)
+	}
+	if s.OIDC != nil && s.OIDC.Enabled {
+		schemes = append(schemes, AuthOIDC)
+	}
+	return schemes
+}
+
+// AuthMiddleware authenticates and authorizes requests by path. Public paths
+// are always served; otherwise the first auth rule whose paths match decides
+// the scheme and the users, groups or claims allowed. Paths without a rule
+// accept any enabled scheme (basic_auth, bearer_auth, oidc) and are public
+// when none is enabled. The paths of enabled signed_urls need a signed URL
+// unless an auth rule matches first. Endpoints of a scheme, like the OIDC
+// callback, are served first. Paths are matched with their "." and ".."
+// segments resolved, as they are served.
+// Unauthenticated requests get the scheme's challenge, authenticated ones
+// that a rule does not allow get 403.
+func AuthMiddleware(config *SecurityConfig, logger *Logger) Middleware {
+	public, err := compilePathGlobs(config.PublicPaths)
+	if err != nil {
+		logger.Error("Ignoring public_paths: %v", err)
+		public = nil
+	}
+
+	// An invalid rule fails closed: ignoring it could expose its paths
+	rules, err := compileAuthRules(config.AuthRules)
+	if err == nil {
+		err = ValidateAuthRules(config)
+	}
+	if err != nil {
+		logger.Error("Denying every request, invalid auth rules: %v", err)
+		return denyAll
+	}
+
+	if signed := config.SignedURLs; signed != nil && signed.Enabled && len(signed.Paths) > 0 {
+		paths, err := compilePathGlobs(signed.Paths)
+		if err == nil {
+			err = validateAuthScheme(config, AuthSigned)
+		}
+		if err != nil {
+			logger.Error("Denying every request, invalid signed_urls: %v", err)
+			return denyAll
+		}
+		rules = append(rules, &authRule{paths: paths, schemes: []string{AuthSigned}})
+	}
+
+	var fallback *authRule
+	if schemes := config.defaultAuthSchemes(); len(schemes) > 0 {
+		fallback = &authRule{schemes: schemes}
+	}
+	authenticators := newAuthenticators(config, logger)
+	var routes []authRoutes
+	for _, auth := range authenticators {
+		if r, ok := auth.(authRoutes); ok {
+			routes = append(routes, r)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, route := range routes {
+				if route.serveAuthRoute(w, r) {
+					return
+				}
+			}
+
+			if matchFirstGlob(public, r.URL.Path) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rule := fallback
+			for _, candidate := range rules {
+				if matchFirstGlob(candidate.paths, r.URL.Path) != nil {
+					rule = candidate
+					break
+				}
+			}
+			if rule == nil || rule.schemes[0] == AuthNone {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id := authenticate(authenticators, rule.schemes, r)
+			if id == nil {
+				authenticators[rule.schemes[0]].challenge(w, r)
+				return
+			}
+			if !rule.allows(id) {
+				logger.Debug("User %s denied access to %s", id.User, r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, withIdentity(r, id))
+		})
+	}
+}
+
+// authenticate returns the identity from the first scheme that accepts the
+// request's credentials, or nil
+func authenticate(authenticators map[string]authenticator, schemes []string, r *http.Request) *Identity {
+	for _, scheme := range schemes {
+		if id, ok := authenticators[scheme].authenticate(r); ok {
+			return id
+		}
+	}
+	return nil
+}
+
+// denyAll refuses every request, for security settings that cannot be applied
+func denyAll(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,144 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthMiddlewareRules(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	config := &SecurityConfig{
+		BasicAuth: &BasicAuthConfig{
+			Enabled: true,
+			Users:   map[string]string{"alice": "alice-pw", "bob": "bob-pw", "carol": "carol-pw"},
+			Groups:  map[string][]string{"ops": {"bob"}},
+		},
+		PublicPaths: []string{"/healthz", "/admin/ping"},
+		AuthRules: []AuthRuleConfig{
+			{Paths: []string{"/admin/**"}, Auth: AuthBasic, Users: []string{"alice"}, Groups: []string{"ops"}},
+			{Paths: []string{"/assets/**", "*.css"}, Auth: AuthNone},
+		},
+	}
+
+	var identity *Identity
+	handler := AuthMiddleware(config, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = AuthenticatedIdentity(r)
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		password string
+		status   int
+	}{
+		{"public path", "/healthz", "", "", http.StatusOK},
+		{"public path inside a rule", "/admin/ping", "", "", http.StatusOK},
+		{"rule without auth", "/assets/app.js", "", "", http.StatusOK},
+		{"glob without slash", "/theme/site.css", "", "", http.StatusOK},
+		{"fallback needs auth", "/index.html", "", "", http.StatusUnauthorized},
+		{"fallback accepts any user", "/index.html", "carol", "carol-pw", http.StatusOK},
+		{"allowed user", "/admin/users", "alice", "alice-pw", http.StatusOK},
+		{"allowed group", "/admin/users", "bob", "bob-pw", http.StatusOK},
+		{"other user", "/admin/users", "carol", "carol-pw", http.StatusForbidden},
+		{"wrong password", "/admin/users", "alice", "nope", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity = nil
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected a basic auth challenge")
+			}
+			if tt.status == http.StatusOK && tt.user != "" && (identity == nil || identity.User != tt.user || identity.Method != AuthBasic) {
+				t.Errorf("expected identity of %s, got %+v", tt.user, identity)
+			}
+		})
+	}
+
+	// Groups are carried on the identity
+	req := httptest.NewRequest("GET", "/admin/users", nil)
+	req.SetBasicAuth("bob", "bob-pw")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if identity == nil || !identity.InGroup("ops") {
+		t.Errorf("expected bob in ops, got %+v", identity)
+	}
+}
+
+func TestAuthMiddlewareInvalidRulesFailClosed(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := AuthMiddleware(&SecurityConfig{
+		AuthRules: []AuthRuleConfig{{Paths: []string{"/admin/**"}, Auth: AuthBasic}},
+	}, logger)(testHandler())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without basic auth users, got %d", w.Code)
+	}
+}
+
+func TestAuthMiddlewareCleansPaths(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := AuthMiddleware(&SecurityConfig{
+		BasicAuth:   &BasicAuthConfig{Enabled: true, Users: map[string]string{"alice": "alice-pw"}},
+		PublicPaths: []string{"/public/**"},
+		AuthRules:   []AuthRuleConfig{{Paths: []string{"/assets/**"}, Auth: AuthNone}},
+	}, logger)(testHandler())
+
+	for _, path := range []string{
+		"/public/%2e%2e/admin/secret.txt",
+		"/public/%2E%2E/%2e%2e/admin/secret.txt",
+		"/assets/%2e%2e/admin/secret.txt",
+		"/public/./%2e%2e/admin/secret.txt",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/public/%2e/index.html", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected a public path with a . segment to stay public, got %d", w.Code)
+	}
+}
+
+func TestRuntimeConfigBehindAuthRules(t *testing.T) {
+	config := DefaultConfig()
+	config.Server.RootDir = t.TempDir()
+	config.RuntimeConfig = &RuntimeConfigConfig{Enabled: true}
+	config.Security.BasicAuth = &BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+
+	status := func(config *Config) int {
+		handler, err := NewHandler(config, logger)
+		if err != nil {
+			t.Fatalf("NewHandler failed: %v", err)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/runtime-config.js", nil))
+		return w.Code
+	}
+
+	if code := status(config); code != http.StatusUnauthorized {
+		t.Errorf("expected runtime config behind basic auth, got %d", code)
+	}
+	config.Security.PublicPaths = []string{"/runtime-config.js"}
+	if code := status(config); code != http.StatusOK {
+		t.Errorf("expected public runtime config, got %d", code)
+	}
+}
@@ -0,0 +1,245 @@
+package koryxserv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AutoCertSelfSigned generates a local CA and a certificate it signs
+const AutoCertSelfSigned = "self-signed"
+
+const (
+	// autoCertCAValidity is the lifetime of a generated CA
+	autoCertCAValidity = 10 * 365 * 24 * time.Hour
+	// autoCertValidity stays below the 825 days some platforms accept
+	autoCertValidity = 365 * 24 * time.Hour
+	// autoCertRenewBefore is when a generated certificate is replaced
+	autoCertRenewBefore = 30 * 24 * time.Hour
+)
+
+// autoCertDefaultNames are always in a generated certificate
+var autoCertDefaultNames = []string{"localhost", "127.0.0.1", "::1"}
+
+// AutoCertFiles are the PEM files of a generated CA and certificate
+type AutoCertFiles struct {
+	CAFile   string // trust this file to accept the certificate
+	CertFile string
+	KeyFile  string
+}
+
+// EnsureSelfSignedCert returns a certificate for hostnames (plus
+// localhost, 127.0.0.1 and ::1) signed by a local CA, both kept in dir. The
+// CA is created once and reused; the certificate is reissued when it does
+// not cover every name or is close to expiry.
+func EnsureSelfSignedCert(dir string, hostnames []string) (*AutoCertFiles, error) {
+	files := &AutoCertFiles{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+	names := autoCertNames(hostnames)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	ca, err := loadAutoCertCA(files.CAFile, caKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("auto_cert CA: %w", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("auto_cert CA: %w", err)
+	}
+
+	if autoCertUsable(files, caCert, names) {
+		return files, nil
+	}
+	if err := issueAutoCert(files, caCert, ca.PrivateKey, names); err != nil {
+		return nil, fmt.Errorf("auto_cert certificate: %w", err)
+	}
+	return files, nil
+}
+
+// autoCertNames returns the names of a generated certificate, defaults
+// first, without duplicates
+func autoCertNames(hostnames []string) []string {
+	names := slices.Clone(autoCertDefaultNames)
+	for _, name := range hostnames {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// loadAutoCertCA loads the CA, or creates it when neither of its files
+// exists. A CA missing one of them is an error rather than replaced, since
+// a new CA would silently break every trust store that holds the old one.
+func loadAutoCertCA(certFile, keyFile string) (tls.Certificate, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	switch {
+	case os.IsNotExist(certErr) && os.IsNotExist(keyErr):
+		return createAutoCertCA(certFile, keyFile)
+	case os.IsNotExist(keyErr):
+		return tls.Certificate{}, fmt.Errorf("%s exists but its key %s is missing; restore the key, or remove %s to create a new CA", certFile, keyFile, certFile)
+	case os.IsNotExist(certErr):
+		return tls.Certificate{}, fmt.Errorf("%s exists but its certificate %s is missing; restore the certificate, or remove %s to create a new CA", keyFile, certFile, keyFile)
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// createAutoCertCA generates and writes a CA that may only sign leaf
+// certificates
+func createAutoCertCA(certFile, keyFile string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	name := "koryx-serv local CA"
+	if host, err := os.Hostname(); err == nil {
+		name += " (" + host + ")"
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"koryx-serv"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(autoCertCAValidity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writeAutoCertPair(certFile, keyFile, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// autoCertUsable reports whether the existing certificate was signed by
+// the CA, covers every name and is not close to expiry
+func autoCertUsable(files *AutoCertFiles, ca *x509.Certificate, names []string) bool {
+	cert, err := loadCertificatePair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return false
+	}
+	leaf := cert.Leaf
+	if time.Until(leaf.NotAfter) < autoCertRenewBefore || leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	covered := certificateNames(leaf)
+	for _, name := range names {
+		if !slices.Contains(covered, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// issueAutoCert writes a server certificate for names signed by the CA
+func issueAutoCert(files *AutoCertFiles, ca *x509.Certificate, caKey any, names []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0], Organization: []string{"koryx-serv"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(autoCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeAutoCertPair(files.CertFile, files.KeyFile, der, key)
+}
+
+// writeAutoCertPair writes a certificate and its private key as PEM, the
+// key readable by the owner only
+func writeAutoCertPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// AutoCertHostnames returns the names a generated certificate covers for a
+// configuration: tls.hostnames, the virtual host names (without ports) and
+// the listen address when it is a specific host
+func AutoCertHostnames(config *Config) []string {
+	names := slices.Clone(config.GetTLS().Hostnames)
+	hosts := make([]string, 0, len(config.Hosts))
+	for pattern := range config.Hosts {
+		hosts = append(hosts, normalizeHostPattern(pattern))
+	}
+	slices.Sort(hosts)
+	names = append(names, hosts...)
+	switch host := config.Server.Host; host {
+	case "", "0.0.0.0", "::":
+	default:
+		names = append(names, host)
+	}
+	return autoCertNames(names)
+}
+
+// resolveAutoCert returns the HTTPS settings with the generated certificate
+// added after the configured ones, when auto_cert is set
+func resolveAutoCert(config *Config, logger *Logger) (*TLSConfig, error) {
+	tlsConfig := config.GetTLS()
+	if tlsConfig.AutoCert != AutoCertSelfSigned {
+		return tlsConfig, nil
+	}
+	files, err := EnsureSelfSignedCert(tlsConfig.GetAutoCertDir(), AutoCertHostnames(config))
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Self-signed certificate: trust %s to avoid browser warnings (koryx-serv gen-cert exports it)", files.CAFile)
+
+	resolved := *tlsConfig
+	resolved.Certificates = append(slices.Clone(tlsConfig.Certificates), TLSCertificateConfig{CertFile: files.CertFile, KeyFile: files.KeyFile})
+	return &resolved, nil
+}
@@ -0,0 +1,103 @@
+package koryxserv
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	files, err := EnsureSelfSignedCert(dir, []string{"Dev.Test"})
+	if err != nil {
+		t.Fatalf("EnsureSelfSignedCert failed: %v", err)
+	}
+	if info, err := os.Stat(files.KeyFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected a private key readable by the owner only, got %v", info.Mode())
+	}
+
+	caPEM, _ := os.ReadFile(files.CAFile)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	cert, err := loadCertificatePair(files.CertFile, files.KeyFile)
+	if err != nil {
+		t.Fatalf("generated pair does not load: %v", err)
+	}
+	for _, name := range []string{"dev.test", "localhost", "127.0.0.1", "::1"} {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			t.Errorf("certificate not valid for %s: %v", name, err)
+		}
+	}
+
+	// Later starts reuse both
+	certPEM, _ := os.ReadFile(files.CertFile)
+	if _, err := EnsureSelfSignedCert(dir, []string{"dev.test"}); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(files.CertFile); !bytes.Equal(again, certPEM) {
+		t.Error("expected the certificate to be reused")
+	}
+
+	// A new name reissues the certificate from the same CA
+	if _, err := EnsureSelfSignedCert(dir, []string{"dev.test", "api.dev.test"}); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(files.CertFile); bytes.Equal(again, certPEM) {
+		t.Error("expected the certificate to be reissued for a new name")
+	}
+	if again, _ := os.ReadFile(files.CAFile); !bytes.Equal(again, caPEM) {
+		t.Error("expected the CA to be kept")
+	}
+	cert, _ = loadCertificatePair(files.CertFile, files.KeyFile)
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "api.dev.test", Roots: roots}); err != nil {
+		t.Errorf("reissued certificate not valid for api.dev.test: %v", err)
+	}
+}
+
+func TestEnsureSelfSignedCertMissingCAKey(t *testing.T) {
+	dir := t.TempDir()
+	files, err := EnsureSelfSignedCert(dir, nil)
+	if err != nil {
+		t.Fatalf("EnsureSelfSignedCert failed: %v", err)
+	}
+	caPEM, _ := os.ReadFile(files.CAFile)
+	os.Remove(filepath.Join(dir, "ca-key.pem"))
+
+	if _, err := EnsureSelfSignedCert(dir, []string{"dev.test"}); err == nil {
+		t.Error("expected an error when the CA key is missing")
+	}
+	if again, _ := os.ReadFile(files.CAFile); !bytes.Equal(again, caPEM) {
+		t.Error("expected the CA not to be replaced")
+	}
+
+	// Removing the CA certificate as well creates a new CA
+	os.Remove(files.CAFile)
+	if _, err := EnsureSelfSignedCert(dir, []string{"dev.test"}); err != nil {
+		t.Errorf("expected a new CA, got %v", err)
+	}
+}
+
+func TestAutoCertHostnames(t *testing.T) {
+	config := DefaultConfig()
+	config.Security.EnableHTTPS = true
+	config.Security.AutoCert = AutoCertSelfSigned
+	config.Server.Host = "192.168.1.20"
+	config.Hosts = map[string]*HostConfig{"Docs.Dev.Test:8443": {}, "*.apps.dev.test": {}, "docs.dev.test": {}}
+
+	got := AutoCertHostnames(config)
+	want := []string{"localhost", "127.0.0.1", "::1", "*.apps.dev.test", "docs.dev.test", "192.168.1.20"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	if tls := config.GetTLS(); len(tls.Certificates) != 0 || tls.AutoCert != AutoCertSelfSigned {
+		t.Errorf("expected auto_cert without certificate files, got %+v", tls)
+	}
+}
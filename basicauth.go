@@ -0,0 +1,231 @@
+package koryxserv
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authCacheSize bounds the remembered successful logins. Hash checks are
+// slow by design, so repeated requests with the same credentials skip them.
+const authCacheSize = 1024
+
+// userStore holds basic auth users from the configuration and an optional
+// htpasswd file, which is reloaded when it changes
+type userStore struct {
+	static map[string]string   // username -> password (plaintext or hash)
+	groups map[string][]string // username -> groups
+	file   *watchedFile
+	logger *Logger
+
+	users atomic.Pointer[map[string]string]
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+// dummyHash is compared against for unknown users, so that a failed login
+// takes as long whether or not the user exists
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("koryx-serv"), bcrypt.DefaultCost)
+	return string(hash)
+})
+
+func newUserStore(config *BasicAuthConfig, logger *Logger) *userStore {
+	store := &userStore{
+		static:   make(map[string]string),
+		groups:   make(map[string][]string),
+		logger:   logger,
+		verified: make(map[[sha256.Size]byte]struct{}),
+	}
+	if config.Username != "" {
+		store.static[config.Username] = config.Password
+	}
+	for username, password := range config.Users {
+		store.static[username] = password
+	}
+	for username, password := range store.static {
+		if err := ValidatePasswordHash(password); err != nil {
+			logger.Error("Ignoring basic auth user %s: %v", username, err)
+			delete(store.static, username)
+		}
+	}
+
+	for group, members := range config.Groups {
+		for _, username := range members {
+			store.groups[username] = append(store.groups[username], group)
+		}
+	}
+
+	store.users.Store(&store.static)
+	if config.HtpasswdFile != "" {
+		store.file = newWatchedFile(config.HtpasswdFile)
+		store.file.poll(store.load)
+	}
+	return store
+}
+
+// load merges the htpasswd file over the configured users. A file that
+// cannot be read keeps the previous users; invalid lines are skipped.
+func (u *userStore) load(data []byte, err error) {
+	if err != nil {
+		u.logger.Error("Keeping previous htpasswd users: %v", err)
+		return
+	}
+	fileUsers, errs := parseHtpasswd(string(data))
+	for _, err := range errs {
+		u.logger.Warn("Skipping %s %v", u.file.path, err)
+	}
+
+	users := make(map[string]string, len(u.static)+len(fileUsers))
+	for username, password := range u.static {
+		users[username] = password
+	}
+	for username, password := range fileUsers {
+		users[username] = password
+	}
+	u.users.Store(&users)
+	u.logger.Info("Loaded %d users from %s", len(fileUsers), u.file.path)
+}
+
+// authenticate checks a username and password against the store
+func (u *userStore) authenticate(username, password string) bool {
+	if u.file != nil {
+		u.file.poll(u.load)
+	}
+
+	stored, ok := (*u.users.Load())[username]
+	if !ok {
+		verifyPassword(dummyHash(), password)
+		return false
+	}
+
+	// The stored value is part of the key, so changed passwords miss
+	key := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + stored))
+	u.mu.Lock()
+	_, cached := u.verified[key]
+	u.mu.Unlock()
+	if cached {
+		return true
+	}
+
+	if !verifyPassword(stored, password) {
+		return false
+	}
+	u.mu.Lock()
+	if len(u.verified) >= authCacheSize {
+		clear(u.verified)
+	}
+	u.verified[key] = struct{}{}
+	u.mu.Unlock()
+	return true
+}
+
+// parseHtpasswd parses "user:password" lines; blank lines and "#" comments
+// are ignored
+func parseHtpasswd(data string) (map[string]string, []error) {
+	users := make(map[string]string)
+	var errs []error
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, password, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			errs = append(errs, fmt.Errorf("line %d: expected user:password", i+1))
+			continue
+		}
+		if err := ValidatePasswordHash(password); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		users[username] = password
+	}
+	return users, errs
+}
+
+// ValidateBasicAuth checks that basic auth has at least one user and that
+// every password hash, inline or in the htpasswd file, is supported
+func ValidateBasicAuth(config *BasicAuthConfig) error {
+	if (config.Username == "" || config.Password == "") && len(config.Users) == 0 && config.HtpasswdFile == "" {
+		return fmt.Errorf("basic auth enabled but no username/password, users or htpasswd_file specified")
+	}
+	if config.Username != "" {
+		if err := ValidatePasswordHash(config.Password); err != nil {
+			return fmt.Errorf("basic auth password: %w", err)
+		}
+	}
+	for username, password := range config.Users {
+		if err := ValidatePasswordHash(password); err != nil {
+			return fmt.Errorf("basic auth user %s: %w", username, err)
+		}
+	}
+	if config.HtpasswdFile != "" {
+		data, err := os.ReadFile(config.HtpasswdFile)
+		if err != nil {
+			return fmt.Errorf("htpasswd_file: %w", err)
+		}
+		if _, errs := parseHtpasswd(string(data)); len(errs) > 0 {
+			return fmt.Errorf("htpasswd_file %s %w", config.HtpasswdFile, errs[0])
+		}
+	}
+	return nil
+}
+
+// basicAuthenticator checks HTTP basic credentials against a user store
+type basicAuthenticator struct {
+	store *userStore
+	realm string
+}
+
+func newBasicAuthenticator(config *BasicAuthConfig, logger *Logger) *basicAuthenticator {
+	realm := config.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	return &basicAuthenticator{store: newUserStore(config, logger), realm: realm}
+}
+
+func (a *basicAuthenticator) authenticate(r *http.Request) (*Identity, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok || !a.store.authenticate(username, password) {
+		return nil, false
+	}
+	return &Identity{User: username, Groups: a.store.groups[username], Method: AuthBasic}, true
+}
+
+func (a *basicAuthenticator) challenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+a.realm+`"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// BasicAuthMiddleware adds basic authentication against the configured
+// users and htpasswd file to every request. The identity is stored on the
+// request context; AuthMiddleware applies it per path instead.
+func BasicAuthMiddleware(config *BasicAuthConfig, logger *Logger) Middleware {
+	auth := newBasicAuthenticator(config, logger)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !config.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id, ok := auth.authenticate(r)
+			if !ok {
+				auth.challenge(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, withIdentity(r, id))
+		})
+	}
+}
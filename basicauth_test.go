@@ -0,0 +1,103 @@
+package koryxserv
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthUsersAndHtpasswd(t *testing.T) {
+	aliceHash, _ := bcrypt.GenerateFromPassword([]byte("alice-pw"), bcrypt.MinCost)
+	htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+	os.WriteFile(htpasswd, []byte("# users\nbob:$apr1$r31.....$G/cElGhD0cboYkZN5h5Ne/\ncarol:$9$unsupported\n"), 0o644)
+
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	var user string
+	handler := BasicAuthMiddleware(&BasicAuthConfig{
+		Enabled:      true,
+		Users:        map[string]string{"alice": string(aliceHash)},
+		HtpasswdFile: htpasswd,
+	}, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = AuthenticatedUser(r)
+	}))
+
+	status := func(username, password string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status("alice", "alice-pw") != http.StatusOK || user != "alice" {
+		t.Errorf("expected alice to log in, got user %q", user)
+	}
+	if status("alice", "alice-pw") != http.StatusOK {
+		t.Errorf("expected remembered credentials to log in again")
+	}
+	if status("bob", "secret") != http.StatusOK || user != "bob" {
+		t.Errorf("expected bob from the htpasswd file to log in")
+	}
+	if status("carol", "") != http.StatusUnauthorized || status("mallory", "x") != http.StatusUnauthorized {
+		t.Errorf("expected unknown users and unsupported hashes to be refused")
+	}
+
+	// bob's password changes in the file
+	later := time.Now().Add(time.Hour)
+	os.WriteFile(htpasswd, []byte("bob:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5\n"), 0o644)
+	os.Chtimes(htpasswd, later, later)
+	time.Sleep(watchedFileCheckInterval + 50*time.Millisecond)
+
+	if status("bob", "secret") != http.StatusUnauthorized {
+		t.Errorf("expected the old password to stop working after reload")
+	}
+	if status("bob", "Hello world!") != http.StatusOK {
+		t.Errorf("expected the new password to work after reload")
+	}
+	if status("alice", "alice-pw") != http.StatusOK {
+		t.Errorf("expected configured users to survive a reload")
+	}
+}
+
+func TestAccessLogIncludesUser(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	logger, err := NewLogger(&LoggingConfig{Enabled: true, AccessLog: true, LogFile: logFile})
+	if err != nil {
+		t.Fatalf("NewLogger failed: %v", err)
+	}
+
+	handler := Chain(testHandler(),
+		LoggingMiddleware(logger),
+		BasicAuthMiddleware(&BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"}, logger),
+	)
+	req := httptest.NewRequest("GET", "/report", nil)
+	req.SetBasicAuth("admin", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, _ := os.ReadFile(logFile)
+	if line := string(bytes.TrimSpace(data)); !strings.HasSuffix(line, " - admin") {
+		t.Errorf("expected the username in the access log, got %q", line)
+	}
+}
+
+func TestValidateBasicAuth(t *testing.T) {
+	if err := ValidateBasicAuth(&BasicAuthConfig{Enabled: true}); err == nil {
+		t.Errorf("expected error without users")
+	}
+	if err := ValidateBasicAuth(&BasicAuthConfig{Enabled: true, Users: map[string]string{"a": "$2y$broken"}}); err == nil {
+		t.Errorf("expected error for a malformed hash")
+	}
+
+	htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+	os.WriteFile(htpasswd, []byte("admin:$apr1$r31.....$G/cElGhD0cboYkZN5h5Ne/\nbroken\n"), 0o644)
+	if err := ValidateBasicAuth(&BasicAuthConfig{Enabled: true, HtpasswdFile: htpasswd}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected htpasswd line error, got %v", err)
+	}
+}
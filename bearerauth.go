@@ -0,0 +1,232 @@
+package koryxserv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// bearerAuthenticator checks JWTs from the Authorization header or a
+// cookie against a shared secret and the keys of a JWKS file, which is
+// reloaded when it changes
+type bearerAuthenticator struct {
+	config *BearerAuthConfig
+	logger *Logger
+	realm  string
+
+	secret []jwtKey
+	jwks   *watchedFile
+	keys   atomic.Pointer[[]jwtKey]
+}
+
+func newBearerAuthenticator(config *BearerAuthConfig, logger *Logger) *bearerAuthenticator {
+	auth := &bearerAuthenticator{config: config, logger: logger, realm: config.Realm}
+	if auth.realm == "" {
+		auth.realm = "Restricted"
+	}
+	if config.Secret != "" {
+		auth.secret = []jwtKey{{key: []byte(config.Secret)}}
+	}
+	auth.keys.Store(&auth.secret)
+	if config.JWKSFile != "" {
+		auth.jwks = newWatchedFile(config.JWKSFile)
+		auth.jwks.poll(auth.load)
+	}
+	return auth
+}
+
+// load replaces the JWKS keys; a file that cannot be read or parsed keeps
+// the previous keys
+func (a *bearerAuthenticator) load(data []byte, err error) {
+	if err == nil {
+		var keys []jwtKey
+		if keys, err = parseJWKS(data); err == nil {
+			a.logger.Info("Loaded %d keys from %s", len(keys), a.jwks.path)
+			keys = append(keys, a.secret...)
+			a.keys.Store(&keys)
+			return
+		}
+	}
+	a.logger.Error("Keeping previous JWKS keys: %v", err)
+}
+
+// token returns the bearer token of the request, from the Authorization
+// header or else the configured cookie
+func (a *bearerAuthenticator) token(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if a.config.Cookie != "" {
+		if cookie, err := r.Cookie(a.config.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (a *bearerAuthenticator) authenticate(r *http.Request) (*Identity, bool) {
+	token := a.token(r)
+	if token == "" {
+		return nil, false
+	}
+	if a.jwks != nil {
+		a.jwks.poll(a.load)
+	}
+
+	claims, err := verifyJWT(token, *a.keys.Load())
+	if err == nil {
+		leeway := time.Duration(a.config.Leeway) * time.Second
+		err = checkTokenClaims(claims, a.config.Issuer, a.config.Audience, a.config.RequiredClaims, leeway, time.Now())
+	}
+	if err != nil {
+		a.logger.Debug("Rejected bearer token: %v", err)
+		return nil, false
+	}
+
+	return &Identity{
+		User:   claimString(claims[a.config.GetUserClaim()]),
+		Groups: claimStrings(claims[a.config.GetGroupsClaim()]),
+		Method: AuthBearer,
+		Claims: claims,
+	}, true
+}
+
+// checkTokenClaims checks expiry, issuer, audience and required claims of
+// a verified token; leeway allows for clock skew
+func checkTokenClaims(claims map[string]any, issuer, audience string, required map[string]string, leeway time.Duration, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not valid yet")
+	}
+
+	if issuer != "" && claims["iss"] != issuer {
+		return fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if audience != "" && !claimMatches(claims["aud"], audience) {
+		return fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	for name, want := range required {
+		if !claimMatches(claims[name], want) {
+			return fmt.Errorf("claim %s does not match", name)
+		}
+	}
+	return nil
+}
+
+func (a *bearerAuthenticator) challenge(w http.ResponseWriter, r *http.Request) {
+	value := `Bearer realm="` + a.realm + `"`
+	if a.token(r) != "" {
+		value += `, error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", value)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// claimMatches reports whether a claim has the wanted value, or contains
+// it when the claim is a list. An empty want only requires the claim.
+func claimMatches(claim any, want string) bool {
+	if claim == nil {
+		return false
+	}
+	if want == "" || claim == want {
+		return true
+	}
+	for _, value := range claimStrings(claim) {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// claimString formats a scalar claim
+func claimString(claim any) string {
+	switch v := claim.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return fmt.Sprint(int64(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// claimStrings returns the values of a list claim; a string claim is split
+// on spaces, like OAuth scopes
+func claimStrings(claim any) []string {
+	switch v := claim.(type) {
+	case nil:
+		return nil
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, claimString(item))
+		}
+		return values
+	default:
+		return []string{claimString(v)}
+	}
+}
+
+// ValidateBearerAuth checks that bearer auth has a secret of at least 32
+// bytes or a JWKS file with signature keys
+func ValidateBearerAuth(config *BearerAuthConfig) error {
+	if config.Secret == "" && config.JWKSFile == "" {
+		return fmt.Errorf("bearer auth enabled but no secret or jwks_file specified")
+	}
+	if config.Secret != "" && len(config.Secret) < 32 {
+		return fmt.Errorf("bearer auth secret must be at least 32 bytes")
+	}
+	if config.Leeway < 0 {
+		return fmt.Errorf("invalid bearer auth leeway: %d", config.Leeway)
+	}
+	if config.JWKSFile != "" {
+		data, err := os.ReadFile(config.JWKSFile)
+		if err != nil {
+			return fmt.Errorf("jwks_file: %w", err)
+		}
+		if _, err := parseJWKS(data); err != nil {
+			return fmt.Errorf("jwks_file %s: %w", config.JWKSFile, err)
+		}
+	}
+	return nil
+}
+
+// BearerAuthMiddleware requires a valid JWT on every request. The identity
+// and verified claims are stored on the request context; AuthMiddleware
+// applies it per path instead.
+func BearerAuthMiddleware(config *BearerAuthConfig, logger *Logger) Middleware {
+	auth := newBearerAuthenticator(config, logger)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !config.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id, ok := auth.authenticate(r)
+			if !ok {
+				auth.challenge(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, withIdentity(r, id))
+		})
+	}
+}
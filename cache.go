@@ -0,0 +1,227 @@
+package koryxserv
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Cache-Control sent with error responses, whatever the path rules say
+const (
+	clientErrorCacheControl = "no-cache"
+	serverErrorCacheControl = "no-store"
+)
+
+// cacheRule is a compiled CacheRuleConfig
+type cacheRule struct {
+	paths        []*pathGlob
+	regex        *regexp.Regexp
+	contentTypes []string
+	value        string
+}
+
+// cachePolicy picks the Cache-Control value for a response
+type cachePolicy struct {
+	rules        []*cacheRule
+	defaultValue string
+}
+
+// ValidateCacheRules checks that every cache rule compiles
+func ValidateCacheRules(rules []CacheRuleConfig) error {
+	_, err := compileCacheRules(rules)
+	return err
+}
+
+func compileCacheRules(rules []CacheRuleConfig) ([]*cacheRule, error) {
+	compiled := make([]*cacheRule, 0, len(rules))
+	for i := range rules {
+		rule, err := compileCacheRule(&rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("cache rule %d: %w", i+1, err)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+func compileCacheRule(config *CacheRuleConfig) (*cacheRule, error) {
+	paths, err := compilePathGlobs(config.Paths)
+	if err != nil {
+		return nil, err
+	}
+	rule := &cacheRule{paths: paths, value: config.HeaderValue()}
+
+	if config.Regex != "" {
+		rule.regex, err = regexp.Compile(config.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", config.Regex, err)
+		}
+	}
+	for _, pattern := range config.ContentTypes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid content type pattern %q: %w", pattern, err)
+		}
+		rule.contentTypes = append(rule.contentTypes, pattern)
+	}
+
+	if rule.value == "" {
+		return nil, fmt.Errorf("cache_control, max_age, no_cache or no_store is required")
+	}
+	return rule, nil
+}
+
+// HeaderValue returns the Cache-Control value described by the rule
+func (c *CacheRuleConfig) HeaderValue() string {
+	if c.CacheControl != "" {
+		return c.CacheControl
+	}
+	if c.NoStore {
+		return "no-store"
+	}
+	if c.MaxAge == nil && !c.NoCache {
+		return ""
+	}
+
+	directives := []string{"public"}
+	if c.Private {
+		directives[0] = "private"
+	}
+	if c.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	if c.MaxAge != nil {
+		directives = append(directives, "max-age="+strconv.Itoa(*c.MaxAge))
+	}
+	if c.SMaxAge != nil && !c.Private {
+		directives = append(directives, "s-maxage="+strconv.Itoa(*c.SMaxAge))
+	}
+	if c.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+	if c.StaleWhileRevalidate > 0 {
+		directives = append(directives, "stale-while-revalidate="+strconv.Itoa(c.StaleWhileRevalidate))
+	}
+	if c.StaleIfError > 0 {
+		directives = append(directives, "stale-if-error="+strconv.Itoa(c.StaleIfError))
+	}
+	if c.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// match reports whether the rule applies; every configured condition must hold
+func (rule *cacheRule) match(urlPath, contentType string) bool {
+	if len(rule.paths) > 0 && matchFirstGlob(rule.paths, urlPath) == nil {
+		return false
+	}
+	if rule.regex != nil && !rule.regex.MatchString(urlPath) {
+		return false
+	}
+	if len(rule.contentTypes) > 0 {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		for _, pattern := range rule.contentTypes {
+			if ok, _ := path.Match(pattern, mediaType); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// value returns the Cache-Control for a response, or "" to leave it alone
+func (p *cachePolicy) value(urlPath, contentType string, status int) string {
+	switch {
+	case status >= 500:
+		return serverErrorCacheControl
+	case status >= 400:
+		return clientErrorCacheControl
+	case status >= 300 && status != http.StatusNotModified:
+		return ""
+	}
+
+	for _, rule := range p.rules {
+		if rule.match(urlPath, contentType) {
+			return rule.value
+		}
+	}
+	return p.defaultValue
+}
+
+// CacheMiddleware sets Cache-Control once the response status and
+// Content-Type are known. The first matching cache rule wins, other
+// successful responses get "public, max-age=cache_max_age", client errors
+// get no-cache and server errors no-store. A Cache-Control set further
+// down the chain (e.g. by _headers) is left alone.
+func CacheMiddleware(config *PerformanceConfig, logger *Logger) Middleware {
+	rules, err := compileCacheRules(config.CacheRules)
+	if err != nil {
+		logger.Error("Ignoring cache_rules: %v", err)
+		rules = nil
+	}
+
+	policy := &cachePolicy{rules: rules}
+	if config.CacheMaxAge > 0 {
+		policy.defaultValue = fmt.Sprintf("public, max-age=%d", config.CacheMaxAge)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &cacheResponseWriter{
+				ResponseWriter: w,
+				policy:         policy,
+				path:           r.URL.Path,
+				initial:        w.Header().Get("Cache-Control"),
+			}
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// cacheResponseWriter applies the cache policy when the header is written
+type cacheResponseWriter struct {
+	http.ResponseWriter
+	policy      *cachePolicy
+	path        string
+	initial     string // Cache-Control before the handler ran
+	wroteHeader bool
+}
+
+func (w *cacheResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		header := w.Header()
+		if header.Get("Cache-Control") == w.initial {
+			if value := w.policy.value(w.path, header.Get("Content-Type"), code); value != "" {
+				header.Set("Cache-Control", value)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *cacheResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
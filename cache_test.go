@@ -0,0 +1,106 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheRuleHeaderValue(t *testing.T) {
+	year, minute := 31536000, 60
+	tests := []struct {
+		rule CacheRuleConfig
+		want string
+	}{
+		{CacheRuleConfig{MaxAge: &year, Immutable: true}, "public, max-age=31536000, immutable"},
+		{CacheRuleConfig{NoCache: true}, "public, no-cache"},
+		{CacheRuleConfig{NoStore: true, MaxAge: &year}, "no-store"},
+		{CacheRuleConfig{Private: true, MaxAge: &minute, SMaxAge: &year}, "private, max-age=60"},
+		{CacheRuleConfig{MaxAge: &minute, SMaxAge: &year, StaleWhileRevalidate: 30, StaleIfError: 600}, "public, max-age=60, s-maxage=31536000, stale-while-revalidate=30, stale-if-error=600"},
+		{CacheRuleConfig{CacheControl: "no-transform", MaxAge: &year}, "no-transform"},
+		{CacheRuleConfig{Immutable: true}, ""},
+	}
+
+	for _, test := range tests {
+		if got := test.rule.HeaderValue(); got != test.want {
+			t.Errorf("expected %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestCacheMiddlewareRules(t *testing.T) {
+	year := 31536000
+	config := &PerformanceConfig{
+		CacheMaxAge: 3600,
+		CacheRules: []CacheRuleConfig{
+			{Regex: `\.[0-9a-f]{8}\.(js|css)$`, MaxAge: &year, Immutable: true},
+			{ContentTypes: []string{"text/html"}, NoCache: true},
+			{Paths: []string{"/private/**"}, Private: true, MaxAge: &year},
+		},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	middleware := CacheMiddleware(config, logger)
+
+	respond := func(status int, contentType string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(status)
+		})
+	}
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		want        string
+	}{
+		{"/assets/app.1a2b3c4d.js", http.StatusOK, "text/javascript", "public, max-age=31536000, immutable"},
+		{"/assets/app.1a2b3c4d.js", http.StatusNotModified, "", "public, max-age=31536000, immutable"},
+		{"/dashboard", http.StatusOK, "text/html; charset=utf-8", "public, no-cache"},
+		{"/private/report.pdf", http.StatusOK, "application/pdf", "private, max-age=31536000"},
+		{"/logo.png", http.StatusOK, "image/png", "public, max-age=3600"},
+		{"/assets/missing.1a2b3c4d.js", http.StatusNotFound, "text/plain", "no-cache"},
+		{"/boom", http.StatusInternalServerError, "text/plain", "no-store"},
+		{"/moved", http.StatusFound, "", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		middleware(respond(test.status, test.contentType)).ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if got := w.Header().Get("Cache-Control"); got != test.want {
+			t.Errorf("%s (%d): expected %q, got %q", test.path, test.status, test.want, got)
+		}
+	}
+
+	t.Run("HandlerValueWins", func(t *testing.T) {
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write([]byte("data"))
+		}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/logo.png", nil))
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("expected handler Cache-Control to be kept, got %q", got)
+		}
+	})
+}
+
+func TestValidateCacheRules(t *testing.T) {
+	hour := 3600
+	valid := []CacheRuleConfig{{Paths: []string{"/assets/**"}, MaxAge: &hour}}
+	if err := ValidateCacheRules(valid); err != nil {
+		t.Fatalf("expected valid rules, got %v", err)
+	}
+
+	invalid := [][]CacheRuleConfig{
+		{{Paths: []string{"/assets/**"}}},
+		{{Regex: "(", MaxAge: &hour}},
+		{{Paths: []string{"/[a"}, MaxAge: &hour}},
+		{{ContentTypes: []string{"text/[x"}, NoCache: true}},
+	}
+	for i, rules := range invalid {
+		if err := ValidateCacheRules(rules); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package koryxserv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// certExpiryWarning is how close to expiry a loaded certificate is logged
+// as a warning
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// certReloader holds a certificate pair and reloads it when either file
+// changes (checked on poll, at most once per watchedFileCheckInterval) or
+// when reload is called. A new pair only replaces the current one once it
+// loads and validates, so a rotation caught halfway keeps the old
+// certificate until both files are written.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *Logger
+
+	cert    atomic.Pointer[tls.Certificate]
+	checked atomic.Int64 // unix nanoseconds of the last check
+
+	mu     sync.Mutex
+	stamps [2]fileStamp // certFile, keyFile
+}
+
+// newCertReloader loads the initial pair; it fails if the pair is invalid
+func newCertReloader(certFile, keyFile string, logger *Logger) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	c.checked.Store(time.Now().UnixNano())
+	return c, nil
+}
+
+// poll reloads the pair when either file's size or modification time changed
+func (c *certReloader) poll() {
+	now := time.Now().UnixNano()
+	if now-c.checked.Load() < int64(watchedFileCheckInterval) {
+		return
+	}
+
+	c.mu.Lock()
+	if now-c.checked.Load() < int64(watchedFileCheckInterval) {
+		c.mu.Unlock()
+		return
+	}
+	c.checked.Store(now)
+	changed := c.currentStamps() != c.stamps
+	c.mu.Unlock()
+
+	if changed {
+		if err := c.reload(); err != nil {
+			c.logger.Error("Keeping the current TLS certificate: %v", err)
+		}
+	}
+}
+
+// currentStamps stats both files
+func (c *certReloader) currentStamps() [2]fileStamp {
+	var stamps [2]fileStamp
+	for i, path := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			stamps[i] = fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return stamps
+}
+
+// reload loads and validates the pair, then swaps it in. On error the
+// current certificate stays in use.
+func (c *certReloader) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Record the stamps first: a failed pair is not retried until a file
+	// changes again
+	c.stamps = c.currentStamps()
+
+	cert, err := loadCertificatePair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(cert)
+
+	leaf := cert.Leaf
+	names := strings.Join(certificateNames(leaf), ", ")
+	if remaining := time.Until(leaf.NotAfter); remaining < certExpiryWarning {
+		c.logger.Warn("Loaded TLS certificate for %s, expires %s (in %d days)",
+			names, leaf.NotAfter.Format(time.RFC3339), int(remaining.Hours()/24))
+	} else {
+		c.logger.Info("Loaded TLS certificate for %s, expires %s",
+			names, leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadCertificatePair reads a PEM certificate chain and its private key,
+// checking that they match and that the certificate has not expired
+func loadCertificatePair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s and %s: %w", certFile, keyFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", certFile, err)
+		}
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate %s expired on %s", certFile, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return &cert, nil
+}
+
+// certificateNames returns the DNS and IP names of a certificate, or its
+// common name when it has none
+func certificateNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
@@ -0,0 +1,126 @@
+package koryxserv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for name and its key as
+// PEM files
+func writeTestCert(t *testing.T, certFile, keyFile, name string, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touchCerts moves the file times forward and expires the poll interval, so
+// the next handshake sees the change
+func touchCerts(t *testing.T, c *certReloader, step int) {
+	t.Helper()
+	at := time.Now().Add(time.Duration(step) * time.Minute)
+	for _, path := range []string{c.certFile, c.keyFile} {
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.checked.Store(0)
+}
+
+func currentCertName(t *testing.T, c *certReloader) string {
+	t.Helper()
+	c.poll()
+	return c.cert.Load().Leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "old.test", time.Now().Add(90*24*time.Hour))
+
+	c, err := newCertReloader(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatalf("newCertReloader failed: %v", err)
+	}
+	if got := currentCertName(t, c); got != "old.test" {
+		t.Fatalf("expected old.test, got %s", got)
+	}
+
+	// A rotated pair is picked up on the next handshake
+	writeTestCert(t, certFile, keyFile, "new.test", time.Now().Add(90*24*time.Hour))
+	touchCerts(t, c, 1)
+	if got := currentCertName(t, c); got != "new.test" {
+		t.Fatalf("expected new.test after rotation, got %s", got)
+	}
+
+	// A certificate without its key (rotation caught halfway) is not used
+	certPEM, _ := os.ReadFile(certFile)
+	writeTestCert(t, certFile, keyFile, "half.test", time.Now().Add(90*24*time.Hour))
+	os.WriteFile(certFile, certPEM, 0o644)
+	touchCerts(t, c, 2)
+	if got := currentCertName(t, c); got != "new.test" {
+		t.Errorf("expected mismatched pair to be rejected, got %s", got)
+	}
+
+	// Neither is an expired certificate
+	writeTestCert(t, certFile, keyFile, "expired.test", time.Now().Add(-time.Hour))
+	if err := c.reload(); err == nil {
+		t.Error("expected expired certificate to be rejected")
+	}
+	if got := currentCertName(t, c); got != "new.test" {
+		t.Errorf("expected expired certificate to be kept out, got %s", got)
+	}
+
+	// An explicit reload picks up a valid pair even with unchanged stamps
+	writeTestCert(t, certFile, keyFile, "reloaded.test", time.Now().Add(24*time.Hour))
+	if err := c.reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if got := currentCertName(t, c); got != "reloaded.test" {
+		t.Errorf("expected reloaded.test, got %s", got)
+	}
+}
+
+func TestNewCertReloaderRejectsInvalidPair(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	dir := t.TempDir()
+	writeTestCert(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "a.test", time.Now().Add(time.Hour))
+	writeTestCert(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.key"), "b.test", time.Now().Add(time.Hour))
+	if _, err := newCertReloader(filepath.Join(dir, "a.crt"), filepath.Join(dir, "b.key"), logger); err == nil {
+		t.Error("expected mismatched key to be rejected")
+	}
+}
@@ -0,0 +1,113 @@
+package koryxserv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Client certificate modes
+const (
+	ClientAuthOptional = "optional" // certificates are verified when presented
+	ClientAuthRequire  = "require"  // the handshake fails without a valid certificate
+)
+
+// clientCertIdentity returns the identity of a request's verified client
+// certificate: its SPIFFE ID, or else its subject CN, as the user, its
+// organizational units as groups, and cn, san and spiffe_id claims for
+// auth rules
+func clientCertIdentity(r *http.Request) (*Identity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	user := cert.Subject.CommonName
+	claims := map[string]any{"cn": cert.Subject.CommonName}
+	var sans []any
+	for _, name := range cert.DNSNames {
+		sans = append(sans, name)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+		if uri.Scheme == "spiffe" && claims["spiffe_id"] == nil {
+			claims["spiffe_id"] = uri.String()
+			user = uri.String()
+		}
+	}
+	claims["san"] = sans
+
+	return &Identity{
+		User:   user,
+		Groups: cert.Subject.OrganizationalUnit,
+		Method: AuthClientCert,
+		Claims: claims,
+	}, true
+}
+
+// clientCertAuthenticator accepts requests made with a verified client
+// certificate
+type clientCertAuthenticator struct{}
+
+func (clientCertAuthenticator) authenticate(r *http.Request) (*Identity, bool) {
+	return clientCertIdentity(r)
+}
+
+// challenge refuses the request; a certificate can only be sent during
+// the handshake
+func (clientCertAuthenticator) challenge(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
+
+// ClientCertMiddleware records the verified client certificate of HTTPS
+// requests as their identity, for the access log and handlers. Auth rules
+// with auth "client_cert" decide which certificates may access a path.
+func ClientCertMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id, ok := clientCertIdentity(r); ok {
+				r = withIdentity(r, id)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// configureClientAuth asks for client certificates signed by the CA bundle
+func configureClientAuth(tlsConfig *tls.Config, config *ClientAuthConfig) error {
+	pool, err := loadCertPool(config.CAFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig.ClientCAs = pool
+	switch config.Mode {
+	case ClientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("invalid client_auth mode %q (must be %q or %q)", config.Mode, ClientAuthOptional, ClientAuthRequire)
+	}
+	return nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("client_auth ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("client_auth ca_file %s contains no certificates", file)
+	}
+	return pool, nil
+}
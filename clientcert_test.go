@@ -0,0 +1,193 @@
+package koryxserv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a client certificate signed by the CA
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newClientCertServer serves AuthenticatedUser over HTTPS with client
+// certificates verified against ca
+func newClientCertServer(t *testing.T, ca *testCA, mode string, security *SecurityConfig) *httptest.Server {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, ca.pem, 0o644)
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(AuthenticatedUser(r)))
+	}), ClientCertMiddleware(), AuthMiddleware(security, logger))
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{}
+	if err := configureClientAuth(server.TLS, &ClientAuthConfig{Mode: mode, CAFile: caFile}); err != nil {
+		t.Fatalf("configureClientAuth failed: %v", err)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getWithCert fetches a path, presenting cert when it is set
+func getWithCert(server *httptest.Server, path string, cert *tls.Certificate) (int, string, error) {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if cert != nil {
+		// Present the certificate even when its CA is not one the server asks for
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return cert, nil }
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	resp, err := client.Get(server.URL + path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body := make([]byte, 256)
+	n, _ := resp.Body.Read(body)
+	return resp.StatusCode, string(body[:n]), nil
+}
+
+func TestClientCertAuth(t *testing.T) {
+	ca := newTestCA(t, "Internal CA")
+	spiffeID, _ := url.Parse("spiffe://corp.example/ci/runner")
+	runner := ca.issue(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "CI Runner", OrganizationalUnit: []string{"builders"}},
+		URIs:    []*url.URL{spiffeID},
+	})
+	laptop := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "alice-laptop"}, DNSNames: []string{"alice.corp.example"}})
+	stranger := newTestCA(t, "Other CA").issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "CI Runner"}})
+
+	server := newClientCertServer(t, ca, ClientAuthOptional, &SecurityConfig{
+		AuthRules: []AuthRuleConfig{
+			{Paths: []string{"/artifacts/**"}, Auth: AuthClientCert, Claims: map[string]string{"spiffe_id": "spiffe://corp.example/ci/runner"}},
+			{Paths: []string{"/builds/**"}, Auth: AuthClientCert, Groups: []string{"builders"}},
+			{Paths: []string{"/docs/**"}, Auth: AuthClientCert, Claims: map[string]string{"san": "alice.corp.example"}},
+			{Paths: []string{"/status/**"}, Auth: AuthClientCert, Claims: map[string]string{"cn": "CI Runner"}},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		cert       *tls.Certificate
+		wantStatus int
+		wantUser   string
+	}{
+		{"no certificate on a public path", "/", nil, http.StatusOK, ""},
+		{"certificate on a public path", "/", &laptop, http.StatusOK, "alice-laptop"},
+		{"no certificate", "/artifacts/a.tgz", nil, http.StatusForbidden, ""},
+		{"spiffe id", "/artifacts/a.tgz", &runner, http.StatusOK, "spiffe://corp.example/ci/runner"},
+		{"other spiffe id", "/artifacts/a.tgz", &laptop, http.StatusForbidden, ""},
+		{"organizational unit", "/builds/1", &runner, http.StatusOK, "spiffe://corp.example/ci/runner"},
+		{"dns san", "/docs/", &laptop, http.StatusOK, "alice-laptop"},
+		{"common name", "/status/", &runner, http.StatusOK, "spiffe://corp.example/ci/runner"},
+		{"other common name", "/status/", &laptop, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, user, err := getWithCert(server, tt.path, tt.cert)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("expected %d, got %d", tt.wantStatus, status)
+			}
+			if status == http.StatusOK && user != tt.wantUser {
+				t.Errorf("expected user %q, got %q", tt.wantUser, user)
+			}
+		})
+	}
+
+	// Certificates from other CAs fail the handshake, even when optional
+	if _, _, err := getWithCert(server, "/", &stranger); err == nil {
+		t.Error("expected a certificate from another CA to be rejected")
+	}
+}
+
+func TestClientCertRequired(t *testing.T) {
+	ca := newTestCA(t, "Internal CA")
+	cert := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ci"}})
+	server := newClientCertServer(t, ca, ClientAuthRequire, &SecurityConfig{})
+
+	if _, _, err := getWithCert(server, "/", nil); err == nil {
+		t.Error("expected the handshake to fail without a certificate")
+	}
+	if status, user, err := getWithCert(server, "/", &cert); err != nil || status != http.StatusOK || user != "ci" {
+		t.Errorf("expected 200 for ci, got %d %q (%v)", status, user, err)
+	}
+}
+
+func TestConfigureClientAuthErrors(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, newTestCA(t, "CA").pem, 0o644)
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o644)
+
+	for name, config := range map[string]*ClientAuthConfig{
+		"unknown mode": {Mode: "request", CAFile: caFile},
+		"missing file": {Mode: ClientAuthRequire, CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"no pem":       {Mode: ClientAuthRequire, CAFile: notPEM},
+	} {
+		if err := configureClientAuth(&tls.Config{}, config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package koryxserv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPKey stores the resolved client address in the request context
+type clientIPKey struct{}
+
+// Forwarding headers client_ip_header accepts, in canonical form
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-Ip"
+	HeaderForwarded     = "Forwarded"
+)
+
+// clientAddr is the resolved client of a request
+type clientAddr struct {
+	ip     string
+	header string // forwarding header of a trusted proxy it was resolved from
+}
+
+// ClientIP returns the address of the client that sent the request. Behind
+// trusted proxies it is the address resolved by RealIPMiddleware, otherwise
+// the address of the connection.
+func ClientIP(r *http.Request) string {
+	if addr, ok := r.Context().Value(clientIPKey{}).(clientAddr); ok {
+		return addr.ip
+	}
+	return clientIP(r.RemoteAddr)
+}
+
+// forwardedByTrustedProxy reports whether the client was resolved from
+// forwarding headers, which are then safe to pass on
+func forwardedByTrustedProxy(r *http.Request) bool {
+	return forwardedHeader(r) != ""
+}
+
+// forwardedHeader returns the forwarding header the client was resolved
+// from, or "" when it is the address of the connection
+func forwardedHeader(r *http.Request) string {
+	addr, _ := r.Context().Value(clientIPKey{}).(clientAddr)
+	return addr.header
+}
+
+// ValidateClientIPHeader checks a client_ip_header value
+func ValidateClientIPHeader(name string) error {
+	switch http.CanonicalHeaderKey(name) {
+	case "", HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded:
+		return nil
+	}
+	return fmt.Errorf("invalid client_ip_header %q (must be %s, X-Real-IP or %s)", name, HeaderXForwardedFor, HeaderForwarded)
+}
+
+// ValidateIPRanges checks that every entry is an IP address or a CIDR range
+func ValidateIPRanges(entries []string) error {
+	_, err := parseIPRanges(entries)
+	return err
+}
+
+// parseIPRanges parses IP addresses and CIDR ranges
+func parseIPRanges(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parseIPRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parseIPRange parses an IP address (a range of one) or a CIDR range.
+// IPv4-mapped IPv6 entries are turned into their IPv4 form.
+func parseIPRange(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if !strings.Contains(entry, "/") {
+		addr, err := netip.ParseAddr(entry)
+		if err != nil || addr.Zone() != "" {
+			return netip.Prefix{}, fmt.Errorf("invalid IP address %q", entry)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", entry)
+	}
+	if addr := prefix.Addr(); addr.Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q: IPv4-mapped ranges need at least 96 bits", entry)
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// RealIPMiddleware resolves the client address of requests that arrive
+// through trusted proxies from one forwarding header, X-Forwarded-For unless
+// header names X-Real-IP or Forwarded. It must be the header the proxies
+// set: others are passed through from the client as they are. The header is
+// walked right to left and the first address that is not a trusted proxy is
+// the client. Requests from other peers keep their connection address, so
+// the header cannot be spoofed.
+func RealIPMiddleware(trustedProxies []string, header string, logger *Logger) Middleware {
+	header = http.CanonicalHeaderKey(header)
+	if header == "" {
+		header = HeaderXForwardedFor
+	}
+	prefixes, err := parseIPRanges(trustedProxies)
+	if err == nil {
+		err = ValidateClientIPHeader(header)
+	}
+	if err != nil {
+		logger.Error("Ignoring trusted_proxies: %v", err)
+		prefixes = nil
+	}
+	trusted := newIPSet(prefixes)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := clientAddr{ip: clientIP(r.RemoteAddr)}
+			if trusted.containsIP(addr.ip) {
+				if ip, ok := forwardedClientIP(r.Header, header, trusted); ok {
+					addr = clientAddr{ip: ip, header: header}
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, addr)))
+		})
+	}
+}
+
+// forwardedClientIP resolves the client from the named forwarding header
+func forwardedClientIP(header http.Header, name string, trusted *ipSet) (string, bool) {
+	switch name {
+	case HeaderXRealIP:
+		if value := strings.TrimSpace(header.Get(HeaderXRealIP)); value != "" {
+			return walkForwardedHops([]string{value}, trusted)
+		}
+	case HeaderForwarded:
+		if values := header.Values(HeaderForwarded); len(values) > 0 {
+			return walkForwardedHops(parseForwardedFor(values), trusted)
+		}
+	default:
+		var hops []string
+		for _, value := range header.Values(HeaderXForwardedFor) {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		return walkForwardedHops(hops, trusted)
+	}
+	return "", false
+}
+
+// walkForwardedHops returns the rightmost hop that is not a trusted proxy.
+// A malformed hop ends the walk at the last valid address, since nothing
+// to its left can be trusted.
+func walkForwardedHops(hops []string, trusted *ipSet) (string, bool) {
+	found := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseHopIP(hops[i])
+		if !ok {
+			break
+		}
+		found = ip
+		if !trusted.containsIP(ip) {
+			break
+		}
+	}
+	return found, found != ""
+}
+
+// parseHopIP extracts the IP from a hop, which may carry a port and
+// brackets ("192.0.2.1:80", "[2001:db8::1]:443")
+func parseHopIP(hop string) (string, bool) {
+	hop = strings.Trim(hop, `"`)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(hop, "[]"))
+	if err != nil {
+		return "", false
+	}
+	return addr.Unmap().String(), true
+}
+
+// parseForwardedFor returns the for= values of RFC 7239 Forwarded headers
+// in order
+func parseForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hop = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
@@ -0,0 +1,88 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRealIPMiddleware(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	var got string
+	handlers := make(map[string]http.Handler)
+	for _, header := range []string{"", "x-real-ip", HeaderForwarded} {
+		handlers[header] = RealIPMiddleware([]string{"10.0.0.0/8", "192.168.1.10"}, header, logger)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			}),
+		)
+	}
+
+	tests := []struct {
+		name       string
+		header     string // client_ip_header
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"untrusted peer keeps its address", "", "203.0.113.9:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.9"},
+		{"no forwarding headers", "", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"rightmost untrusted hop", "", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.2.3.4"}, "198.51.100.1"},
+		{"all hops trusted", "", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "192.168.1.10, 10.2.3.4"}, "192.168.1.10"},
+		{"malformed hop stops the walk", "", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "198.51.100.1, garbage, 10.2.3.4"}, "10.2.3.4"},
+		{"x-real-ip ignored by default", "", "192.168.1.10:5000", map[string]string{"X-Real-IP": "198.51.100.2"}, "192.168.1.10"},
+		{"x-real-ip", "x-real-ip", "192.168.1.10:5000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"client x-forwarded-for ignored", "x-real-ip", "10.0.0.1:5000", map[string]string{"X-Real-IP": "198.51.100.2", "X-Forwarded-For": "198.51.100.3"}, "198.51.100.2"},
+		{"forwarded header", HeaderForwarded, "10.0.0.1:5000", map[string]string{"Forwarded": `for="[2001:db8::17]:4711";proto=https, for=10.9.9.9`}, "2001:db8::17"},
+		{"forwarded obfuscated hop", HeaderForwarded, "10.0.0.1:5000", map[string]string{"Forwarded": "for=_hidden, for=10.9.9.9"}, "10.9.9.9"},
+		{"forwarded without the header", HeaderForwarded, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "198.51.100.3"}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			handlers[tt.header].ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesWithIPFilter(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("ok"), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Security.TrustedProxies = []string{"10.0.0.0/8"}
+	config.Security.IPBlacklist = []string{"198.51.100.1"}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected the forwarded client to be blocked, got %d", w.Code)
+	}
+
+	// The same header from an untrusted peer is ignored
+	req.RemoteAddr = "203.0.113.9:5000"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected spoofed header from an untrusted peer to be ignored")
+	}
+}
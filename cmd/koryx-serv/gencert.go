@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	koryxserv "koryx-serv"
+)
+
+// runGenCert implements "koryx-serv gen-cert", which creates (or reuses)
+// the local CA and certificate of auto_cert "self-signed" and exports the
+// CA certificate, so it can be added to trust stores. Extra hostnames are
+// added to those of the configuration.
+func runGenCert(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gen-cert", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "Path to configuration file (JSON) with tls.auto_cert_dir and tls.hostnames")
+	dir := flags.String("dir", "", "Directory of the CA and certificate (overrides config)")
+	out := flags.String("out", "", "Write the CA certificate to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: koryx-serv gen-cert [options] [hostname ...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := loadConfiguration(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	certDir := *dir
+	if certDir == "" {
+		certDir = config.GetTLS().GetAutoCertDir()
+	}
+	hostnames := append(koryxserv.AutoCertHostnames(config), flags.Args()...)
+
+	files, err := koryxserv.EnsureSelfSignedCert(certDir, hostnames)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	ca, err := os.ReadFile(files.CAFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *out != "" {
+		if err := os.WriteFile(*out, ca, 0o644); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	} else {
+		stdout.Write(ca)
+	}
+	fmt.Fprintf(stderr, "CA certificate: %s\nCertificate:    %s (%s)\nKey:            %s\n",
+		files.CAFile, files.CertFile, strings.Join(hostnames, ", "), files.KeyFile)
+	return 0
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -20,6 +21,14 @@ const (
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "sign-url" {
+		os.Exit(runSignURL(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen-cert" {
+		os.Exit(runGenCert(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	// Command-line flags
 	configFile := flag.String("config", "", "Path to configuration file (JSON)")
 	port := flag.Int("port", 0, "Port to listen on (overrides config)")
@@ -95,6 +104,20 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// SIGHUP reloads the TLS certificates
+	if config.GetTLS().Enabled {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go func() {
+			for range hupChan {
+				logger.Info("Received SIGHUP, reloading TLS certificates")
+				if err := server.ReloadTLS(); err != nil {
+					logger.Error("TLS certificate reload failed: %v", err)
+				}
+			}
+		}()
+	}
+
 	// Start server in a goroutine
 	errChan := make(chan error, 1)
 	go func() {
@@ -174,33 +197,81 @@ func validateConfig(config *koryxserv.Config) error {
 		return fmt.Errorf("invalid port: %d (must be between 1-65535)", config.Server.Port)
 	}
 
-	// Validate root directory
-	if info, err := os.Stat(config.Server.RootDir); err != nil {
-		return fmt.Errorf("root directory error: %w", err)
-	} else if !info.IsDir() {
-		return fmt.Errorf("root path is not a directory: %s", config.Server.RootDir)
+	// Validate root directory (or archive) and extra layers
+	for _, root := range append([]string{config.Server.RootDir}, config.Server.Layers...) {
+		if info, err := os.Stat(root); err != nil {
+			return fmt.Errorf("root directory error: %w", err)
+		} else if !info.IsDir() && !koryxserv.IsArchivePath(root) {
+			return fmt.Errorf("root path is not a directory or supported archive: %s", root)
+		}
+	}
+
+	if config.Server.PreloadMaxSize < 0 {
+		return fmt.Errorf("invalid preload_max_size: %d (must not be negative)", config.Server.PreloadMaxSize)
+	}
+
+	if err := validateMounts(config.Mounts); err != nil {
+		return err
+	}
+	if err := koryxserv.ValidateProxies(config.Proxies, config.Mounts); err != nil {
+		return fmt.Errorf("proxies: %w", err)
 	}
 
 	// Validate HTTPS settings
-	if config.Security.EnableHTTPS {
-		if config.Security.CertFile == "" || config.Security.KeyFile == "" {
-			return fmt.Errorf("HTTPS enabled but cert_file or key_file not specified")
-		}
-		if _, err := os.Stat(config.Security.CertFile); err != nil {
-			return fmt.Errorf("certificate file not found: %s", config.Security.CertFile)
-		}
-		if _, err := os.Stat(config.Security.KeyFile); err != nil {
-			return fmt.Errorf("key file not found: %s", config.Security.KeyFile)
+	if config.TLS != nil && config.Security.EnableHTTPS {
+		return fmt.Errorf("HTTPS is configured twice: use the tls section instead of security.enable_https")
+	}
+	if tlsConfig := config.GetTLS(); tlsConfig.Enabled {
+		if err := koryxserv.ValidateTLS(tlsConfig); err != nil {
+			return fmt.Errorf("tls: %w", err)
 		}
 	}
 
-	// Validate basic authentication
-	if config.Security.BasicAuth != nil && config.Security.BasicAuth.Enabled {
-		if config.Security.BasicAuth.Username == "" || config.Security.BasicAuth.Password == "" {
-			return fmt.Errorf("basic auth enabled but username or password not specified")
+	if err := validateSecurity(&config.Security); err != nil {
+		return err
+	}
+	if err := validateClientCertRules(config); err != nil {
+		return err
+	}
+
+	// Validate redirect and rewrite rules
+	if err := koryxserv.ValidateRules(config.Rules); err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+
+	// Validate cache rules
+	if err := koryxserv.ValidateCacheRules(config.Performance.CacheRules); err != nil {
+		return fmt.Errorf("performance: %w", err)
+	}
+
+	// Validate virtual hosts
+	for pattern, host := range config.Hosts {
+		if host == nil {
+			continue
 		}
-		if config.Security.BasicAuth.Realm == "" {
-			config.Security.BasicAuth.Realm = "Restricted"
+		if err := koryxserv.ValidateHostPattern(pattern); err != nil {
+			return fmt.Errorf("hosts: %w", err)
+		}
+		if host.RootDir != "" {
+			if info, err := os.Stat(host.RootDir); err != nil {
+				return fmt.Errorf("host %s root directory error: %w", pattern, err)
+			} else if !info.IsDir() && !koryxserv.IsArchivePath(host.RootDir) {
+				return fmt.Errorf("host %s root path is not a directory or supported archive: %s", pattern, host.RootDir)
+			}
+		}
+		if err := validateMounts(host.Mounts); err != nil {
+			return fmt.Errorf("host %s: %w", pattern, err)
+		}
+		if err := koryxserv.ValidateProxies(host.Proxies, host.Mounts); err != nil {
+			return fmt.Errorf("host %s proxies: %w", pattern, err)
+		}
+		if err := koryxserv.ValidateRules(host.Rules); err != nil {
+			return fmt.Errorf("host %s rules: %w", pattern, err)
+		}
+		if host.Security != nil {
+			if err := validateSecurity(host.Security); err != nil {
+				return fmt.Errorf("host %s: %w", pattern, err)
+			}
 		}
 	}
 
@@ -209,6 +280,26 @@ func validateConfig(config *koryxserv.Config) error {
 		config.Performance.CompressionLevel = 6
 	}
 
+	// Validate memory cache
+	if cache := config.Performance.MemoryCache; cache != nil && cache.Enabled {
+		if cache.MaxSize < 0 || cache.MaxFileSize < 0 || cache.CheckInterval < 0 {
+			return fmt.Errorf("memory_cache: sizes and check_interval must not be negative")
+		}
+		if cache.AdminRoute != "" && !strings.HasPrefix(cache.AdminRoute, "/") {
+			return fmt.Errorf("memory_cache: admin_route must start with /: %s", cache.AdminRoute)
+		}
+		if cache.AdminRoute != "" && len(cache.AdminToken) < 32 {
+			return fmt.Errorf("memory_cache: admin_route requires an admin_token of at least 32 bytes")
+		}
+	}
+
+	// Validate ETag mode
+	switch config.Performance.ETagMode {
+	case "", koryxserv.ETagModeMTime, koryxserv.ETagModeContent:
+	default:
+		return fmt.Errorf("invalid etag_mode: %s (must be %q or %q)", config.Performance.ETagMode, koryxserv.ETagModeMTime, koryxserv.ETagModeContent)
+	}
+
 	// Validate log level
 	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
 	if !validLevels[config.Logging.Level] {
@@ -218,6 +309,138 @@ func validateConfig(config *koryxserv.Config) error {
 	return nil
 }
 
+// validateClientCertRules checks that auth rules requiring client
+// certificates have HTTPS ask for them
+func validateClientCertRules(config *koryxserv.Config) error {
+	if tlsConfig := config.GetTLS(); tlsConfig.Enabled && tlsConfig.ClientAuth != nil {
+		return nil
+	}
+	securities := []*koryxserv.SecurityConfig{&config.Security}
+	for _, host := range config.Hosts {
+		if host != nil && host.Security != nil {
+			securities = append(securities, host.Security)
+		}
+	}
+	for _, security := range securities {
+		for _, rule := range security.AuthRules {
+			if rule.Auth == koryxserv.AuthClientCert {
+				return fmt.Errorf("auth rules require client certificates, but tls.client_auth is not configured")
+			}
+		}
+	}
+	return nil
+}
+
+// validateMounts validates mount prefixes and roots
+func validateMounts(mounts []koryxserv.MountConfig) error {
+	seenPrefixes := make(map[string]bool)
+	for _, mount := range mounts {
+		prefix := mount.CleanPrefix()
+		if prefix == "" {
+			return fmt.Errorf("mount prefix %q must not be the root path", mount.Prefix)
+		}
+		if seenPrefixes[prefix] {
+			return fmt.Errorf("duplicate mount prefix: %s", prefix)
+		}
+		seenPrefixes[prefix] = true
+
+		if info, err := os.Stat(mount.RootDir); err != nil {
+			return fmt.Errorf("mount %s root directory error: %w", prefix, err)
+		} else if !info.IsDir() && !koryxserv.IsArchivePath(mount.RootDir) {
+			return fmt.Errorf("mount %s root path is not a directory or supported archive: %s", prefix, mount.RootDir)
+		}
+	}
+	return nil
+}
+
+// validateSecurity validates the request-level security settings
+// shared by the main configuration and virtual hosts
+func validateSecurity(security *koryxserv.SecurityConfig) error {
+	// Validate basic authentication
+	if security.BasicAuth != nil && security.BasicAuth.Enabled {
+		if err := koryxserv.ValidateBasicAuth(security.BasicAuth); err != nil {
+			return err
+		}
+		if security.BasicAuth.Realm == "" {
+			security.BasicAuth.Realm = "Restricted"
+		}
+	}
+
+	// Validate bearer token authentication
+	if security.BearerAuth != nil && security.BearerAuth.Enabled {
+		if err := koryxserv.ValidateBearerAuth(security.BearerAuth); err != nil {
+			return err
+		}
+	}
+
+	// Validate OpenID Connect login
+	if security.OIDC != nil && security.OIDC.Enabled {
+		if err := koryxserv.ValidateOIDC(security.OIDC); err != nil {
+			return err
+		}
+	}
+
+	// Validate signed URLs
+	if security.SignedURLs != nil && security.SignedURLs.Enabled {
+		if err := koryxserv.ValidateSignedURLs(security.SignedURLs); err != nil {
+			return err
+		}
+	}
+
+	// Validate forward auth
+	if security.ForwardAuth != nil && security.ForwardAuth.Enabled {
+		if err := koryxserv.ValidateForwardAuth(security.ForwardAuth); err != nil {
+			return err
+		}
+	}
+
+	// Validate per-path auth rules
+	if err := koryxserv.ValidateAuthRules(security); err != nil {
+		return err
+	}
+
+	// Validate IP lists
+	if err := koryxserv.ValidateIPRanges(security.IPWhitelist); err != nil {
+		return fmt.Errorf("ip_whitelist: %w", err)
+	}
+	if err := koryxserv.ValidateIPRanges(security.IPBlacklist); err != nil {
+		return fmt.Errorf("ip_blacklist: %w", err)
+	}
+	if security.IPWhitelistFile != "" {
+		if err := koryxserv.ValidateIPListFile(security.IPWhitelistFile); err != nil {
+			return fmt.Errorf("ip_whitelist_file: %w", err)
+		}
+	}
+	if security.IPBlacklistFile != "" {
+		if err := koryxserv.ValidateIPListFile(security.IPBlacklistFile); err != nil {
+			return fmt.Errorf("ip_blacklist_file: %w", err)
+		}
+	}
+
+	// Validate trusted proxies
+	if err := koryxserv.ValidateIPRanges(security.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	if err := koryxserv.ValidateClientIPHeader(security.ClientIPHeader); err != nil {
+		return err
+	}
+
+	// Validate path access rules
+	if err := koryxserv.ValidatePathPatterns(security.AllowedPaths); err != nil {
+		return fmt.Errorf("allowed_paths: %w", err)
+	}
+	if err := koryxserv.ValidatePathPatterns(security.BlockedPaths); err != nil {
+		return fmt.Errorf("blocked_paths: %w", err)
+	}
+	switch security.PathDenyStatus {
+	case 0, 403, 404:
+	default:
+		return fmt.Errorf("invalid path_deny_status: %d (must be 403 or 404)", security.PathDenyStatus)
+	}
+
+	return nil
+}
+
 // printHelp prints the help message
 func printHelp() {
 	fmt.Printf(`koryx-serv - Simple HTTP file server with advanced features
@@ -227,6 +450,8 @@ VERSION:
 
 USAGE:
   koryx-serv [options]
+  koryx-serv sign-url [-config file] [-secret key] [-expires 1h] [-method GET] [-ip addr] <path or URL>
+  koryx-serv gen-cert [-config file] [-dir dir] [-out ca.pem] [hostname ...]
 
 OPTIONS:
   -config string
@@ -269,6 +494,12 @@ EXAMPLES:
   # Generate example configuration
   koryx-serv -generate-config config.example.json
 
+  # Create a download link valid for 30 minutes
+  koryx-serv sign-url -config config.json -expires 30m /downloads/report.pdf
+
+  # Export the local CA of auto_cert "self-signed" to trust it
+  koryx-serv gen-cert -config config.json -out koryx-ca.pem dev.example.test
+
 CONFIGURATION:
   Configuration precedence:
     1) -config flag
@@ -279,13 +510,16 @@ CONFIGURATION:
 
 FEATURES:
   • Static file serving
+  • Virtual hosts
+  • Redirect and rewrite rules
   • Directory listing (optional)
   • HTTPS/TLS support
   • Basic authentication
+  • JWT bearer tokens, OIDC login and signed URLs
   • CORS support
   • Rate limiting
   • IP whitelist/blacklist
-  • Gzip compression
+  • Brotli/zstd/gzip compression
   • Cache headers
   • ETags
   • SPA mode
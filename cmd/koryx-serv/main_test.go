@@ -96,3 +96,452 @@ func TestLoadConfiguration_UsesDefaultsWhenNoConfigSourcesFound(t *testing.T) {
 		t.Fatalf("expected default port 8080, got %d", loaded.Server.Port)
 	}
 }
+
+func TestValidateConfig_RejectsInvalidPathRules(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+	cfg.Security.BlockedPaths = []string{"/secret/[a-"}
+
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "blocked_paths") {
+		t.Fatalf("expected blocked_paths validation error, got %v", err)
+	}
+
+	cfg.Security.BlockedPaths = []string{"/secret/**"}
+	cfg.Security.PathDenyStatus = 401
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "path_deny_status") {
+		t.Fatalf("expected path_deny_status validation error, got %v", err)
+	}
+}
+
+func TestValidateConfig_IPLists(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+	listFile := filepath.Join(t.TempDir(), "blocked.txt")
+	os.WriteFile(listFile, []byte("198.51.100.0/24\n2001:db8::/32\n"), 0o644)
+
+	cfg.Security.IPWhitelist = []string{"10.0.0.0/8", "::1"}
+	cfg.Security.IPBlacklistFile = listFile
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid IP lists, got %v", err)
+	}
+
+	cfg.Security.IPWhitelist = []string{"10.0.0.300"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "ip_whitelist") {
+		t.Fatalf("expected ip_whitelist error, got %v", err)
+	}
+	cfg.Security.IPWhitelist = nil
+
+	os.WriteFile(listFile, []byte("198.51.100.0/24\nnope\n"), 0o644)
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected ip_blacklist_file line error, got %v", err)
+	}
+}
+
+func TestValidateConfig_TrustedProxies(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Security.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid trusted proxies, got %v", err)
+	}
+
+	cfg.Security.ClientIPHeader = "X-Real-IP"
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected X-Real-IP to be valid, got %v", err)
+	}
+
+	cfg.Security.ClientIPHeader = "True-Client-IP"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "client_ip_header") {
+		t.Fatalf("expected client_ip_header error, got %v", err)
+	}
+	cfg.Security.ClientIPHeader = ""
+
+	cfg.Security.TrustedProxies = []string{"10.0.0.0/33"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "trusted_proxies") {
+		t.Fatalf("expected trusted_proxies error, got %v", err)
+	}
+}
+
+func TestValidateConfig_BasicAuthUsers(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Security.BasicAuth = &koryxserv.BasicAuthConfig{Enabled: true, Users: map[string]string{
+		"alice": "$apr1$r31.....$G/cElGhD0cboYkZN5h5Ne/",
+	}}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid basic auth users, got %v", err)
+	}
+
+	cfg.Security.BasicAuth.Users["bob"] = "$6$broken"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "bob") {
+		t.Fatalf("expected error for malformed hash, got %v", err)
+	}
+
+	cfg.Security.BasicAuth = &koryxserv.BasicAuthConfig{Enabled: true, HtpasswdFile: filepath.Join(t.TempDir(), "missing")}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "htpasswd_file") {
+		t.Fatalf("expected htpasswd_file error, got %v", err)
+	}
+}
+
+func TestValidateConfig_AuthRules(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Security.PublicPaths = []string{"/healthz"}
+	cfg.Security.AuthRules = []koryxserv.AuthRuleConfig{{Paths: []string{"/admin/**"}, Auth: "basic", Groups: []string{"ops"}}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "basic_auth") {
+		t.Fatalf("expected error for basic rule without users, got %v", err)
+	}
+
+	cfg.Security.BasicAuth = &koryxserv.BasicAuthConfig{Users: map[string]string{"alice": "secret"}}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid auth rules, got %v", err)
+	}
+
+	cfg.Security.AuthRules = []koryxserv.AuthRuleConfig{{Paths: []string{"/public/**"}, Auth: "none", Users: []string{"alice"}}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "auth rule 1") {
+		t.Fatalf("expected error for users on a public rule, got %v", err)
+	}
+
+	cfg.Security.AuthRules = []koryxserv.AuthRuleConfig{{Paths: []string{"/x/**"}, Auth: "digest"}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "unknown auth scheme") {
+		t.Fatalf("expected unknown scheme error, got %v", err)
+	}
+}
+
+func TestValidateConfig_BearerAuth(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Security.BearerAuth = &koryxserv.BearerAuthConfig{Enabled: true, Secret: "short"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "32 bytes") {
+		t.Fatalf("expected short secret error, got %v", err)
+	}
+
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(jwks, []byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"short"}]}`), 0o644)
+	cfg.Security.BearerAuth = &koryxserv.BearerAuthConfig{Enabled: true, JWKSFile: jwks}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "jwks_file") {
+		t.Fatalf("expected jwks_file error, got %v", err)
+	}
+
+	cfg.Security.BearerAuth = &koryxserv.BearerAuthConfig{Secret: "0123456789abcdef0123456789abcdef"}
+	cfg.Security.AuthRules = []koryxserv.AuthRuleConfig{{Paths: []string{"/api/**"}, Auth: "bearer", Claims: map[string]string{"scope": "read"}}}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid bearer rule, got %v", err)
+	}
+}
+
+func TestValidateConfig_OIDC(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Security.OIDC = &koryxserv.OIDCConfig{
+		Enabled:      true,
+		Issuer:       "https://login.example.com",
+		ClientID:     "site",
+		CookieSecret: "0123456789abcdef0123456789abcdef",
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid oidc config, got %v", err)
+	}
+
+	cfg.Security.OIDC.CookieSecret = "short"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "cookie_secret") {
+		t.Fatalf("expected cookie_secret error, got %v", err)
+	}
+
+	cfg.Security.OIDC.CookieSecret = "0123456789abcdef0123456789abcdef"
+	cfg.Security.OIDC.Issuer = "login.example.com"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Fatalf("expected issuer error, got %v", err)
+	}
+}
+
+func TestValidateConfig_ForwardAuth(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Security.ForwardAuth = &koryxserv.ForwardAuthConfig{Enabled: true, URL: "http://auth:4181/verify", ResponseHeaders: []string{"X-Auth-User"}}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid forward auth, got %v", err)
+	}
+
+	cfg.Security.ForwardAuth.ResponseHeaders = []string{"Transfer-Encoding"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "response header") {
+		t.Fatalf("expected response header error, got %v", err)
+	}
+
+	cfg.Security.ForwardAuth = &koryxserv.ForwardAuthConfig{Enabled: true, URL: "auth:4181"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "forward_auth url") {
+		t.Fatalf("expected url error, got %v", err)
+	}
+}
+
+func TestValidateConfig_TLS(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	// The legacy settings still require both files
+	cfg.Security.EnableHTTPS = true
+	cfg.Security.CertFile = filepath.Join(cfg.Server.RootDir, "cert.pem")
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "cert_file and key_file") {
+		t.Fatalf("expected missing key_file error, got %v", err)
+	}
+
+	cfg.TLS = &koryxserv.TLSConfig{Enabled: true, CertDir: cfg.Server.RootDir}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "configured twice") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	cfg.Security.EnableHTTPS = false
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid tls section, got %v", err)
+	}
+
+	cfg.TLS.MinVersion = "1.0"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "min_version") {
+		t.Fatalf("expected min_version error, got %v", err)
+	}
+}
+
+func TestValidateConfig_AutoCert(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+	cfg.Security.EnableHTTPS = true
+	cfg.Security.AutoCert = koryxserv.AutoCertSelfSigned
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected auto_cert without cert_file to be valid, got %v", err)
+	}
+
+	cfg.Security.AutoCert = "acme"
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "auto_cert") {
+		t.Fatalf("expected auto_cert error, got %v", err)
+	}
+}
+
+func TestRunGenCert(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(configPathEnvVar, "")
+
+	var stdout, stderr strings.Builder
+	if code := runGenCert([]string{"-dir", dir, "dev.example.test"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected success, got %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "-----BEGIN CERTIFICATE-----") {
+		t.Errorf("expected the CA certificate on stdout, got %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "dev.example.test") {
+		t.Errorf("expected the certificate names in the summary, got %q", stderr.String())
+	}
+
+	out := filepath.Join(t.TempDir(), "ca.pem")
+	stdout.Reset()
+	if code := runGenCert([]string{"-dir", dir, "-out", out}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected success, got %d: %s", code, stderr.String())
+	}
+	if data, err := os.ReadFile(out); err != nil || !strings.Contains(string(data), "BEGIN CERTIFICATE") || stdout.Len() != 0 {
+		t.Errorf("expected the CA certificate in %s only", out)
+	}
+}
+
+func TestValidateConfig_ClientCertRules(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+	cfg.Hosts = map[string]*koryxserv.HostConfig{
+		"artifacts.example.com": {Security: &koryxserv.SecurityConfig{
+			AuthRules: []koryxserv.AuthRuleConfig{{Paths: []string{"/**"}, Auth: koryxserv.AuthClientCert}},
+		}},
+	}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "client_auth") {
+		t.Fatalf("expected client_auth error, got %v", err)
+	}
+
+	cfg.TLS = &koryxserv.TLSConfig{
+		Enabled:    true,
+		CertDir:    cfg.Server.RootDir,
+		ClientAuth: &koryxserv.ClientAuthConfig{Mode: "maybe", CAFile: filepath.Join(cfg.Server.RootDir, "ca.pem")},
+	}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "client_auth") {
+		t.Fatalf("expected client_auth ca_file error, got %v", err)
+	}
+}
+
+func TestRunSignURL(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	cfg := koryxserv.DefaultConfig()
+	cfg.Security.SignedURLs = &koryxserv.SignedURLConfig{Secret: "0123456789abcdef0123456789abcdef"}
+	if err := koryxserv.SaveConfig(configPath, cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+
+	var stdout, stderr strings.Builder
+	code := runSignURL([]string{"-config", configPath, "-expires", "10m", "-method", "GET", "/downloads/a.zip"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected success, got %d: %s", code, stderr.String())
+	}
+	if out := stdout.String(); !strings.HasPrefix(out, "/downloads/a.zip?") || !strings.Contains(out, "method=GET") || !strings.Contains(out, "sig=") {
+		t.Errorf("unexpected signed URL %q", out)
+	}
+
+	stderr.Reset()
+	t.Setenv(configPathEnvVar, filepath.Join(t.TempDir(), "missing.json"))
+	if code := runSignURL([]string{"/downloads/a.zip"}, &stdout, &stderr); code == 0 {
+		t.Errorf("expected failure without a secret")
+	}
+	if code := runSignURL(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("expected usage error without a path, got %d", code)
+	}
+}
+
+func TestValidateConfig_Mounts(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+	docs := t.TempDir()
+
+	cfg.Mounts = []koryxserv.MountConfig{{Prefix: "/docs/", RootDir: docs}}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid mount, got %v", err)
+	}
+
+	cfg.Mounts = append(cfg.Mounts, koryxserv.MountConfig{Prefix: "/docs", RootDir: docs})
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "duplicate mount prefix") {
+		t.Fatalf("expected duplicate prefix error, got %v", err)
+	}
+
+	cfg.Mounts = []koryxserv.MountConfig{{Prefix: "/", RootDir: docs}}
+	if err := validateConfig(cfg); err == nil {
+		t.Fatalf("expected error for root mount prefix")
+	}
+
+	cfg.Mounts = []koryxserv.MountConfig{{Prefix: "/static", RootDir: filepath.Join(docs, "missing")}}
+	if err := validateConfig(cfg); err == nil {
+		t.Fatalf("expected error for missing mount root")
+	}
+}
+
+func TestValidateConfig_Proxies(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Proxies = []koryxserv.ProxyConfig{{Prefix: "/api", Upstreams: []string{"http://127.0.0.1:3000"}, StripPrefix: true}}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid proxy, got %v", err)
+	}
+
+	cfg.Mounts = []koryxserv.MountConfig{{Prefix: "/api/", RootDir: t.TempDir()}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "duplicate proxy or mount prefix") {
+		t.Fatalf("expected prefix collision error, got %v", err)
+	}
+	cfg.Mounts = nil
+
+	cfg.Proxies = []koryxserv.ProxyConfig{{Prefix: "/api", Upstreams: []string{"127.0.0.1:3000"}}}
+	if err := validateConfig(cfg); err == nil {
+		t.Fatalf("expected error for upstream without scheme")
+	}
+}
+
+func TestValidateConfig_Hosts(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+	site := t.TempDir()
+
+	cfg.Hosts = map[string]*koryxserv.HostConfig{
+		"*.example.com": {RootDir: site, Security: &koryxserv.SecurityConfig{
+			BasicAuth: &koryxserv.BasicAuthConfig{Enabled: true, Username: "u", Password: "p"},
+		}},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid host, got %v", err)
+	}
+	if realm := cfg.Hosts["*.example.com"].Security.BasicAuth.Realm; realm != "Restricted" {
+		t.Errorf("expected default realm for host basic auth, got %q", realm)
+	}
+
+	cfg.Hosts = map[string]*koryxserv.HostConfig{"*": {RootDir: site}}
+	if err := validateConfig(cfg); err == nil {
+		t.Fatalf("expected error for bare wildcard host")
+	}
+
+	cfg.Hosts = map[string]*koryxserv.HostConfig{"example.com": {RootDir: filepath.Join(site, "missing")}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "host example.com") {
+		t.Fatalf("expected missing host root error, got %v", err)
+	}
+}
+
+func TestValidateConfig_Rules(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Rules = []koryxserv.RuleConfig{
+		{From: "/old-blog/*", To: "/blog/:splat"},
+		{From: "/app/*", To: "/app/index.html", Type: "rewrite"},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid rules, got %v", err)
+	}
+
+	cfg.Rules = []koryxserv.RuleConfig{{From: "/old", To: "/new", Status: 200}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "rule 1") {
+		t.Fatalf("expected invalid status error, got %v", err)
+	}
+
+	cfg.Rules = nil
+	cfg.Hosts = map[string]*koryxserv.HostConfig{
+		"example.com": {Rules: []koryxserv.RuleConfig{{Regex: "([", To: "/x"}}},
+	}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "host example.com") {
+		t.Fatalf("expected invalid host rule error, got %v", err)
+	}
+}
+
+func TestValidateConfig_CacheRules(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	year := 31536000
+	cfg.Performance.CacheRules = []koryxserv.CacheRuleConfig{
+		{Paths: []string{"/assets/**"}, MaxAge: &year, Immutable: true},
+		{ContentTypes: []string{"text/html"}, NoCache: true},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected valid cache rules, got %v", err)
+	}
+
+	cfg.Performance.CacheRules = []koryxserv.CacheRuleConfig{{Paths: []string{"/assets/**"}}}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "cache rule 1") {
+		t.Fatalf("expected cache rule error, got %v", err)
+	}
+}
+
+func TestValidateConfig_ETagMode(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+
+	cfg.Performance.ETagMode = koryxserv.ETagModeContent
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected content etag mode to be valid, got %v", err)
+	}
+
+	cfg.Performance.ETagMode = "inode"
+	if err := validateConfig(cfg); err == nil {
+		t.Fatalf("expected error for unknown etag mode")
+	}
+}
+
+func TestValidateConfig_MemoryCacheAdmin(t *testing.T) {
+	cfg := koryxserv.DefaultConfig()
+	cfg.Server.RootDir = t.TempDir()
+	cfg.Performance.MemoryCache = &koryxserv.MemoryCacheConfig{Enabled: true, AdminRoute: "/_cache"}
+
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "admin_token") {
+		t.Fatalf("expected admin_token error, got %v", err)
+	}
+
+	cfg.Performance.MemoryCache.AdminToken = strings.Repeat("t", 32)
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("expected admin route with a token to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	koryxserv "koryx-serv"
+)
+
+// runSignURL implements "koryx-serv sign-url", which prints a signed,
+// expiring URL for a path. The secret comes from -secret or from
+// security.signed_urls in the configuration.
+func runSignURL(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("sign-url", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "Path to configuration file (JSON) holding security.signed_urls.secret")
+	secret := flags.String("secret", "", "Signing secret (overrides config)")
+	expiresIn := flags.Duration("expires", time.Hour, "How long the URL stays valid")
+	method := flags.String("method", "", "Only allow this HTTP method (e.g. GET)")
+	clientIP := flags.String("ip", "", "Only allow this client IP")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: koryx-serv sign-url [options] <path or URL>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *expiresIn <= 0 {
+		fmt.Fprintln(stderr, "Error: -expires must be positive")
+		return 2
+	}
+
+	key := *secret
+	if key == "" {
+		config, err := loadConfiguration(*configFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading configuration: %v\n", err)
+			return 1
+		}
+		if config.Security.SignedURLs != nil {
+			key = config.Security.SignedURLs.Secret
+		}
+	}
+	if key == "" {
+		fmt.Fprintln(stderr, "Error: no signing secret (use -secret or security.signed_urls.secret)")
+		return 1
+	}
+
+	signed, err := koryxserv.SignURL(flags.Arg(0), key, time.Now().Add(*expiresIn), &koryxserv.SignURLOptions{
+		Method:   *method,
+		ClientIP: *clientIP,
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, signed)
+	return 0
+}
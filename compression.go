@@ -0,0 +1,406 @@
+package koryxserv
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultCompressionMinSize is the smallest body worth compressing (bytes)
+const defaultCompressionMinSize = 1024
+
+// defaultCompressionTypes lists MIME patterns compressed when none are configured.
+// Already-compressed formats (images, video, archives, woff2) are left out on purpose.
+var defaultCompressionTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/x-javascript",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/wasm",
+	"image/svg+xml",
+	"image/x-icon",
+	"font/ttf",
+	"font/otf",
+	"application/vnd.ms-fontobject",
+}
+
+// compressor creates pooled encoders for a single content coding
+type compressor struct {
+	pool sync.Pool
+}
+
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+func newCompressor(name string, level int) *compressor {
+	c := &compressor{}
+	switch name {
+	case "gzip":
+		c.pool.New = func() any {
+			gz, err := gzip.NewWriterLevel(io.Discard, level)
+			if err != nil {
+				gz = gzip.NewWriter(io.Discard)
+			}
+			return gz
+		}
+	case "br":
+		c.pool.New = func() any {
+			return brotli.NewWriterLevel(io.Discard, level)
+		}
+	case "zstd":
+		c.pool.New = func() any {
+			// Browsers only guarantee an 8 MB window for zstd content coding
+			enc, _ := zstd.NewWriter(io.Discard,
+				zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithWindowSize(8<<20),
+			)
+			return &zstdWriter{enc}
+		}
+	default:
+		return nil
+	}
+	return c
+}
+
+func (c *compressor) get(w io.Writer) resettableWriter {
+	enc := c.pool.Get().(resettableWriter)
+	enc.Reset(w)
+	return enc
+}
+
+func (c *compressor) put(enc resettableWriter) {
+	c.pool.Put(enc)
+}
+
+// zstdWriter adapts *zstd.Encoder to resettableWriter
+type zstdWriter struct {
+	*zstd.Encoder
+}
+
+func (z *zstdWriter) Reset(w io.Writer) {
+	z.Encoder.Reset(w)
+}
+
+// compressionSettings is the resolved compression configuration
+type compressionSettings struct {
+	compressors map[string]*compressor
+	offers      []string
+	minSize     int
+	types       []string
+}
+
+func newCompressionSettings(config *PerformanceConfig) *compressionSettings {
+	level := config.CompressionLevel
+	if level < 1 || level > 9 {
+		level = 6
+	}
+
+	settings := &compressionSettings{
+		compressors: make(map[string]*compressor),
+		minSize:     config.CompressionMinSize,
+		types:       config.CompressionTypes,
+	}
+	if settings.minSize <= 0 {
+		settings.minSize = defaultCompressionMinSize
+	}
+	if len(settings.types) == 0 {
+		settings.types = defaultCompressionTypes
+	}
+
+	names := config.CompressionEncodings
+	if len(names) == 0 {
+		for _, coding := range knownCodings {
+			names = append(names, coding.Name)
+		}
+	}
+	for _, name := range names {
+		coding, ok := lookupCoding(name)
+		if !ok {
+			continue
+		}
+		if _, exists := settings.compressors[coding.Name]; exists {
+			continue
+		}
+		settings.compressors[coding.Name] = newCompressor(coding.Name, level)
+		settings.offers = append(settings.offers, coding.Name)
+	}
+
+	return settings
+}
+
+// compressibleType reports whether the Content-Type is in the allowlist
+func (c *compressionSettings) compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range c.types {
+		if ok, _ := path.Match(strings.ToLower(pattern), mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CompressionMiddleware compresses responses on the fly with br, zstd or gzip.
+// The coding is negotiated from Accept-Encoding q-values. Only allowlisted
+// content types at or above the minimum size are compressed, and responses
+// that already carry a Content-Encoding, Range requests, HEAD requests and
+// bodiless statuses pass through untouched.
+func CompressionMiddleware(config *PerformanceConfig) Middleware {
+	settings := newCompressionSettings(config)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := ""
+			if r.Method != http.MethodHead && r.Header.Get("Range") == "" {
+				encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"), settings.offers)
+			}
+
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				settings:       settings,
+				encoding:       encoding,
+				ifNoneMatch:    r.Header.Get("If-None-Match"),
+			}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressResponseWriter decides per response whether to compress.
+// The decision is made once headers are known and, when Content-Length is
+// missing, once enough of the body is buffered to compare with minSize.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	settings    *compressionSettings
+	encoding    string
+	ifNoneMatch string
+
+	status      int
+	headerSeen  bool // handler called WriteHeader (or Write)
+	decided     bool // compress or pass-through has been chosen
+	wroteHeader bool // underlying WriteHeader was called
+	buf         []byte
+	encoder     resettableWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.headerSeen {
+		return
+	}
+	// Informational responses are forwarded as-is
+	if code >= 100 && code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.headerSeen = true
+	w.status = code
+
+	header := w.Header()
+	if !bodyAllowsCompression(code) || header.Get("Content-Encoding") != "" {
+		w.passThrough()
+		return
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		// Sniff the type from the first bytes before deciding
+		return
+	}
+	if !w.settings.compressibleType(contentType) {
+		w.passThrough()
+		return
+	}
+	addVary(header, "Accept-Encoding")
+
+	if w.encoding == "" {
+		w.passThrough()
+		return
+	}
+	if size, err := strconv.Atoi(header.Get("Content-Length")); err == nil {
+		if size < w.settings.minSize {
+			w.passThrough()
+		} else {
+			w.startCompression()
+		}
+	}
+	// Unknown length: buffer until minSize bytes have been written
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.headerSeen {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.settings.minSize {
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide finishes a deferred decision using the buffered bytes.
+// With force set (streaming flush) the minimum size is not enforced.
+func (w *compressResponseWriter) decide(force bool) error {
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" || !w.settings.compressibleType(contentType) {
+		w.passThrough()
+		return w.flushBuffer()
+	}
+	addVary(header, "Accept-Encoding")
+
+	if w.encoding != "" && len(w.buf) > 0 && (force || len(w.buf) >= w.settings.minSize) {
+		w.startCompression()
+	} else {
+		w.passThrough()
+	}
+	return w.flushBuffer()
+}
+
+func (w *compressResponseWriter) flushBuffer() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressResponseWriter) passThrough() {
+	w.decided = true
+	w.writeHeader()
+}
+
+func (w *compressResponseWriter) startCompression() {
+	w.decided = true
+
+	header := w.Header()
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	// The encoded body is a different representation, so a strong validator
+	// of the identity body must not be reused as-is
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	w.writeHeader()
+	w.encoder = w.settings.compressors[w.encoding].get(w.ResponseWriter)
+}
+
+func (w *compressResponseWriter) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	// A 304 revalidating a compressed copy must echo the weak validator the
+	// client holds rather than the handler's strong one
+	if w.status == http.StatusNotModified && w.Header().Get("Content-Encoding") == "" {
+		if etag := w.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") &&
+			strings.Contains(w.ifNoneMatch, "W/"+etag) {
+			w.Header().Set("ETag", "W/"+etag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Close completes the response, flushing any buffered body and the encoder
+func (w *compressResponseWriter) Close() error {
+	if !w.headerSeen {
+		// Handler wrote nothing; let the server send its implicit 200
+		return nil
+	}
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.encoder != nil {
+		err := w.encoder.Close()
+		w.settings.compressors[w.encoding].put(w.encoder)
+		w.encoder = nil
+		return err
+	}
+	return nil
+}
+
+// Flush implements http.Flusher for streaming handlers
+func (w *compressResponseWriter) Flush() {
+	if !w.headerSeen {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		// Streaming responses cannot wait for minSize
+		w.decide(true)
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through the compression layer
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyAllowsCompression reports whether a response status carries a full body
+func bodyAllowsCompression(status int) bool {
+	switch {
+	case status < 200:
+		return false
+	case status == http.StatusNoContent,
+		status == http.StatusPartialContent,
+		status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,228 @@
+package koryxserv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentHandler serves a fixed body with the given Content-Type
+func contentHandler(contentType, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(body))
+	})
+}
+
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid gzip body: %v", err)
+		}
+		reader = gz
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		dec, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid zstd body: %v", err)
+		}
+		defer dec.Close()
+		reader = dec
+	default:
+		return string(body)
+	}
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decode %s body: %v", encoding, err)
+	}
+	return string(decoded)
+}
+
+func TestCompressionMiddlewareEncodings(t *testing.T) {
+	body := strings.Repeat("<p>hello compression</p>\n", 200)
+	middleware := CompressionMiddleware(&PerformanceConfig{CompressionLevel: 6})
+	handler := middleware(contentHandler("text/html; charset=utf-8", body))
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip", "gzip"},
+		{"br", "br"},
+		{"zstd", "zstd"},
+		{"gzip, deflate, br, zstd", "br"},
+		{"br;q=0.2, zstd;q=0.5, gzip;q=0.4", "zstd"},
+		{"br;q=0, gzip", "gzip"},
+		{"identity", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("Accept-Encoding %q: expected Content-Encoding %q, got %q", tt.acceptEncoding, tt.want, got)
+			continue
+		}
+		if decoded := decodeBody(t, tt.want, w.Body.Bytes()); decoded != body {
+			t.Errorf("Accept-Encoding %q: decoded body mismatch", tt.acceptEncoding)
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+			t.Errorf("Accept-Encoding %q: expected Vary: Accept-Encoding", tt.acceptEncoding)
+		}
+	}
+}
+
+func TestCompressionMiddlewareSkipsIneligibleResponses(t *testing.T) {
+	large := strings.Repeat("a", 4096)
+	config := &PerformanceConfig{CompressionLevel: 6, CompressionMinSize: 1024}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		header  map[string]string
+		vary    bool
+	}{
+		{
+			name:    "TooSmall",
+			handler: contentHandler("text/plain", "tiny"),
+			method:  "GET",
+			vary:    true,
+		},
+		{
+			name:    "NotInAllowlist",
+			handler: contentHandler("image/jpeg", large),
+			method:  "GET",
+		},
+		{
+			name:    "Head",
+			handler: contentHandler("text/plain", large),
+			method:  "HEAD",
+			vary:    true,
+		},
+		{
+			name:    "RangeRequest",
+			handler: contentHandler("text/plain", large),
+			method:  "GET",
+			header:  map[string]string{"Range": "bytes=0-10"},
+			vary:    true,
+		},
+		{
+			name: "NotModified",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotModified)
+			}),
+			method: "GET",
+		},
+		{
+			name: "AlreadyEncoded",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "br")
+				w.Write([]byte(large))
+			}),
+			method: "GET",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CompressionMiddleware(config)(tt.handler)
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if encoding := w.Header().Get("Content-Encoding"); encoding == "gzip" {
+				t.Fatalf("expected response not to be gzip encoded")
+			}
+			if hasVary := strings.Contains(w.Header().Get("Vary"), "Accept-Encoding"); hasVary != tt.vary {
+				t.Fatalf("expected Vary: Accept-Encoding present=%v, got %q", tt.vary, w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCompressionMiddlewareContentLengthAndETag(t *testing.T) {
+	body := strings.Repeat("{\"key\":\"value\"}", 200)
+	handler := CompressionMiddleware(&PerformanceConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding")
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("expected Content-Length to be removed, got %q", w.Header().Get("Content-Length"))
+	}
+	if w.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("expected weakened ETag, got %q", w.Header().Get("ETag"))
+	}
+}
+
+func TestCompressionMiddlewareSniffsContentType(t *testing.T) {
+	body := "<!DOCTYPE html><html><body>" + strings.Repeat("x", 2048) + "</body></html>"
+	handler := CompressionMiddleware(&PerformanceConfig{})(contentHandler("", body))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("expected br encoding for sniffed HTML, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if decoded := decodeBody(t, "br", w.Body.Bytes()); decoded != body {
+		t.Fatalf("decoded body mismatch")
+	}
+}
+
+func TestCompressionMiddlewareCustomAllowlist(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	config := &PerformanceConfig{
+		CompressionEncodings: []string{"gzip"},
+		CompressionTypes:     []string{"application/octet-stream"},
+	}
+	handler := CompressionMiddleware(config)(contentHandler("application/octet-stream", body))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected only configured gzip encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,168 @@
+package koryxserv
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// preconditionResult is the outcome of evaluating conditional headers
+type preconditionResult int
+
+const (
+	preconditionPass preconditionResult = iota
+	preconditionNotModified
+	preconditionFailed
+)
+
+// conditionalHeaders are answered by checkPreconditions and removed before
+// the request reaches http.ServeContent
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"}
+
+// checkPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since in the order of RFC 9110 section 13.2.2. etag is
+// the current validator ("" when ETags are disabled) and modTime may be zero.
+func checkPreconditions(r *http.Request, etag string, modTime time.Time) preconditionResult {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return preconditionFailed
+		}
+	} else if since := r.Header.Get("If-Unmodified-Since"); since != "" && !modTime.IsZero() {
+		if t, err := http.ParseTime(since); err == nil && modTime.Truncate(time.Second).After(t) {
+			return preconditionFailed
+		}
+	}
+
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			if safe {
+				return preconditionNotModified
+			}
+			return preconditionFailed
+		}
+	} else if since := r.Header.Get("If-Modified-Since"); since != "" && safe && !modTime.IsZero() {
+		if t, err := http.ParseTime(since); err == nil && !modTime.Truncate(time.Second).After(t) {
+			return preconditionNotModified
+		}
+	}
+	return preconditionPass
+}
+
+// ifRangeMatches reports whether a Range request may be served partially.
+// If-Range needs a strong entity tag or an exact modification date.
+func ifRangeMatches(r *http.Request, etag string, modTime time.Time) bool {
+	ifRange := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		tag, _ := scanETag(ifRange)
+		return tag != "" && etagsMatch(tag, etag, true)
+	}
+	if modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Truncate(time.Second).Equal(t)
+}
+
+// etagListMatches reports whether an If-Match/If-None-Match value matches
+// etag. "*" matches any current representation.
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+	if etag == "" {
+		return false
+	}
+
+	for rest := list; ; {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return false
+		}
+		tag, remain := scanETag(rest)
+		if tag == "" {
+			// Malformed member: skip to the next comma
+			i := strings.IndexByte(rest, ',')
+			if i < 0 {
+				return false
+			}
+			rest = rest[i+1:]
+			continue
+		}
+		if etagsMatch(tag, etag, strong) {
+			return true
+		}
+		rest = remain
+	}
+}
+
+// scanETag reads one entity tag (optionally W/ prefixed) from the start of s
+func scanETag(s string) (tag, rest string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s) <= start || s[start] != '"' {
+		return "", s
+	}
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", s
+	}
+	end += start + 2
+	return s[:end], s[end:]
+}
+
+// etagsMatch compares entity tags using the strong or weak function of
+// RFC 9110 section 8.8.3.2
+func etagsMatch(a, b string, strong bool) bool {
+	if strong {
+		return a == b && !strings.HasPrefix(a, "W/")
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// evaluatePreconditions answers 304 and 412 itself. Otherwise it returns the
+// request to pass to http.ServeContent, without the headers already handled
+// and without Range when If-Range no longer matches.
+func (s *Server) evaluatePreconditions(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) (*http.Request, bool) {
+	switch checkPreconditions(r, etag, modTime) {
+	case preconditionNotModified:
+		header := w.Header()
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		header.Del("Content-Encoding")
+		if etag == "" && !modTime.IsZero() {
+			header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return nil, false
+	case preconditionFailed:
+		s.serveError(w, r, http.StatusPreconditionFailed)
+		return nil, false
+	}
+
+	conditional := r.Header.Get("Range") != ""
+	for _, name := range conditionalHeaders {
+		if r.Header.Get(name) != "" {
+			conditional = true
+		}
+	}
+	if !conditional {
+		return r, true
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = r.Header.Clone()
+	if !ifRangeMatches(r, etag, modTime) {
+		r2.Header.Del("Range")
+	}
+	for _, name := range conditionalHeaders {
+		r2.Header.Del(name)
+	}
+	return r2, true
+}
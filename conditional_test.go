@@ -0,0 +1,135 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"abc"`
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	before := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	same := modTime.Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    preconditionResult
+	}{
+		{"no conditions", "GET", nil, preconditionPass},
+		{"if-none-match exact", "GET", map[string]string{"If-None-Match": `"abc"`}, preconditionNotModified},
+		{"if-none-match list", "GET", map[string]string{"If-None-Match": `"x", "abc"`}, preconditionNotModified},
+		{"if-none-match weak", "GET", map[string]string{"If-None-Match": `W/"abc"`}, preconditionNotModified},
+		{"if-none-match star", "GET", map[string]string{"If-None-Match": "*"}, preconditionNotModified},
+		{"if-none-match other", "GET", map[string]string{"If-None-Match": `"x"`}, preconditionPass},
+		{"if-none-match unsafe", "POST", map[string]string{"If-None-Match": `"abc"`}, preconditionFailed},
+		{"if-none-match beats if-modified-since", "GET", map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": same}, preconditionPass},
+		{"if-modified-since same", "GET", map[string]string{"If-Modified-Since": same}, preconditionNotModified},
+		{"if-modified-since before", "GET", map[string]string{"If-Modified-Since": before}, preconditionPass},
+		{"if-match strong", "GET", map[string]string{"If-Match": `"x", "abc"`}, preconditionPass},
+		{"if-match weak fails", "GET", map[string]string{"If-Match": `W/"abc"`}, preconditionFailed},
+		{"if-match star", "PUT", map[string]string{"If-Match": "*"}, preconditionPass},
+		{"if-unmodified-since before", "GET", map[string]string{"If-Unmodified-Since": before}, preconditionFailed},
+		{"if-unmodified-since same", "GET", map[string]string{"If-Unmodified-Since": same}, preconditionPass},
+		{"if-match beats if-unmodified-since", "GET", map[string]string{"If-Match": `"abc"`, "If-Unmodified-Since": before}, preconditionPass},
+		{"malformed member", "GET", map[string]string{"If-None-Match": `abc, "abc"`}, preconditionNotModified},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/", nil)
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		if got := checkPreconditions(req, etag, modTime); got != test.want {
+			t.Errorf("%s: expected %d, got %d", test.name, test.want, got)
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{`"abc"`, true},
+		{`"x"`, false},
+		{`W/"abc"`, false},
+		{modTime.Format(http.TimeFormat), true},
+		{modTime.Add(time.Hour).Format(http.TimeFormat), false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.ifRange != "" {
+			req.Header.Set("If-Range", test.ifRange)
+		}
+		if got := ifRangeMatches(req, `"abc"`, modTime); got != test.want {
+			t.Errorf("If-Range %q: expected %v, got %v", test.ifRange, test.want, got)
+		}
+	}
+}
+
+func TestServeFileConditionalRequests(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("0123456789"), 0o644)
+	os.WriteFile(filepath.Join(root, "b.txt"), []byte("9876543210"), 0o644)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(root, "a.txt"), modTime, modTime)
+	os.Chtimes(filepath.Join(root, "b.txt"), modTime, modTime)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Performance.EnableCompression = false
+	config.Performance.ETagMode = ETagModeContent
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("expected NewHandler to succeed, got error: %v", err)
+	}
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/a.txt", nil)
+	etag := first.Header().Get("ETag")
+	if etag == "" || etag == get("/b.txt", nil).Header().Get("ETag") {
+		t.Fatalf("expected distinct content ETags for same-size files, got %q", etag)
+	}
+	if first.Header().Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+		t.Errorf("expected Last-Modified, got %q", first.Header().Get("Last-Modified"))
+	}
+
+	if w := get("/a.txt", map[string]string{"If-None-Match": `"other", ` + etag}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for ETag list, got %d", w.Code)
+	}
+	if w := get("/a.txt", map[string]string{"If-Match": `"other"`}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for failed If-Match, got %d", w.Code)
+	}
+	if w := get("/a.txt", map[string]string{"Range": "bytes=0-3", "If-Range": etag}); w.Code != http.StatusPartialContent || w.Body.String() != "0123" {
+		t.Errorf("expected partial content for matching If-Range, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/a.txt", map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`}); w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Errorf("expected full content for stale If-Range, got %d %q", w.Code, w.Body.String())
+	}
+
+	// A changed file gets a new content hash
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("abcdefghij"), 0o644)
+	os.Chtimes(filepath.Join(root, "a.txt"), modTime.Add(time.Hour), modTime.Add(time.Hour))
+	if w := get("/a.txt", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after the file changed, got %d", w.Code)
+	}
+}
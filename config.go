@@ -3,49 +3,264 @@ package koryxserv
 import (
 	"encoding/json"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Config represents the full server configuration
 type Config struct {
-	Server        ServerConfig         `json:"server"`
-	Security      SecurityConfig       `json:"security"`
-	Performance   PerformanceConfig    `json:"performance"`
-	Logging       LoggingConfig        `json:"logging"`
-	Features      FeaturesConfig       `json:"features"`
-	RuntimeConfig *RuntimeConfigConfig `json:"runtime_config,omitempty"`
+	Server        ServerConfig           `json:"server"`
+	Security      SecurityConfig         `json:"security"`
+	TLS           *TLSConfig             `json:"tls,omitempty"` // replaces security.enable_https, cert_file and key_file
+	Performance   PerformanceConfig      `json:"performance"`
+	Logging       LoggingConfig          `json:"logging"`
+	Features      FeaturesConfig         `json:"features"`
+	RuntimeConfig *RuntimeConfigConfig   `json:"runtime_config,omitempty"`
+	Mounts        []MountConfig          `json:"mounts,omitempty"`
+	Hosts         map[string]*HostConfig `json:"hosts,omitempty"` // keyed by host name, "*.example.com" allowed
+	Rules         []RuleConfig           `json:"rules,omitempty"` // evaluated in order, first match wins
+	Proxies       []ProxyConfig          `json:"proxies,omitempty"`
 }
 
 // ServerConfig contains basic server settings
 type ServerConfig struct {
-	Port         int    `json:"port"`
-	Host         string `json:"host"`
-	RootDir      string `json:"root_dir"`
-	ReadTimeout  int    `json:"read_timeout"`  // seconds
-	WriteTimeout int    `json:"write_timeout"` // seconds
+	Port         int      `json:"port"`
+	Host         string   `json:"host"`
+	RootDir      string   `json:"root_dir"`         // directory or .zip/.tar/.tar.gz archive
+	Layers       []string `json:"layers,omitempty"` // extra directories/archives consulted below root_dir
+	ReadTimeout  int      `json:"read_timeout"`     // seconds
+	WriteTimeout int      `json:"write_timeout"`    // seconds
+
+	// Preload snapshots the whole root into memory at startup
+	Preload        bool  `json:"preload,omitempty"`
+	PreloadMaxSize int64 `json:"preload_max_size,omitempty"` // bytes, compressed variants included (default: 256 MB)
+}
+
+// TLSConfig configures HTTPS: certificates selected by SNI name and the
+// protocol settings
+type TLSConfig struct {
+	Enabled               bool                   `json:"enabled"`
+	Certificates          []TLSCertificateConfig `json:"certificates,omitempty"`            // the first one is the default for unknown or missing names
+	CertDir               string                 `json:"cert_dir,omitempty"`                // name.crt or name.pem with name.key, or subdirectories with tls.crt and tls.key
+	MinVersion            string                 `json:"min_version,omitempty"`             // "1.2" (default) or "1.3"
+	CipherSuites          []string               `json:"cipher_suites,omitempty"`           // TLS 1.2 suites by name, e.g. "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+	Curves                []string               `json:"curves,omitempty"`                  // key exchanges in preference order: X25519MLKEM768, X25519, P256, P384, P521
+	ALPN                  []string               `json:"alpn,omitempty"`                    // "h2" and "http/1.1" (default: both)
+	SessionTicketRotation int                    `json:"session_ticket_rotation,omitempty"` // seconds between new session ticket keys (default: Go's daily rotation)
+	DisableSessionTickets bool                   `json:"disable_session_tickets,omitempty"`
+	ClientAuth            *ClientAuthConfig      `json:"client_auth,omitempty"` // mutual TLS
+
+	// AutoCert "self-signed" generates a local CA and a certificate for
+	// hostnames, virtual hosts, localhost and 127.0.0.1, kept in AutoCertDir
+	AutoCert    string   `json:"auto_cert,omitempty"`
+	AutoCertDir string   `json:"auto_cert_dir,omitempty"` // default: koryx-serv/certs in the user config directory
+	Hostnames   []string `json:"hostnames,omitempty"`
+}
+
+// ClientAuthConfig asks HTTPS clients for certificates signed by a CA
+// bundle; auth rules with auth "client_cert" then check who they are
+type ClientAuthConfig struct {
+	Mode   string `json:"mode"`    // "optional" (verified when presented) or "require"
+	CAFile string `json:"ca_file"` // PEM bundle of the CAs that sign client certificates
+}
+
+// TLSCertificateConfig is a certificate chain and its private key, in PEM
+type TLSCertificateConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
 }
 
 // SecurityConfig contains security settings
 type SecurityConfig struct {
-	EnableHTTPS      bool             `json:"enable_https"`
-	CertFile         string           `json:"cert_file"`
-	KeyFile          string           `json:"key_file"`
-	BasicAuth        *BasicAuthConfig `json:"basic_auth,omitempty"`
-	CORS             *CORSConfig      `json:"cors,omitempty"`
-	RateLimit        *RateLimitConfig `json:"rate_limit,omitempty"`
-	IPWhitelist      []string         `json:"ip_whitelist,omitempty"`
-	IPBlacklist      []string         `json:"ip_blacklist,omitempty"`
-	BlockHiddenFiles bool             `json:"block_hidden_files"`
-	AllowedPaths     []string         `json:"allowed_paths,omitempty"`
-	BlockedPaths     []string         `json:"blocked_paths,omitempty"`
+	EnableHTTPS      bool               `json:"enable_https"` // deprecated: use the tls section
+	CertFile         string             `json:"cert_file"`
+	KeyFile          string             `json:"key_file"`
+	AutoCert         string             `json:"auto_cert,omitempty"` // "self-signed" instead of cert_file and key_file
+	BasicAuth        *BasicAuthConfig   `json:"basic_auth,omitempty"`
+	BearerAuth       *BearerAuthConfig  `json:"bearer_auth,omitempty"`
+	OIDC             *OIDCConfig        `json:"oidc,omitempty"`
+	SignedURLs       *SignedURLConfig   `json:"signed_urls,omitempty"`
+	ForwardAuth      *ForwardAuthConfig `json:"forward_auth,omitempty"`
+	CORS             *CORSConfig        `json:"cors,omitempty"`
+	RateLimit        *RateLimitConfig   `json:"rate_limit,omitempty"`
+	IPWhitelist      []string           `json:"ip_whitelist,omitempty"`
+	IPBlacklist      []string           `json:"ip_blacklist,omitempty"`
+	IPWhitelistFile  string             `json:"ip_whitelist_file,omitempty"` // one IP or CIDR range per line, reloaded on change
+	IPBlacklistFile  string             `json:"ip_blacklist_file,omitempty"`
+	TrustedProxies   []string           `json:"trusted_proxies,omitempty"`  // IPs or CIDR ranges whose forwarding headers are honored
+	ClientIPHeader   string             `json:"client_ip_header,omitempty"` // header trusted proxies set: X-Forwarded-For (default), X-Real-IP or Forwarded
+	BlockHiddenFiles bool               `json:"block_hidden_files"`
+	AllowedPaths     []string           `json:"allowed_paths,omitempty"`
+	BlockedPaths     []string           `json:"blocked_paths,omitempty"`
+	PathDenyStatus   int                `json:"path_deny_status,omitempty"` // 403 (default) or 404
+	AuthRules        []AuthRuleConfig   `json:"auth_rules,omitempty"`       // first match decides how a path is authenticated
+	PublicPaths      []string           `json:"public_paths,omitempty"`     // never authenticated, e.g. health checks
+}
+
+// AuthRuleConfig requires an auth scheme, and optionally specific users or
+// groups, for matching paths
+type AuthRuleConfig struct {
+	Paths  []string          `json:"paths"`            // globs, e.g. "/admin/**"
+	Auth   string            `json:"auth"`             // "none", "basic", "bearer", "oidc", "signed" or "client_cert"
+	Users  []string          `json:"users,omitempty"`  // allowed users (any authenticated user when both lists are empty)
+	Groups []string          `json:"groups,omitempty"` // allowed groups
+	Claims map[string]string `json:"claims,omitempty"` // required token or certificate claims: exact value or list member, "" for any
 }
 
 // BasicAuthConfig configures HTTP basic authentication
 type BasicAuthConfig struct {
-	Enabled  bool   `json:"enabled"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Realm    string `json:"realm"`
+	Enabled      bool                `json:"enabled"`
+	Username     string              `json:"username"`
+	Password     string              `json:"password"` // plaintext or a bcrypt, SHA-crypt, argon2id or MD5-crypt hash
+	Realm        string              `json:"realm"`
+	Users        map[string]string   `json:"users,omitempty"`         // username -> password, like password
+	HtpasswdFile string              `json:"htpasswd_file,omitempty"` // Apache-style user:hash lines, reloaded on change
+	Groups       map[string][]string `json:"groups,omitempty"`        // group -> usernames, for auth rules
+}
+
+// BearerAuthConfig configures JWT bearer token authentication
+type BearerAuthConfig struct {
+	Enabled        bool              `json:"enabled"`
+	Secret         string            `json:"secret,omitempty"`          // HS256/384/512 shared secret, at least 32 bytes
+	JWKSFile       string            `json:"jwks_file,omitempty"`       // RSA, EC and Ed25519 public keys, reloaded on change
+	Issuer         string            `json:"issuer,omitempty"`          // required iss
+	Audience       string            `json:"audience,omitempty"`        // required aud entry
+	RequiredClaims map[string]string `json:"required_claims,omitempty"` // claim -> value, "" for any
+	Cookie         string            `json:"cookie,omitempty"`          // cookie read when there is no Authorization header
+	UserClaim      string            `json:"user_claim,omitempty"`      // default "sub"
+	GroupsClaim    string            `json:"groups_claim,omitempty"`    // default "groups"
+	Leeway         int               `json:"leeway,omitempty"`          // allowed clock skew in seconds for exp and nbf
+	Realm          string            `json:"realm,omitempty"`
+}
+
+// GetUserClaim returns the claim holding the username
+func (c *BearerAuthConfig) GetUserClaim() string {
+	if c.UserClaim == "" {
+		return "sub"
+	}
+	return c.UserClaim
+}
+
+// GetGroupsClaim returns the claim holding the user's groups
+func (c *BearerAuthConfig) GetGroupsClaim() string {
+	if c.GroupsClaim == "" {
+		return "groups"
+	}
+	return c.GroupsClaim
+}
+
+// OIDCConfig configures OpenID Connect login (authorization code flow with
+// PKCE) and the encrypted session cookie that follows it
+type OIDCConfig struct {
+	Enabled           bool     `json:"enabled"`
+	Issuer            string   `json:"issuer"` // discovered at /.well-known/openid-configuration
+	ClientID          string   `json:"client_id"`
+	ClientSecret      string   `json:"client_secret,omitempty"`       // empty for public clients
+	RedirectURL       string   `json:"redirect_url,omitempty"`        // callback URL or path, default "/oauth2/callback"
+	LogoutPath        string   `json:"logout_path,omitempty"`         // default "/oauth2/logout"
+	LogoutRedirectURL string   `json:"logout_redirect_url,omitempty"` // after logout, default "/"
+	Scopes            []string `json:"scopes,omitempty"`              // default openid, profile and email
+	CookieSecret      string   `json:"cookie_secret"`                 // encrypts the session cookie, at least 32 bytes
+	CookieName        string   `json:"cookie_name,omitempty"`         // default "koryx_session"
+	SessionTTL        int      `json:"session_ttl,omitempty"`         // seconds, default 8 hours
+	UserClaim         string   `json:"user_claim,omitempty"`          // default "sub"
+	GroupsClaim       string   `json:"groups_claim,omitempty"`        // default "groups"
+}
+
+// GetRedirectURL returns the callback URL or path
+func (c *OIDCConfig) GetRedirectURL() string {
+	if c.RedirectURL == "" {
+		return "/oauth2/callback"
+	}
+	return c.RedirectURL
+}
+
+// GetLogoutPath returns the path that ends the session
+func (c *OIDCConfig) GetLogoutPath() string {
+	if c.LogoutPath == "" {
+		return "/oauth2/logout"
+	}
+	return c.LogoutPath
+}
+
+// GetLogoutRedirectURL returns where browsers go after logout
+func (c *OIDCConfig) GetLogoutRedirectURL() string {
+	if c.LogoutRedirectURL == "" {
+		return "/"
+	}
+	return c.LogoutRedirectURL
+}
+
+// GetScopes returns the requested scopes, which always include openid
+func (c *OIDCConfig) GetScopes() []string {
+	if len(c.Scopes) == 0 {
+		return []string{"openid", "profile", "email"}
+	}
+	for _, scope := range c.Scopes {
+		if scope == "openid" {
+			return c.Scopes
+		}
+	}
+	return append([]string{"openid"}, c.Scopes...)
+}
+
+// GetCookieName returns the name of the session cookie
+func (c *OIDCConfig) GetCookieName() string {
+	if c.CookieName == "" {
+		return "koryx_session"
+	}
+	return c.CookieName
+}
+
+// GetSessionTTL returns how long a login lasts
+func (c *OIDCConfig) GetSessionTTL() time.Duration {
+	if c.SessionTTL <= 0 {
+		return 8 * time.Hour
+	}
+	return time.Duration(c.SessionTTL) * time.Second
+}
+
+// GetUserClaim returns the ID token claim holding the username
+func (c *OIDCConfig) GetUserClaim() string {
+	if c.UserClaim == "" {
+		return "sub"
+	}
+	return c.UserClaim
+}
+
+// GetGroupsClaim returns the ID token claim holding the user's groups
+func (c *OIDCConfig) GetGroupsClaim() string {
+	if c.GroupsClaim == "" {
+		return "groups"
+	}
+	return c.GroupsClaim
+}
+
+// SignedURLConfig configures expiring, HMAC-signed links (see SignURL)
+type SignedURLConfig struct {
+	Enabled bool     `json:"enabled"`
+	Secret  string   `json:"secret"`          // HMAC-SHA256 key, at least 32 bytes
+	Paths   []string `json:"paths,omitempty"` // globs that need a signed URL
+}
+
+// ForwardAuthConfig delegates access decisions to an external service
+type ForwardAuthConfig struct {
+	Enabled         bool     `json:"enabled"`
+	URL             string   `json:"url"`                        // asked with the original method and headers
+	ResponseHeaders []string `json:"response_headers,omitempty"` // copied from a 2xx answer onto the request, e.g. "X-Auth-User"
+	UserHeader      string   `json:"user_header,omitempty"`      // answer header naming the user, for the access log
+	CacheTTL        int      `json:"cache_ttl,omitempty"`        // seconds to reuse a decision for the same request and Authorization and Cookie headers
+	Timeout         int      `json:"timeout,omitempty"`          // seconds, default 5
+}
+
+// GetTimeout returns the timeout of requests to the auth service
+func (c *ForwardAuthConfig) GetTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
 }
 
 // CORSConfig contains CORS settings
@@ -70,9 +285,57 @@ type PerformanceConfig struct {
 	EnableCompression bool              `json:"enable_compression"`
 	CompressionLevel  int               `json:"compression_level"` // 1-9
 	EnableCache       bool              `json:"enable_cache"`
-	CacheMaxAge       int               `json:"cache_max_age"` // seconds
+	CacheMaxAge       int               `json:"cache_max_age"`         // seconds
+	CacheRules        []CacheRuleConfig `json:"cache_rules,omitempty"` // first match wins over cache_max_age
 	EnableETags       bool              `json:"enable_etags"`
+	ETagMode          string            `json:"etag_mode,omitempty"` // "mtime" (default) or "content"
 	CustomHeaders     map[string]string `json:"custom_headers,omitempty"`
+
+	// On-the-fly compression tuning
+	CompressionEncodings []string `json:"compression_encodings,omitempty"` // preference order (default: br, zstd, gzip)
+	CompressionMinSize   int      `json:"compression_min_size,omitempty"`  // bytes (default: 1024)
+	CompressionTypes     []string `json:"compression_types,omitempty"`     // MIME allowlist, e.g. "text/*"
+
+	// Precompressed serves file.br/.zst/.gz sidecars instead of compressing on the fly
+	Precompressed          bool     `json:"precompressed"`
+	PrecompressedEncodings []string `json:"precompressed_encodings,omitempty"` // preference order (default: br, zstd, gzip)
+
+	MemoryCache *MemoryCacheConfig `json:"memory_cache,omitempty"`
+}
+
+// MemoryCacheConfig keeps small files, their ETags and compressed variants in memory
+type MemoryCacheConfig struct {
+	Enabled       bool   `json:"enabled"`
+	MaxSize       int64  `json:"max_size,omitempty"`       // total bytes (default: 64 MB)
+	MaxFileSize   int64  `json:"max_file_size,omitempty"`  // larger files are not cached (default: 1 MB)
+	CheckInterval int    `json:"check_interval,omitempty"` // seconds between change polls; 0 stats files on every hit
+	AdminRoute    string `json:"admin_route,omitempty"`    // stats (GET) and purge (POST/DELETE), e.g. "/_cache"
+	AdminToken    string `json:"admin_token,omitempty"`    // bearer token admin_route requires (at least 32 bytes)
+}
+
+// ETag modes
+const (
+	ETagModeMTime   = "mtime"   // modification time and size
+	ETagModeContent = "content" // strong hash of the file content
+)
+
+// CacheRuleConfig is a Cache-Control policy for matching responses.
+// Paths, Regex and ContentTypes are optional conditions that must all hold.
+type CacheRuleConfig struct {
+	Paths        []string `json:"paths,omitempty"`         // globs, e.g. "/assets/**"
+	Regex        string   `json:"regex,omitempty"`         // matched against the URL path, e.g. content-hashed names
+	ContentTypes []string `json:"content_types,omitempty"` // MIME patterns, e.g. "text/html"
+
+	CacheControl         string `json:"cache_control,omitempty"` // raw value, overrides the fields below
+	MaxAge               *int   `json:"max_age,omitempty"`
+	SMaxAge              *int   `json:"s_maxage,omitempty"`
+	Private              bool   `json:"private,omitempty"`
+	NoCache              bool   `json:"no_cache,omitempty"`
+	NoStore              bool   `json:"no_store,omitempty"`
+	MustRevalidate       bool   `json:"must_revalidate,omitempty"`
+	Immutable            bool   `json:"immutable,omitempty"`
+	StaleWhileRevalidate int    `json:"stale_while_revalidate,omitempty"` // seconds
+	StaleIfError         int    `json:"stale_if_error,omitempty"`         // seconds
 }
 
 // LoggingConfig contains logging settings
@@ -92,6 +355,7 @@ type FeaturesConfig struct {
 	SPAMode          bool              `json:"spa_mode"` // redirect all routes to index.html
 	SPAIndex         string            `json:"spa_index"`
 	CustomErrorPages map[string]string `json:"custom_error_pages,omitempty"`
+	SiteFiles        bool              `json:"site_files,omitempty"` // honour _redirects and _headers in the root
 }
 
 // RuntimeConfigConfig configures runtime config output
@@ -105,6 +369,72 @@ type RuntimeConfigConfig struct {
 	NoCache      bool     `json:"no_cache"`      // if true, add no-cache headers
 }
 
+// MountConfig maps a URL prefix to its own root directory.
+// Pointer fields left unset inherit the main configuration.
+type MountConfig struct {
+	Prefix           string   `json:"prefix"`   // URL prefix, e.g. "/docs"
+	RootDir          string   `json:"root_dir"` // directory or archive
+	IndexFiles       []string `json:"index_files,omitempty"`
+	SPAMode          *bool    `json:"spa_mode,omitempty"`
+	SPAIndex         string   `json:"spa_index,omitempty"`
+	DirectoryListing *bool    `json:"directory_listing,omitempty"`
+	BlockHiddenFiles *bool    `json:"block_hidden_files,omitempty"`
+	CacheMaxAge      *int     `json:"cache_max_age,omitempty"` // seconds (0 disables cache headers)
+}
+
+// CleanPrefix returns the mount prefix with a leading and no trailing slash
+func (m *MountConfig) CleanPrefix() string {
+	return strings.TrimSuffix(path.Clean("/"+m.Prefix), "/")
+}
+
+// ProxyConfig forwards requests under a URL prefix to upstream servers.
+// Several upstreams are used round-robin; one that fails max_fails times
+// in a row is skipped for fail_timeout seconds.
+type ProxyConfig struct {
+	Prefix          string            `json:"prefix"`    // URL prefix, e.g. "/api"
+	Upstreams       []string          `json:"upstreams"` // e.g. "http://127.0.0.1:3000"
+	StripPrefix     bool              `json:"strip_prefix,omitempty"`
+	PreserveHost    bool              `json:"preserve_host,omitempty"`    // send the client's Host instead of the upstream's
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`  // set on upstream requests ("" removes)
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"` // set on responses ("" removes)
+	ConnectTimeout  int               `json:"connect_timeout,omitempty"`  // seconds (default: 10)
+	ResponseTimeout int               `json:"response_timeout,omitempty"` // seconds to wait for response headers (0: no limit)
+	MaxFails        int               `json:"max_fails,omitempty"`        // default: 1
+	FailTimeout     int               `json:"fail_timeout,omitempty"`     // seconds (default: 10)
+}
+
+// CleanPrefix returns the proxy prefix with a leading and no trailing slash
+func (p *ProxyConfig) CleanPrefix() string {
+	return strings.TrimSuffix(path.Clean("/"+p.Prefix), "/")
+}
+
+// RuleConfig is a redirect or rewrite rule.
+// The path is matched either by From ("/blog/:slug", "/old-blog/*") or by
+// Regex; To may reference :name, :splat, $1 or ${name} captures.
+type RuleConfig struct {
+	From    string            `json:"from,omitempty"`
+	Regex   string            `json:"regex,omitempty"`
+	To      string            `json:"to"`
+	Type    string            `json:"type,omitempty"`    // "redirect" (default) or "rewrite"
+	Status  int               `json:"status,omitempty"`  // 301 (default), 302, 307 or 308 for redirects
+	Query   map[string]string `json:"query,omitempty"`   // required parameters: exact value, "" for any, ":name" to capture
+	Host    string            `json:"host,omitempty"`    // glob on the request host, e.g. "*.example.com"
+	Headers map[string]string `json:"headers,omitempty"` // header name -> regexp ("" only requires presence)
+}
+
+// HostConfig overrides the main configuration for a virtual host.
+// A section that is set replaces the main section as a whole (an empty
+// mounts, rules or proxies list clears it); unset sections are inherited.
+type HostConfig struct {
+	RootDir       string               `json:"root_dir,omitempty"`
+	Features      *FeaturesConfig      `json:"features,omitempty"`
+	Security      *SecurityConfig      `json:"security,omitempty"`
+	RuntimeConfig *RuntimeConfigConfig `json:"runtime_config,omitempty"`
+	Mounts        []MountConfig        `json:"mounts,omitempty"`
+	Rules         []RuleConfig         `json:"rules,omitempty"`
+	Proxies       []ProxyConfig        `json:"proxies,omitempty"`
+}
+
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -178,6 +508,50 @@ func SaveConfig(filename string, config *Config) error {
 	return encoder.Encode(config)
 }
 
+// GetTLS returns the HTTPS settings: the tls section, or one certificate
+// from security.enable_https, cert_file and key_file (or auto_cert) when
+// there is none
+func (c *Config) GetTLS() *TLSConfig {
+	if c.TLS != nil {
+		return c.TLS
+	}
+	if !c.Security.EnableHTTPS {
+		return &TLSConfig{}
+	}
+	tls := &TLSConfig{Enabled: true, AutoCert: c.Security.AutoCert}
+	if c.Security.AutoCert == "" || c.Security.CertFile != "" || c.Security.KeyFile != "" {
+		tls.Certificates = []TLSCertificateConfig{{CertFile: c.Security.CertFile, KeyFile: c.Security.KeyFile}}
+	}
+	return tls
+}
+
+// GetAutoCertDir returns the directory of generated certificates
+func (c *TLSConfig) GetAutoCertDir() string {
+	if c.AutoCertDir != "" {
+		return c.AutoCertDir
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "koryx-serv", "certs")
+	}
+	return filepath.Join(".koryx-serv", "certs")
+}
+
+// GetALPN returns the protocols offered through ALPN, in preference order
+func (c *TLSConfig) GetALPN() []string {
+	if len(c.ALPN) == 0 {
+		return []string{"h2", "http/1.1"}
+	}
+	return c.ALPN
+}
+
+// GetPreloadMaxSize returns the preload memory limit in bytes
+func (c *ServerConfig) GetPreloadMaxSize() int64 {
+	if c.PreloadMaxSize > 0 {
+		return c.PreloadMaxSize
+	}
+	return defaultPreloadMaxSize
+}
+
 // GetReadTimeout returns the read timeout as Duration
 func (c *ServerConfig) GetReadTimeout() time.Duration {
 	return time.Duration(c.ReadTimeout) * time.Second
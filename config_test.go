@@ -240,3 +240,23 @@ func TestConfigJSON(t *testing.T) {
 		t.Errorf("Expected env prefix TEST_, got %s", loadedConfig.RuntimeConfig.EnvPrefix)
 	}
 }
+
+func TestGetTLS(t *testing.T) {
+	config := DefaultConfig()
+	if config.GetTLS().Enabled {
+		t.Error("Expected HTTPS disabled by default")
+	}
+
+	config.Security.EnableHTTPS = true
+	config.Security.CertFile = "cert.pem"
+	config.Security.KeyFile = "key.pem"
+	tls := config.GetTLS()
+	if !tls.Enabled || len(tls.Certificates) != 1 || tls.Certificates[0].CertFile != "cert.pem" || tls.Certificates[0].KeyFile != "key.pem" {
+		t.Errorf("Expected the legacy settings as one certificate, got %+v", tls)
+	}
+
+	config.TLS = &TLSConfig{Enabled: true, CertDir: "/etc/certs"}
+	if config.GetTLS() != config.TLS {
+		t.Errorf("Expected the tls section to take precedence")
+	}
+}
@@ -0,0 +1,113 @@
+package koryxserv
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// contentCoding describes a content coding and its precompressed sidecar extension
+type contentCoding struct {
+	Name      string // token used in Accept-Encoding / Content-Encoding
+	Extension string // sidecar file suffix
+}
+
+// knownCodings lists supported codings in default server preference order
+var knownCodings = []contentCoding{
+	{Name: "br", Extension: ".br"},
+	{Name: "zstd", Extension: ".zst"},
+	{Name: "gzip", Extension: ".gz"},
+}
+
+// lookupCoding returns the coding registered under name
+func lookupCoding(name string) (contentCoding, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "x-gzip" {
+		name = "gzip"
+	}
+	for _, coding := range knownCodings {
+		if coding.Name == name {
+			return coding, true
+		}
+	}
+	return contentCoding{}, false
+}
+
+// parseAcceptEncoding parses an Accept-Encoding header into coding -> q-value
+func parseAcceptEncoding(header string) map[string]float64 {
+	result := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name := part
+		q := 1.0
+		if idx := strings.Index(part, ";"); idx >= 0 {
+			name = strings.TrimSpace(part[:idx])
+			for _, param := range strings.Split(part[idx+1:], ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+					continue
+				}
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+
+		name = strings.ToLower(name)
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		result[name] = q
+	}
+	return result
+}
+
+// encodingQuality returns the q-value the client assigned to a coding
+func encodingQuality(accepted map[string]float64, name string) float64 {
+	if q, ok := accepted[name]; ok {
+		return q
+	}
+	if q, ok := accepted["*"]; ok {
+		return q
+	}
+	return 0
+}
+
+// negotiateEncoding picks the best coding from the offered list.
+// Offers are in server preference order, which breaks ties between equal
+// q-values. An empty result means the identity coding should be used.
+func negotiateEncoding(header string, offers []string) string {
+	if header == "" || len(offers) == 0 {
+		return ""
+	}
+
+	accepted := parseAcceptEncoding(header)
+	best := ""
+	bestQ := 0.0
+	for _, offer := range offers {
+		if q := encodingQuality(accepted, offer); q > bestQ {
+			best = offer
+			bestQ = q
+		}
+	}
+	return best
+}
+
+// addVary appends a token to the Vary header unless it is already present
+func addVary(header http.Header, token string) {
+	for _, value := range header.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			existing = strings.TrimSpace(existing)
+			if existing == "*" || strings.EqualFold(existing, token) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", token)
+}
@@ -0,0 +1,63 @@
+package koryxserv
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseAcceptEncoding(t *testing.T) {
+	accepted := parseAcceptEncoding("gzip;q=0.8, br, zstd;q=0, *;q=0.1, x-gzip;q=0.5")
+
+	if accepted["br"] != 1 {
+		t.Errorf("Expected br q=1, got %v", accepted["br"])
+	}
+	if accepted["zstd"] != 0 {
+		t.Errorf("Expected zstd q=0, got %v", accepted["zstd"])
+	}
+	// x-gzip is an alias and the later entry wins
+	if accepted["gzip"] != 0.5 {
+		t.Errorf("Expected gzip q=0.5, got %v", accepted["gzip"])
+	}
+	if accepted["*"] != 0.1 {
+		t.Errorf("Expected * q=0.1, got %v", accepted["*"])
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	offers := []string{"br", "zstd", "gzip"}
+
+	tests := []struct {
+		header string
+		offers []string
+		want   string
+	}{
+		{"", offers, ""},
+		{"gzip", offers, "gzip"},
+		{"gzip, br", offers, "br"},
+		{"br;q=0.5, gzip", offers, "gzip"},
+		{"br;q=0, gzip;q=0", offers, ""},
+		{"*", offers, "br"},
+		{"*;q=0.5, gzip;q=0.9", offers, "gzip"},
+		{"identity", offers, ""},
+		{"gzip, deflate, br", []string{"gzip"}, "gzip"},
+		{"br, gzip", []string{"gzip", "br"}, "gzip"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header, tt.offers); got != tt.want {
+			t.Errorf("negotiateEncoding(%q, %v) = %q, want %q", tt.header, tt.offers, got, tt.want)
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	header := http.Header{}
+	header.Set("Vary", "Origin")
+	addVary(header, "Accept-Encoding")
+	addVary(header, "accept-encoding")
+
+	values := header.Values("Vary")
+	if len(values) != 2 || values[1] != "Accept-Encoding" {
+		t.Errorf("Expected Vary to contain Origin and Accept-Encoding once, got %v", values)
+	}
+}
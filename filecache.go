@@ -0,0 +1,517 @@
+package koryxserv
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Memory cache defaults
+const (
+	defaultMemoryCacheSize     = 64 << 20 // bytes
+	defaultMemoryCacheFileSize = 1 << 20  // bytes
+)
+
+// fileCache keeps small files, their ETags and compressed variants in
+// memory with a total size budget and least-recently-used eviction.
+// A single cache is shared by the main server, its mounts and hosts.
+type fileCache struct {
+	maxSize     int64
+	maxFileSize int64
+	noStat      bool // entries are validated by a poller or never change
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // front is most recently used
+	size    int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+
+	stop chan struct{}
+}
+
+// cacheKey identifies a file in the filesystem of one server
+type cacheKey struct {
+	owner *Server
+	name  string
+}
+
+// cachedFile is a file held in memory
+type cachedFile struct {
+	key         cacheKey
+	fsys        fs.FS
+	modTime     time.Time
+	fileSize    int64
+	contentType string
+	etag        string
+	data        []byte
+
+	mu       sync.Mutex
+	variants map[string][]byte // content coding -> compressed body
+}
+
+// CacheStats reports memory cache activity
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"` // files read from the filesystem
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`     // bytes held, compressed variants included
+	MaxSize   int64 `json:"max_size"` // bytes
+}
+
+func newFileCache(config *MemoryCacheConfig) *fileCache {
+	c := &fileCache{
+		maxSize:     config.MaxSize,
+		maxFileSize: config.MaxFileSize,
+		entries:     make(map[cacheKey]*list.Element),
+		lru:         list.New(),
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = defaultMemoryCacheSize
+	}
+	if c.maxFileSize <= 0 {
+		c.maxFileSize = defaultMemoryCacheFileSize
+	}
+
+	if config.CheckInterval > 0 {
+		c.noStat = true
+		c.stop = make(chan struct{})
+		go c.poll(time.Duration(config.CheckInterval) * time.Second)
+	}
+	return c
+}
+
+// newSnapshotCache returns an unbounded cache for a preloaded snapshot,
+// whose files never change
+func newSnapshotCache() *fileCache {
+	return &fileCache{
+		maxSize:     math.MaxInt64,
+		maxFileSize: math.MaxInt64,
+		noStat:      true,
+		entries:     make(map[cacheKey]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// get returns a cached file without touching the filesystem when possible.
+// Otherwise the file is stat'ed and the entry dropped when its modification
+// time or size changed.
+func (c *fileCache) get(owner *Server, name string) (*cachedFile, bool) {
+	entry, ok := c.lookup(cacheKey{owner: owner, name: name})
+	if !ok {
+		return nil, false
+	}
+	if !c.noStat && !entry.current() {
+		c.remove(entry.key)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return entry, true
+}
+
+// peek returns a cached file that matches info, which the caller has just
+// stat'ed
+func (c *fileCache) peek(owner *Server, name string, info fs.FileInfo) (*cachedFile, bool) {
+	entry, ok := c.lookup(cacheKey{owner: owner, name: name})
+	if !ok || entry.fileSize != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	c.hits.Add(1)
+	return entry, true
+}
+
+func (c *fileCache) lookup(key cacheKey) (*cachedFile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedFile), true
+}
+
+// add reads a file into the cache and counts a miss. Files above the
+// per-file limit are not cached and false is returned.
+func (c *fileCache) add(owner *Server, name string, info fs.FileInfo, etag string) (*cachedFile, bool) {
+	c.misses.Add(1)
+	if !info.Mode().IsRegular() || info.Size() > c.maxFileSize || info.Size() > c.maxSize {
+		return nil, false
+	}
+
+	// Snapshots share their bytes instead of holding a second copy
+	data, ok := []byte(nil), false
+	if mem, isMem := owner.fsys.(*memFS); isMem {
+		data, ok = mem.sharedData(name)
+	}
+	if !ok {
+		var err error
+		if data, err = fs.ReadFile(owner.fsys, name); err != nil {
+			return nil, false
+		}
+	}
+	if int64(len(data)) != info.Size() {
+		return nil, false
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	entry := &cachedFile{
+		key:         cacheKey{owner: owner, name: name},
+		fsys:        owner.fsys,
+		modTime:     info.ModTime(),
+		fileSize:    info.Size(),
+		contentType: contentType,
+		etag:        etag,
+		data:        data,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[entry.key]; ok {
+		c.removeElement(old)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += int64(len(data))
+	c.evict(entry)
+	return entry, true
+}
+
+// addVariant stores a compressed body for an entry that is still cached.
+// An entry that was replaced since is left alone: its size is no longer
+// counted, so a variant added to it would never be subtracted.
+func (c *fileCache) addVariant(entry *cachedFile, coding string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; !ok || elem.Value.(*cachedFile) != entry {
+		return
+	}
+	entry.mu.Lock()
+	if _, exists := entry.variants[coding]; exists {
+		entry.mu.Unlock()
+		return
+	}
+	if entry.variants == nil {
+		entry.variants = make(map[string][]byte)
+	}
+	entry.variants[coding] = data
+	entry.mu.Unlock()
+
+	c.size += int64(len(data))
+	c.evict(entry)
+}
+
+// evict drops least recently used entries until the cache fits its budget.
+// keep is never evicted. Must be called with c.mu held.
+func (c *fileCache) evict(keep *cachedFile) {
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil || elem.Value.(*cachedFile) == keep {
+			return
+		}
+		c.removeElement(elem)
+		c.evictions.Add(1)
+	}
+}
+
+func (c *fileCache) remove(key cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeElement must be called with c.mu held
+func (c *fileCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cachedFile)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.memorySize()
+}
+
+// purge drops every entry, or only the entries for one file name
+func (c *fileCache) purge(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for key, elem := range c.entries {
+		if name == "" || key.name == name {
+			c.removeElement(elem)
+			purged++
+		}
+	}
+	return purged
+}
+
+// stats returns a snapshot of the counters
+func (c *fileCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   len(c.entries),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+	}
+}
+
+// poll drops entries whose file changed, until close is called
+func (c *fileCache) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		entries := make([]*cachedFile, 0, len(c.entries))
+		for _, elem := range c.entries {
+			entries = append(entries, elem.Value.(*cachedFile))
+		}
+		c.mu.Unlock()
+
+		for _, entry := range entries {
+			if !entry.current() {
+				c.remove(entry.key)
+			}
+		}
+	}
+}
+
+// close stops the poller
+func (c *fileCache) close() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+// current reports whether the file on disk still matches the entry
+func (entry *cachedFile) current() bool {
+	info, err := fs.Stat(entry.fsys, entry.key.name)
+	return err == nil && info.Size() == entry.fileSize && info.ModTime().Equal(entry.modTime)
+}
+
+func (entry *cachedFile) memorySize() int64 {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	size := int64(len(entry.data))
+	for _, variant := range entry.variants {
+		size += int64(len(variant))
+	}
+	return size
+}
+
+func (entry *cachedFile) variant(coding string) ([]byte, bool) {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	data, ok := entry.variants[coding]
+	return data, ok
+}
+
+// serveCachedFile serves a file from memory. When live compression is on,
+// compressible files are encoded once per coding and kept with the entry.
+func (s *Server) serveCachedFile(w http.ResponseWriter, r *http.Request, entry *cachedFile) {
+	header := w.Header()
+	body, etag := entry.data, entry.etag
+
+	if coding := s.cachedCoding(r, entry); coding != "" {
+		addVary(header, "Accept-Encoding")
+		if encoded, ok := s.cachedVariant(entry, coding); ok {
+			body = encoded
+			header.Set("Content-Encoding", coding)
+			if etag != "" {
+				etag = strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+			}
+		}
+	}
+
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	r, ok := s.evaluatePreconditions(w, r, etag, entry.modTime)
+	if !ok {
+		return
+	}
+
+	header.Set("Content-Type", entry.contentType)
+	http.ServeContent(w, r, path.Base(entry.key.name), entry.modTime, bytes.NewReader(body))
+}
+
+// cachedCoding negotiates a compressed variant for a cached file, following
+// the same rules as CompressionMiddleware
+func (s *Server) cachedCoding(r *http.Request, entry *cachedFile) string {
+	settings := s.compression
+	if settings == nil || r.Header.Get("Range") != "" {
+		return ""
+	}
+	return negotiateEncoding(r.Header.Get("Accept-Encoding"), s.cachedCodings(entry))
+}
+
+// cachedCodings returns the codings worth keeping for a cached file
+func (s *Server) cachedCodings(entry *cachedFile) []string {
+	settings := s.compression
+	if settings == nil || int64(len(entry.data)) < int64(settings.minSize) || !settings.compressibleType(entry.contentType) {
+		return nil
+	}
+	return settings.offers
+}
+
+// cachedVariant returns the compressed body of an entry, encoding it on first use
+func (s *Server) cachedVariant(entry *cachedFile, coding string) ([]byte, bool) {
+	if data, ok := entry.variant(coding); ok {
+		return data, true
+	}
+
+	compressor := s.compression.compressors[coding]
+	var buf bytes.Buffer
+	enc := compressor.get(&buf)
+	_, err := enc.Write(entry.data)
+	if closeErr := enc.Close(); err == nil {
+		err = closeErr
+	}
+	compressor.put(enc)
+	if err != nil {
+		s.logger.Error("Error compressing %s: %v", entry.key.name, err)
+		return nil, false
+	}
+
+	data := buf.Bytes()
+	s.cache.addVariant(entry, coding, data)
+	return data, true
+}
+
+// warmPreload caches every file of a preloaded snapshot together with its
+// ETag and compressed variants, so that requests are answered from memory.
+// It fails when files and variants exceed the preload limit.
+func (s *Server) warmPreload() error {
+	limit := s.config.Server.GetPreloadMaxSize()
+	var total int64
+
+	err := fs.WalkDir(s.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		etag := ""
+		if s.config.Performance.EnableETags {
+			etag = s.fileETag(name, info)
+		}
+		entry, ok := s.cache.add(s, name, info, etag)
+		if !ok {
+			return nil
+		}
+		total += int64(len(entry.data))
+
+		for _, coding := range s.cachedCodings(entry) {
+			if data, ok := s.cachedVariant(entry, coding); ok {
+				total += int64(len(data))
+			}
+		}
+		if total > limit {
+			return fmt.Errorf("preload: snapshot and compressed variants exceed the limit of %d bytes", limit)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Preloaded %s: %d bytes", s.config.Server.RootDir, total)
+	return nil
+}
+
+// CacheStats returns the memory cache counters (zero when the cache is disabled)
+func (s *Server) CacheStats() CacheStats {
+	if s.cache == nil {
+		return CacheStats{}
+	}
+	return s.cache.stats()
+}
+
+// PurgeCache drops cached files: all of them when urlPath is empty,
+// otherwise the file at that path in every root. It returns the number of
+// entries removed.
+func (s *Server) PurgeCache(urlPath string) int {
+	if s.cache == nil {
+		return 0
+	}
+	name := ""
+	if urlPath != "" {
+		name = fsName(urlPath)
+	}
+	return s.cache.purge(name)
+}
+
+// handleCacheAdmin reports cache stats (GET) and purges the cache
+// (POST or DELETE, optionally limited with ?path=). Requests must carry
+// admin_token as a bearer token.
+func (s *Server) handleCacheAdmin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !s.cacheAdminAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="memory cache"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodDelete:
+		purged := s.PurgeCache(r.URL.Query().Get("path"))
+		s.logger.Info("Memory cache purged: %d entries", purged)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.MarshalIndent(s.CacheStats(), "", "  ")
+	if err != nil {
+		s.logger.Error("Error marshaling cache stats: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// cacheAdminAuthorized reports whether the request carries the admin token;
+// without a configured token nobody is
+func (s *Server) cacheAdminAuthorized(r *http.Request) bool {
+	token := s.config.Performance.MemoryCache.AdminToken
+	scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if token == "" || !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1
+}
@@ -0,0 +1,201 @@
+package koryxserv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCacheLRU(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		os.WriteFile(filepath.Join(root, name), []byte(strings.Repeat("x", 40)), 0o644)
+	}
+	owner := &Server{fsys: os.DirFS(root)}
+	cache := newFileCache(&MemoryCacheConfig{Enabled: true, MaxSize: 100, MaxFileSize: 50})
+
+	add := func(name string) {
+		info, _ := os.Stat(filepath.Join(root, name))
+		if _, ok := cache.add(owner, name, info, ""); !ok {
+			t.Fatalf("expected %s to be cached", name)
+		}
+	}
+
+	add("a.bin")
+	add("b.bin")
+	if _, ok := cache.get(owner, "a.bin"); !ok {
+		t.Fatalf("expected a.bin to be cached")
+	}
+	// a.bin was used last, so b.bin is evicted
+	add("c.bin")
+	if _, ok := cache.get(owner, "b.bin"); ok {
+		t.Errorf("expected b.bin to be evicted")
+	}
+	if _, ok := cache.get(owner, "a.bin"); !ok {
+		t.Errorf("expected a.bin to stay cached")
+	}
+
+	stats := cache.stats()
+	if stats.Entries != 2 || stats.Size != 80 || stats.Evictions != 1 || stats.Hits != 2 || stats.Misses != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// A variant for an entry that was replaced meanwhile is not counted
+	stale, _ := cache.get(owner, "a.bin")
+	add("a.bin")
+	cache.addVariant(stale, "gzip", []byte("zz"))
+	if stats := cache.stats(); stats.Size != 80 {
+		t.Errorf("expected a variant of a replaced entry to be dropped, got size %d", stats.Size)
+	}
+
+	os.WriteFile(filepath.Join(root, "big.bin"), []byte(strings.Repeat("x", 60)), 0o644)
+	info, _ := os.Stat(filepath.Join(root, "big.bin"))
+	if _, ok := cache.add(owner, "big.bin", info, ""); ok {
+		t.Errorf("expected files above max_file_size to bypass the cache")
+	}
+}
+
+func TestMemoryCacheServing(t *testing.T) {
+	root := t.TempDir()
+	script := strings.Repeat("console.log('cached');\n", 200)
+	os.WriteFile(filepath.Join(root, "app.js"), []byte(script), 0o644)
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>v1</h1>"), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	adminToken := strings.Repeat("t", 32)
+	config.Performance.MemoryCache = &MemoryCacheConfig{Enabled: true, AdminRoute: "/_cache", AdminToken: adminToken}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	server := NewServer(config, logger)
+	handler := server.Handler()
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/app.js", "gzip")
+	second := get("/app.js", "gzip")
+	if first.Header().Get("Content-Encoding") != "gzip" || second.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip variants, got %q and %q", first.Header().Get("Content-Encoding"), second.Header().Get("Content-Encoding"))
+	}
+	if first.Body.String() != second.Body.String() || first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Errorf("expected the cached variant to be reused")
+	}
+	if plain := get("/app.js", ""); plain.Body.String() != script {
+		t.Errorf("expected identity body without Accept-Encoding")
+	}
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for cached variant ETag, got %d", w.Code)
+	}
+
+	stats := server.CacheStats()
+	if stats.Hits < 3 || stats.Entries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	t.Run("InvalidatedOnChange", func(t *testing.T) {
+		get("/index.html", "")
+		later := time.Now().Add(time.Hour)
+		os.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>v2</h1>"), 0o644)
+		os.Chtimes(filepath.Join(root, "index.html"), later, later)
+		if body := get("/index.html", "").Body.String(); body != "<h1>v2</h1>" {
+			t.Errorf("expected changed file to be reloaded, got %q", body)
+		}
+	})
+
+	t.Run("AdminRoute", func(t *testing.T) {
+		for _, authorization := range []string{"", "Bearer wrong", "Basic " + adminToken} {
+			req := httptest.NewRequest("POST", "/_cache", nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Authorization %q: expected 401, got %d", authorization, w.Code)
+			}
+		}
+		if server.CacheStats().Entries == 0 {
+			t.Fatalf("expected unauthorized requests to leave the cache alone")
+		}
+
+		req := httptest.NewRequest("POST", "/_cache", nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var stats CacheStats
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("expected JSON stats, got %q", w.Body.String())
+		}
+		if stats.Entries != 0 || stats.Size != 0 {
+			t.Errorf("expected purged cache, got %+v", stats)
+		}
+	})
+}
+
+func TestPreloadServing(t *testing.T) {
+	root := t.TempDir()
+	script := strings.Repeat("console.log('preloaded');\n", 200)
+	os.WriteFile(filepath.Join(root, "app.js"), []byte(script), 0o644)
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>home</h1>"), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Server.Preload = true
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	server := NewServer(config, logger)
+	handler := server.Handler()
+
+	// Everything is held in memory, gzip variants included
+	os.Remove(filepath.Join(root, "app.js"))
+	os.Remove(filepath.Join(root, "index.html"))
+	stats := server.CacheStats()
+	if stats.Entries != 2 || stats.Size <= int64(len(script)) {
+		t.Fatalf("expected files and variants to be preloaded, got %+v", stats)
+	}
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected gzip from the snapshot, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "<h1>home</h1>" {
+		t.Errorf("expected index from the snapshot, got %q", w.Body.String())
+	}
+	if after := server.CacheStats(); after.Misses != stats.Misses || after.Hits != 2 {
+		t.Errorf("expected requests to be answered from memory, got %+v", after)
+	}
+
+	t.Run("LimitExceeded", func(t *testing.T) {
+		os.WriteFile(filepath.Join(root, "app.js"), []byte(script), 0o644)
+		config := DefaultConfig()
+		config.Server.RootDir = root
+		config.Server.Preload = true
+		config.Server.PreloadMaxSize = int64(len(script)) + 10
+		if _, err := NewHandler(config, logger); err == nil {
+			t.Errorf("expected error when compressed variants exceed the limit")
+		}
+	})
+}
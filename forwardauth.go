@@ -0,0 +1,220 @@
+package koryxserv
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// forwardAuthMethod is the Identity.Method of forward auth users
+	forwardAuthMethod = "forward"
+	// forwardAuthMaxBody bounds the auth response body passed to clients
+	forwardAuthMaxBody = 64 << 10
+	// forwardAuthCacheSize bounds the cached decisions
+	forwardAuthCacheSize = 1024
+)
+
+// forwardAuthSkipHeaders are not copied between the client, the auth
+// service and the response: hop-by-hop headers and the body framing
+var forwardAuthSkipHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+}
+
+// forwardAuthDecision is the auth service's answer for a request
+type forwardAuthDecision struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func (d *forwardAuthDecision) allowed() bool {
+	return d.status >= 200 && d.status < 300
+}
+
+// forwardAuth asks an external service whether requests may be served
+type forwardAuth struct {
+	config *ForwardAuthConfig
+	logger *Logger
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*forwardAuthDecision
+}
+
+func newForwardAuth(config *ForwardAuthConfig, logger *Logger) *forwardAuth {
+	return &forwardAuth{
+		config: config,
+		logger: logger,
+		client: &http.Client{
+			Timeout: config.GetTimeout(),
+			// Redirects, e.g. to a login page, go back to the client
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		cache: make(map[[sha256.Size]byte]*forwardAuthDecision),
+	}
+}
+
+// cacheKey returns the key of requests whose decision may be cached:
+// those with credentials. The decision may depend on everything the service
+// is told, so the key covers the method, host and URI with the credentials.
+func (f *forwardAuth) cacheKey(r *http.Request) ([sha256.Size]byte, bool) {
+	authorization, cookie := r.Header.Get("Authorization"), r.Header.Get("Cookie")
+	if f.config.CacheTTL <= 0 || (authorization == "" && cookie == "") {
+		return [sha256.Size]byte{}, false
+	}
+	key := strings.Join([]string{r.Method, r.Host, r.URL.RequestURI(), authorization, cookie}, "\x00")
+	return sha256.Sum256([]byte(key)), true
+}
+
+// check returns the decision for a request, from the cache or the service
+func (f *forwardAuth) check(r *http.Request) (*forwardAuthDecision, error) {
+	key, cacheable := f.cacheKey(r)
+	if cacheable {
+		f.mu.Lock()
+		decision, ok := f.cache[key]
+		f.mu.Unlock()
+		if ok && time.Now().Before(decision.expires) {
+			return decision, nil
+		}
+	}
+
+	decision, err := f.ask(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Server errors are not decisions; they are retried
+	if cacheable && decision.status < 500 {
+		decision.expires = time.Now().Add(time.Duration(f.config.CacheTTL) * time.Second)
+		f.mu.Lock()
+		if len(f.cache) >= forwardAuthCacheSize {
+			clear(f.cache)
+		}
+		f.cache[key] = decision
+		f.mu.Unlock()
+	}
+	return decision, nil
+}
+
+// ask sends the subrequest: the original method and headers, without the
+// body, with the original URI in X-Forwarded-* headers
+func (f *forwardAuth) ask(r *http.Request) (*forwardAuthDecision, error) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, f.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.Header {
+		if !forwardAuthSkipHeaders[name] {
+			req.Header[name] = values
+		}
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", requestScheme(r))
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	req.Header.Set("X-Forwarded-For", ClientIP(r))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, forwardAuthMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	return &forwardAuthDecision{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// ValidateForwardAuth checks the auth service URL and the limits
+func ValidateForwardAuth(config *ForwardAuthConfig) error {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("forward_auth url must be an http or https URL: %q", config.URL)
+	}
+	if config.Timeout < 0 {
+		return fmt.Errorf("invalid forward_auth timeout: %d", config.Timeout)
+	}
+	if config.CacheTTL < 0 {
+		return fmt.Errorf("invalid forward_auth cache_ttl: %d", config.CacheTTL)
+	}
+	for _, name := range config.ResponseHeaders {
+		if name == "" || forwardAuthSkipHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("invalid forward_auth response header %q", name)
+		}
+	}
+	return nil
+}
+
+// ForwardAuthMiddleware delegates access decisions to an external service,
+// like nginx auth_request. A 2xx answer serves the request, with the
+// configured response headers copied onto it (and dropped from the client's
+// request, so they cannot be forged). Any other answer, such as 401, 403 or
+// a redirect to a login page, is passed to the client as is. Public paths
+// skip the check; they are matched on the cleaned path.
+func ForwardAuthMiddleware(config *SecurityConfig, logger *Logger) Middleware {
+	public, err := compilePathGlobs(config.PublicPaths)
+	if err != nil {
+		logger.Error("Ignoring public_paths: %v", err)
+		public = nil
+	}
+	auth := newForwardAuth(config.ForwardAuth, logger)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !config.ForwardAuth.Enabled || matchFirstGlob(public, r.URL.Path) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			decision, err := auth.check(r)
+			if err != nil {
+				logger.Error("Forward auth request failed: %v", err)
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				return
+			}
+
+			if !decision.allowed() {
+				for name, values := range decision.header {
+					if !forwardAuthSkipHeaders[name] {
+						w.Header()[name] = values
+					}
+				}
+				w.WriteHeader(decision.status)
+				w.Write(decision.body)
+				return
+			}
+
+			r = r.Clone(r.Context())
+			for _, name := range auth.config.ResponseHeaders {
+				r.Header.Del(name)
+				if values := decision.header.Values(name); len(values) > 0 {
+					r.Header[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+			if name := auth.config.UserHeader; name != "" {
+				if user := decision.header.Get(name); user != "" {
+					r = withIdentity(r, &Identity{User: user, Method: forwardAuthMethod})
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestForwardAuthMiddleware(t *testing.T) {
+	var calls atomic.Int32
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("X-Forwarded-Uri") == "" || r.Header.Get("X-Forwarded-Method") != r.Method {
+			t.Errorf("expected the original request in X-Forwarded-* headers, got %v", r.Header)
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+			if strings.HasPrefix(r.Header.Get("X-Forwarded-Uri"), "/private/") {
+				http.Error(w, "not for alice", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("X-Auth-User", "alice")
+			w.Header().Set("X-Auth-Groups", "ops")
+			w.WriteHeader(http.StatusOK)
+		case "":
+			w.Header().Set("Location", "https://login.example.com/?rd="+r.Header.Get("X-Forwarded-Uri"))
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "token rejected", http.StatusUnauthorized)
+		}
+	}))
+	defer authService.Close()
+
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	config := &SecurityConfig{
+		PublicPaths: []string{"/healthz"},
+		ForwardAuth: &ForwardAuthConfig{
+			Enabled:         true,
+			URL:             authService.URL,
+			ResponseHeaders: []string{"X-Auth-User", "X-Auth-Groups"},
+			UserHeader:      "X-Auth-User",
+			CacheTTL:        60,
+		},
+	}
+	var upstream *http.Request
+	handler := ForwardAuthMiddleware(config, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r
+	}))
+
+	serve := func(path, authorization string, forged bool) *httptest.ResponseRecorder {
+		upstream = nil
+		req := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		if forged {
+			req.Header.Set("X-Auth-Groups", "admins")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("/healthz", "", false); w.Code != http.StatusOK || calls.Load() != 0 {
+		t.Errorf("expected public path without a subrequest, got %d after %d calls", w.Code, calls.Load())
+	}
+
+	w := serve("/docs/?a=1", "", false)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://login.example.com/?rd=/docs/?a=1" {
+		t.Errorf("expected the login redirect to pass through, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = serve("/docs/", "Bearer bad", false)
+	if w.Code != http.StatusUnauthorized || w.Body.String() != "token rejected\n" || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected the 401 answer to pass through, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve("/docs/", "Bearer good", true)
+	if w.Code != http.StatusOK || upstream == nil {
+		t.Fatalf("expected request to be served, got %d", w.Code)
+	}
+	if upstream.Header.Get("X-Auth-User") != "alice" || upstream.Header.Get("X-Auth-Groups") != "ops" || AuthenticatedUser(upstream) != "alice" {
+		t.Errorf("expected auth headers from the service, got %v", upstream.Header)
+	}
+
+	// Decisions are cached per request and credentials
+	before := calls.Load()
+	serve("/docs/", "Bearer good", false)
+	serve("/docs/", "Bearer bad", false)
+	if calls.Load() != before {
+		t.Errorf("expected cached decisions, got %d more calls", calls.Load()-before)
+	}
+	serve("/docs/", "", false)
+	if calls.Load() != before+1 {
+		t.Errorf("expected requests without credentials to be asked every time")
+	}
+
+	// An allowed path does not answer for another one
+	if w := serve("/private/secret.txt", "Bearer good", false); w.Code != http.StatusForbidden || upstream != nil {
+		t.Errorf("expected the deny for another path, got %d", w.Code)
+	}
+	if w := serve("/private/secret.txt", "Bearer good", false); w.Code != http.StatusForbidden || upstream != nil {
+		t.Errorf("expected the cached deny, got %d", w.Code)
+	}
+
+	// Public paths are matched on the cleaned path
+	before = calls.Load()
+	if w := serve("/healthz/%2e%2e/private/secret.txt", "", false); w.Code != http.StatusFound || calls.Load() != before+1 {
+		t.Errorf("expected dot segments not to reach a public path, got %d", w.Code)
+	}
+}
+
+func TestForwardAuthUnavailable(t *testing.T) {
+	authService := httptest.NewServer(http.NotFoundHandler())
+	authService.Close()
+
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := ForwardAuthMiddleware(&SecurityConfig{
+		ForwardAuth: &ForwardAuthConfig{Enabled: true, URL: authService.URL},
+	}, logger)(testHandler())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when the auth service is down, got %d", w.Code)
+	}
+}
@@ -0,0 +1,358 @@
+package koryxserv
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultPreloadMaxSize bounds a preloaded snapshot when no limit is configured
+const defaultPreloadMaxSize = 256 << 20
+
+// OpenRootFS builds the filesystem described by the server configuration.
+// root_dir may be a directory or an archive (.zip, .tar, .tar.gz, .tgz);
+// every entry of layers is stacked beneath it, and the first layer holding
+// a path wins. With preload set the result is snapshotted into memory.
+func OpenRootFS(config *ServerConfig) (fs.FS, error) {
+	root, err := openFSLayer(config.RootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := root
+	if len(config.Layers) > 0 {
+		layers := []fs.FS{root}
+		for _, layer := range config.Layers {
+			layerFS, err := openFSLayer(layer)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, layerFS)
+		}
+		fsys = NewOverlayFS(layers...)
+	}
+
+	if config.Preload {
+		return SnapshotFS(fsys, config.GetPreloadMaxSize())
+	}
+	return fsys, nil
+}
+
+// SnapshotFS copies every file of fsys into a read-only in-memory
+// filesystem. It fails once the files add up to more than maxSize bytes.
+func SnapshotFS(fsys fs.FS, maxSize int64) (fs.FS, error) {
+	// Archives are already held in memory
+	if mem, ok := fsys.(*memFS); ok {
+		if size := mem.size(); size > maxSize {
+			return nil, fmt.Errorf("preload: snapshot needs %d bytes, limit is %d", size, maxSize)
+		}
+		return mem, nil
+	}
+
+	snapshot := newMemFS()
+	var total int64
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		// Stat follows symlinks, like serving does
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			snapshot.addDir(name, info.ModTime())
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		total += info.Size()
+		if total > maxSize {
+			return fmt.Errorf("preload: snapshot exceeds the limit of %d bytes", maxSize)
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		snapshot.addFile(name, data, info.Mode(), info.ModTime())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// openFSLayer opens a directory or archive as an fs.FS
+func openFSLayer(location string) (fs.FS, error) {
+	if IsArchivePath(location) {
+		return OpenArchiveFS(location)
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is neither a directory nor a supported archive", location)
+	}
+	return os.DirFS(location), nil
+}
+
+// IsArchivePath reports whether the file name has a supported archive extension
+func IsArchivePath(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenArchiveFS loads a zip or (gzipped) tar archive into a read-only
+// in-memory filesystem.
+func OpenArchiveFS(archivePath string) (fs.FS, error) {
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return loadZipFS(archivePath)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return loadTarFS(archivePath, true)
+	case strings.HasSuffix(lower, ".tar"):
+		return loadTarFS(archivePath, false)
+	}
+	return nil, fmt.Errorf("unsupported archive format: %s", archivePath)
+}
+
+func loadZipFS(archivePath string) (fs.FS, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive %s: %w", archivePath, err)
+	}
+	defer reader.Close()
+
+	mem := newMemFS()
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			mem.addDir(file.Name, file.Modified)
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from %s: %w", file.Name, archivePath, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from %s: %w", file.Name, archivePath, err)
+		}
+		mem.addFile(file.Name, data, file.Mode(), file.Modified)
+	}
+	return mem, nil
+}
+
+func loadTarFS(archivePath string, gzipped bool) (fs.FS, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tar archive %s: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tar archive %s: %w", archivePath, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	mem := newMemFS()
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive %s: %w", archivePath, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			mem.addDir(header.Name, header.ModTime)
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s from %s: %w", header.Name, archivePath, err)
+			}
+			mem.addFile(header.Name, data, header.FileInfo().Mode(), header.ModTime)
+		}
+	}
+	return mem, nil
+}
+
+// overlayFS stacks several filesystems; lookups go through the layers in
+// order and directory listings are merged (upper layers shadow lower ones).
+type overlayFS struct {
+	layers []fs.FS
+}
+
+// NewOverlayFS returns a read-only filesystem made of the given layers.
+// The first layer has the highest priority.
+func NewOverlayFS(layers ...fs.FS) fs.FS {
+	return &overlayFS{layers: layers}
+}
+
+// Open implements fs.FS
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, layer := range o.layers {
+		file, err := layer.Open(name)
+		if err != nil {
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			continue
+		}
+		if info.IsDir() {
+			return &overlayDir{File: file, fsys: o, name: name}, nil
+		}
+		return file, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Stat implements fs.StatFS
+func (o *overlayFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, layer := range o.layers {
+		if info, err := fs.Stat(layer, name); err == nil {
+			return info, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	found := false
+	for _, layer := range o.layers {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			continue
+		}
+		found = true
+		for _, entry := range layerEntries {
+			if seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+			entries = append(entries, entry)
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// overlayDir is a directory handle whose listing merges all layers
+type overlayDir struct {
+	fs.File
+	fsys    *overlayFS
+	name    string
+	entries []fs.DirEntry
+	pos     int
+	loaded  bool
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+
+	remaining := d.entries[d.pos:]
+	if n <= 0 {
+		d.pos = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.pos += n
+	return remaining[:n], nil
+}
+
+// fsName converts a URL path into an fs.FS name ("." for the root)
+func fsName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// openSeekable opens a file as an io.ReadSeeker for http.ServeContent.
+// Files that cannot seek are read into memory.
+func openSeekable(fsys fs.FS, name string) (io.ReadSeeker, func() error, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		return seeker, file.Close, nil
+	}
+
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(data), func() error { return nil }, nil
+}
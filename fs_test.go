@@ -0,0 +1,190 @@
+package koryxserv
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func writeZipArchive(t *testing.T, archivePath string, files map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+}
+
+func writeTarGzArchive(t *testing.T, archivePath string, files map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create tar: %v", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			ModTime:  time.Unix(1700000000, 0),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+}
+
+func TestOpenArchiveFS(t *testing.T) {
+	files := map[string]string{
+		"index.html":        "home",
+		"assets/app.js":     "js",
+		"assets/css/a.css":  "css",
+		"docs/guide/1.html": "guide",
+	}
+	dir := t.TempDir()
+
+	for _, name := range []string{"site.zip", "site.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			archivePath := filepath.Join(dir, name)
+			if filepath.Ext(name) == ".zip" {
+				writeZipArchive(t, archivePath, files)
+			} else {
+				writeTarGzArchive(t, archivePath, files)
+			}
+
+			fsys, err := OpenArchiveFS(archivePath)
+			if err != nil {
+				t.Fatalf("OpenArchiveFS failed: %v", err)
+			}
+			if err := fstest.TestFS(fsys, "index.html", "assets/app.js", "assets/css/a.css", "docs/guide/1.html"); err != nil {
+				t.Fatalf("archive filesystem misbehaves: %v", err)
+			}
+
+			data, err := fs.ReadFile(fsys, "assets/css/a.css")
+			if err != nil || string(data) != "css" {
+				t.Fatalf("expected css content, got %q (%v)", data, err)
+			}
+		})
+	}
+
+	if _, err := OpenArchiveFS(filepath.Join(dir, "site.rar")); err == nil {
+		t.Fatalf("expected error for unsupported archive")
+	}
+}
+
+func TestOverlayFS(t *testing.T) {
+	upper := fstest.MapFS{
+		"index.html":    {Data: []byte("upper index")},
+		"assets/app.js": {Data: []byte("upper js")},
+	}
+	lower := fstest.MapFS{
+		"index.html":       {Data: []byte("lower index")},
+		"assets/vendor.js": {Data: []byte("vendor")},
+		"robots.txt":       {Data: []byte("robots")},
+	}
+	overlay := NewOverlayFS(upper, lower)
+
+	if err := fstest.TestFS(overlay, "index.html", "assets/app.js", "assets/vendor.js", "robots.txt"); err != nil {
+		t.Fatalf("overlay filesystem misbehaves: %v", err)
+	}
+
+	data, _ := fs.ReadFile(overlay, "index.html")
+	if string(data) != "upper index" {
+		t.Errorf("expected upper layer to win, got %q", data)
+	}
+
+	entries, err := fs.ReadDir(overlay, "assets")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "app.js" || entries[1].Name() != "vendor.js" {
+		t.Errorf("expected merged listing [app.js vendor.js], got %v", entries)
+	}
+}
+
+func TestOpenRootFSWithLayers(t *testing.T) {
+	root := t.TempDir()
+	fallback := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("root"), 0o644)
+	os.WriteFile(filepath.Join(fallback, "404.html"), []byte("fallback"), 0o644)
+
+	fsys, err := OpenRootFS(&ServerConfig{RootDir: root, Layers: []string{fallback}})
+	if err != nil {
+		t.Fatalf("OpenRootFS failed: %v", err)
+	}
+	if data, _ := fs.ReadFile(fsys, "404.html"); string(data) != "fallback" {
+		t.Errorf("expected file from lower layer, got %q", data)
+	}
+
+	if _, err := OpenRootFS(&ServerConfig{RootDir: filepath.Join(root, "index.html")}); err == nil {
+		t.Errorf("expected error when root is a plain file")
+	}
+}
+
+func TestSnapshotFS(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "assets"), 0o755)
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>home</h1>"), 0o644)
+	os.WriteFile(filepath.Join(root, "assets", "app.js"), []byte("console.log(1)"), 0o644)
+
+	fsys, err := OpenRootFS(&ServerConfig{RootDir: root, Preload: true})
+	if err != nil {
+		t.Fatalf("OpenRootFS failed: %v", err)
+	}
+
+	// Changes on disk are not visible in the snapshot
+	os.Remove(filepath.Join(root, "assets", "app.js"))
+	if data, _ := fs.ReadFile(fsys, "assets/app.js"); string(data) != "console.log(1)" {
+		t.Errorf("expected snapshot content, got %q", data)
+	}
+	if entries, _ := fs.ReadDir(fsys, "."); len(entries) != 2 {
+		t.Errorf("expected 2 root entries, got %d", len(entries))
+	}
+
+	if _, err := SnapshotFS(os.DirFS(root), 5); err == nil {
+		t.Errorf("expected error when the snapshot exceeds the limit")
+	}
+}
+
+func TestFSName(t *testing.T) {
+	tests := map[string]string{
+		"/":                ".",
+		"":                 ".",
+		"/index.html":      "index.html",
+		"/a/b/../c.txt":    "a/c.txt",
+		"/../../etc/hosts": "etc/hosts",
+	}
+	for input, want := range tests {
+		if got := fsName(input); got != want {
+			t.Errorf("fsName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package koryxserv
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// pathGlob is a compiled URL path pattern.
+//
+// Patterns are matched segment by segment against the cleaned request path.
+// Each segment supports the path.Match syntax (*, ?, [...]) and a segment that
+// is exactly "**" matches zero or more whole segments. A pattern without any
+// "/" matches the last path segment at any depth, so "*.map" behaves like
+// "/**/*.map".
+type pathGlob struct {
+	pattern  string
+	segments []string
+}
+
+// compilePathGlob parses and validates a path pattern
+func compilePathGlob(pattern string) (*pathGlob, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty path pattern")
+	}
+
+	normalized := pattern
+	if !strings.Contains(normalized, "/") {
+		normalized = "/**/" + normalized
+	}
+
+	segments := splitPathSegments(normalized)
+	for _, segment := range segments {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+	}
+
+	return &pathGlob{pattern: pattern, segments: segments}, nil
+}
+
+// compilePathGlobs compiles a list of path patterns
+func compilePathGlobs(patterns []string) ([]*pathGlob, error) {
+	globs := make([]*pathGlob, 0, len(patterns))
+	for _, pattern := range patterns {
+		glob, err := compilePathGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+// ValidatePathPatterns reports the first invalid pattern in the list
+func ValidatePathPatterns(patterns []string) error {
+	_, err := compilePathGlobs(patterns)
+	return err
+}
+
+// Match reports whether the URL path matches the pattern
+func (g *pathGlob) Match(urlPath string) bool {
+	return matchSegments(g.segments, splitPathSegments(cleanURLPath(urlPath)))
+}
+
+// String returns the pattern as it was configured
+func (g *pathGlob) String() string {
+	return g.pattern
+}
+
+// matchFirstGlob returns the first glob matching the path, or nil
+func matchFirstGlob(globs []*pathGlob, urlPath string) *pathGlob {
+	segments := splitPathSegments(cleanURLPath(urlPath))
+	for _, glob := range globs {
+		if matchSegments(glob.segments, segments) {
+			return glob
+		}
+	}
+	return nil
+}
+
+// cleanURLPath resolves "." and ".." segments of a URL path, which may
+// still hold them after percent-decoding ("/a/%2e%2e/b"), so that checks
+// see the path that is eventually served
+func cleanURLPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func splitPathSegments(p string) []string {
+	trimmed := strings.Trim(p, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive ** segments
+			rest := pattern[1:]
+			for len(rest) > 0 && rest[0] == "**" {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(rest, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+	return len(segments) == 0
+}
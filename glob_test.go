@@ -0,0 +1,55 @@
+package koryxserv
+
+import "testing"
+
+func TestPathGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/admin", "/admin", true},
+		{"/admin", "/admin/users", false},
+		{"/admin/*", "/admin/users", true},
+		{"/admin/*", "/admin/users/1", false},
+		{"/admin/**", "/admin", true},
+		{"/admin/**", "/admin/users/1", true},
+		{"/admin/**", "/administrator", false},
+		{"/**/*.map", "/assets/js/app.js.map", true},
+		{"/**/*.map", "/app.js.map", true},
+		{"*.map", "/assets/app.js.map", true},
+		{"*.map", "/assets/app.js", false},
+		{"/assets/**/secret/*.txt", "/assets/a/b/secret/x.txt", true},
+		{"/assets/**/secret/*.txt", "/assets/secret/x.txt", true},
+		{"/assets/**/secret/*.txt", "/assets/secret/sub/x.txt", false},
+		{"/file-?.txt", "/file-1.txt", true},
+		{"/file-[0-9].txt", "/file-a.txt", false},
+		{"/", "/", true},
+		{"/**", "/", true},
+		{"/public/**", "/public/../admin/secret.txt", false},
+		{"/admin/**", "/public/../admin/secret.txt", true},
+		{"/admin/*", "/admin/./users", true},
+	}
+
+	for _, tt := range tests {
+		glob, err := compilePathGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("compilePathGlob(%q) returned error: %v", tt.pattern, err)
+		}
+		if got := glob.Match(tt.path); got != tt.want {
+			t.Errorf("pattern %q, path %q: expected %v, got %v", tt.pattern, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestCompilePathGlobInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "/files/[a-"} {
+		if _, err := compilePathGlob(pattern); err == nil {
+			t.Errorf("expected error for pattern %q", pattern)
+		}
+	}
+
+	if err := ValidatePathPatterns([]string{"/ok/**", "/bad/[x"}); err == nil {
+		t.Errorf("expected ValidatePathPatterns to reject invalid pattern")
+	}
+}
@@ -1,3 +1,11 @@
 module koryx-serv
 
 go 1.24.7
+
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.48.0
+)
+
+require golang.org/x/sys v0.41.0 // indirect
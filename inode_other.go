@@ -0,0 +1,10 @@
+//go:build !unix
+
+package koryxserv
+
+import "io/fs"
+
+// fileInode returns the inode number of a file, or 0 when unknown
+func fileInode(info fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package koryxserv
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileInode returns the inode number of a file, or 0 when unknown
+func fileInode(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+package koryxserv
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// ipSet holds IP ranges merged and sorted for binary search, so lookups
+// stay cheap with thousands of entries
+type ipSet struct {
+	v4 []ipRange
+	v6 []ipRange
+}
+
+// ipRange is an inclusive address range
+type ipRange struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+func newIPSet(prefixes []netip.Prefix) *ipSet {
+	set := &ipSet{}
+	for _, prefix := range prefixes {
+		r := ipRange{first: prefix.Addr(), last: lastAddr(prefix)}
+		if r.first.Is4() {
+			set.v4 = append(set.v4, r)
+		} else {
+			set.v6 = append(set.v6, r)
+		}
+	}
+	set.v4 = mergeIPRanges(set.v4)
+	set.v6 = mergeIPRanges(set.v6)
+	return set
+}
+
+// lastAddr returns the highest address of a masked prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// mergeIPRanges sorts ranges and joins overlapping or adjacent ones
+func mergeIPRanges(ranges []ipRange) []ipRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first.Less(ranges[j].first) })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.first.Compare(last.last) <= 0 || r.first == last.last.Next() {
+			if r.last.Compare(last.last) > 0 {
+				last.last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// contains reports whether addr lies in the set. IPv4-mapped IPv6
+// addresses match IPv4 ranges.
+func (s *ipSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	ranges := s.v6
+	if addr.Is4() {
+		ranges = s.v4
+	}
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].last.Compare(addr) >= 0 })
+	return i < len(ranges) && ranges[i].first.Compare(addr) <= 0
+}
+
+// containsIP is contains for an address in text form
+func (s *ipSet) containsIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && s.contains(addr)
+}
+
+func (s *ipSet) size() int {
+	return len(s.v4) + len(s.v6)
+}
+
+// ipList is an IP filter list made of configured entries and an optional
+// file, which is reloaded when it changes
+type ipList struct {
+	name    string // config key, for log messages
+	entries []netip.Prefix
+	file    *watchedFile
+	logger  *Logger
+
+	set atomic.Pointer[ipSet]
+}
+
+// newIPList builds a list. Invalid entries are logged and skipped; nil is
+// returned when neither entries nor a file are configured.
+func newIPList(name string, entries []string, file string, logger *Logger) *ipList {
+	if len(entries) == 0 && file == "" {
+		return nil
+	}
+
+	list := &ipList{name: name, logger: logger}
+	for _, entry := range entries {
+		prefix, err := parseIPRange(entry)
+		if err != nil {
+			logger.Error("Ignoring %s entry: %v", name, err)
+			continue
+		}
+		list.entries = append(list.entries, prefix)
+	}
+	list.set.Store(newIPSet(list.entries))
+	if file != "" {
+		list.file = newWatchedFile(file)
+		list.file.poll(list.load)
+	}
+	return list
+}
+
+// current returns the active set, reloading the file first when it changed
+func (l *ipList) current() *ipSet {
+	if l.file != nil {
+		l.file.poll(l.load)
+	}
+	return l.set.Load()
+}
+
+// load replaces the file ranges. A file that cannot be read keeps the
+// previous list; invalid lines are logged and skipped.
+func (l *ipList) load(data []byte, err error) {
+	if err != nil {
+		l.logger.Error("Keeping previous %s: %v", l.name, err)
+		return
+	}
+	prefixes, errs := parseIPListFile(string(data))
+	for _, err := range errs {
+		l.logger.Warn("Skipping %s %v", l.file.path, err)
+	}
+
+	set := newIPSet(append(append([]netip.Prefix(nil), l.entries...), prefixes...))
+	l.set.Store(set)
+	l.logger.Info("Loaded %d ranges for %s from %s", set.size(), l.name, l.file.path)
+}
+
+// parseIPListFile parses one IP or CIDR range per line; blank lines and
+// "#" comments are ignored
+func parseIPListFile(data string) ([]netip.Prefix, []error) {
+	var prefixes []netip.Prefix
+	var errs []error
+	for i, line := range strings.Split(data, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		prefix, err := parseIPRange(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, errs
+}
+
+// ValidateIPListFile checks that a whitelist/blacklist file can be read and
+// holds only IPs and CIDR ranges
+func ValidateIPListFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if _, errs := parseIPListFile(string(data)); len(errs) > 0 {
+		return fmt.Errorf("%s %w", file, errs[0])
+	}
+	return nil
+}
+
+// IPFilterMiddleware filters clients by IP address and CIDR range.
+// Blacklisted clients are always refused; when a whitelist (entries or
+// file) is configured, clients outside it are refused too.
+func IPFilterMiddleware(config *SecurityConfig, logger *Logger) Middleware {
+	whitelist := newIPList("ip_whitelist", config.IPWhitelist, config.IPWhitelistFile, logger)
+	blacklist := newIPList("ip_blacklist", config.IPBlacklist, config.IPBlacklistFile, logger)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(ClientIP(r))
+			valid := err == nil
+
+			// Check blacklist first
+			if blacklist != nil && valid && blacklist.current().contains(addr) {
+				http.Error(w, "403 Forbidden", http.StatusForbidden)
+				return
+			}
+
+			// If a whitelist exists, the client must be in it
+			if whitelist != nil && (!valid || !whitelist.current().contains(addr)) {
+				http.Error(w, "403 Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package koryxserv
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIPSet(t *testing.T) {
+	prefixes, err := parseIPRanges([]string{
+		"10.0.0.0/8",
+		"10.1.0.0/16", // inside the previous range
+		"192.168.1.0/25",
+		"192.168.1.128/25", // adjacent, merged with the previous range
+		"203.0.113.7",
+		"2001:db8::/32",
+		"::ffff:198.51.100.0/120", // IPv4-mapped, stored as 198.51.100.0/24
+	})
+	if err != nil {
+		t.Fatalf("parseIPRanges failed: %v", err)
+	}
+	set := newIPSet(prefixes)
+	if len(set.v4) != 4 || len(set.v6) != 1 {
+		t.Errorf("expected merged ranges, got %d IPv4 and %d IPv6", len(set.v4), len(set.v6))
+	}
+
+	tests := map[string]bool{
+		"10.255.255.255":           true,
+		"11.0.0.0":                 false,
+		"192.168.1.200":            true,
+		"192.168.2.1":              false,
+		"203.0.113.7":              true,
+		"203.0.113.8":              false,
+		"2001:db8:1234::1":         true,
+		"2001:db9::1":              false,
+		"::ffff:10.1.2.3":          true, // IPv4-mapped client address
+		"198.51.100.42":            true,
+		"fe80::1%eth0":             false,
+		"::ffff:192.168.1.1":       true,
+		"2001:db8:ffff:ffff::ffff": true,
+	}
+	for ip, want := range tests {
+		if got := set.containsIP(ip); got != want {
+			t.Errorf("containsIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if _, err := parseIPRanges([]string{"10.0.0.0/40"}); err == nil {
+		t.Errorf("expected error for invalid prefix length")
+	}
+	if _, err := parseIPRanges([]string{"::ffff:10.0.0.0/90"}); err == nil {
+		t.Errorf("expected error for short IPv4-mapped prefix")
+	}
+}
+
+func TestIPSetLargeList(t *testing.T) {
+	var prefixes []netip.Prefix
+	for i := 0; i < 5000; i++ {
+		prefix, _ := parseIPRange(fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+		prefixes = append(prefixes, prefix)
+		prefix, _ = parseIPRange(fmt.Sprintf("172.%d.%d.1", 16+i/256, i%256))
+		prefixes = append(prefixes, prefix)
+	}
+	set := newIPSet(prefixes)
+	if !set.containsIP("10.19.135.77") || !set.containsIP("172.20.10.1") || set.containsIP("172.20.10.2") {
+		t.Errorf("unexpected lookup results in large list")
+	}
+}
+
+func TestIPFilterFileReload(t *testing.T) {
+	listFile := filepath.Join(t.TempDir(), "blocked.txt")
+	os.WriteFile(listFile, []byte("# abusers\n198.51.100.0/24\n"), 0o644)
+
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := IPFilterMiddleware(&SecurityConfig{
+		IPBlacklist:     []string{"203.0.113.7"},
+		IPBlacklistFile: listFile,
+	}, logger)(testHandler())
+
+	status := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status("198.51.100.9:1000") != http.StatusForbidden || status("203.0.113.7:1000") != http.StatusForbidden {
+		t.Fatalf("expected entries from the file and the config to be blocked")
+	}
+	if status("192.0.2.1:1000") != http.StatusOK {
+		t.Fatalf("expected other clients to be allowed")
+	}
+
+	later := time.Now().Add(time.Hour)
+	os.WriteFile(listFile, []byte("192.0.2.0/24 # new abuser\nnot-an-ip\n"), 0o644)
+	os.Chtimes(listFile, later, later)
+	time.Sleep(watchedFileCheckInterval + 50*time.Millisecond)
+
+	if status("192.0.2.1:1000") != http.StatusForbidden {
+		t.Errorf("expected reloaded file to block the new range")
+	}
+	if status("198.51.100.9:1000") != http.StatusOK {
+		t.Errorf("expected ranges removed from the file to be allowed")
+	}
+	if status("203.0.113.7:1000") != http.StatusForbidden {
+		t.Errorf("expected configured entries to survive a reload")
+	}
+}
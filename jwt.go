@@ -0,0 +1,259 @@
+package koryxserv
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// jwtKey is a verification key, from a JWKS file or a shared secret
+type jwtKey struct {
+	kid string
+	alg string // restricts the key to one algorithm when set
+	key any    // []byte, *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+}
+
+// jwtHeader is the JOSE header of a signed token
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+// jwtHashes maps the supported algorithms to their hash; EdDSA hashes
+// internally
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+// verifyJWT checks the signature of a compact JWS token against the keys
+// and returns its claims. Claims such as exp are checked by the caller.
+func verifyJWT(token string, keys []jwtKey) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	if _, ok := jwtHashes[header.Alg]; !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("unsupported critical header %q", header.Crit[0])
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if header.Kid != "" && key.kid != "" && key.kid != header.Kid {
+			continue
+		}
+		if key.alg != "" && key.alg != header.Alg {
+			continue
+		}
+		if verifyJWTSignature(header.Alg, key.key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature reports whether signature is valid for signed under
+// alg; keys of the wrong type never verify
+func verifyJWTSignature(alg string, key any, signed, signature []byte) bool {
+	hash := jwtHashes[alg]
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(alg, "HS") {
+			return false
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+
+	case *rsa.PublicKey:
+		switch {
+		case strings.HasPrefix(alg, "RS"):
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+		case strings.HasPrefix(alg, "PS"):
+			return rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+
+	case *ecdsa.PublicKey:
+		// ES256 signs on P-256, ES384 on P-384 and ES512 on P-521
+		curves := map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}
+		if curves[alg] != k.Curve {
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s)
+
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, signed, signature)
+	}
+	return false
+}
+
+// jsonWebKey is one entry of a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// parseJWKS parses the signature keys of a JWKS document. Encryption keys
+// and unknown key types are skipped.
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	var keys []jwtKey
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if jwk.Alg != "" {
+			if _, ok := jwtHashes[jwk.Alg]; !ok {
+				continue
+			}
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		if key != nil {
+			keys = append(keys, jwtKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signature keys")
+	}
+	return keys, nil
+}
+
+// publicKey decodes the key material, or returns nil for unknown key types
+func (jwk *jsonWebKey) publicKey() (any, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err1 := decodeJWKInt(jwk.N)
+		e, err2 := decodeJWKInt(jwk.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key of %d bits is too small", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var point ecdh.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve, point = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, point = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, point = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, err1 := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err1 != nil || err2 != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC key")
+		}
+		// Rejects points that are not on the curve
+		if _, err := point.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key (only Ed25519 is supported)")
+		}
+		return ed25519.PublicKey(x), nil
+
+	case "oct":
+		k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(k) < 32 {
+			return nil, errors.New("invalid oct key (at least 32 bytes)")
+		}
+		return k, nil
+	}
+	return nil, nil
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
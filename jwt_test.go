@@ -0,0 +1,280 @@
+package koryxserv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signTestJWT creates a compact JWS token; key is a []byte secret or a
+// private key
+func signTestJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg == "PS256" {
+			signature, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		r, s, signErr := ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		err = signErr
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("signing failed: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+type testJWTKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	jwksDoc []byte
+}
+
+func newTestJWTKeys(t *testing.T) *testJWTKeys {
+	t.Helper()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	doc, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "ec", "alg": "ES256", "crv": "P-256",
+			"x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edKey.Public().(ed25519.PublicKey))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	return &testJWTKeys{rsa: rsaKey, ec: ecKey, ed: edKey, jwksDoc: doc}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	jwks, err := parseJWKS(keys.jwksDoc)
+	if err != nil {
+		t.Fatalf("parseJWKS failed: %v", err)
+	}
+	if len(jwks) != 3 {
+		t.Fatalf("expected 3 signature keys, got %d", len(jwks))
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	all := append(jwks, jwtKey{key: secret})
+	claims := map[string]any{"sub": "alice"}
+
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + "."
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"HS256", signTestJWT(t, "HS256", "", secret, claims), true},
+		{"RS256", signTestJWT(t, "RS256", "rsa", keys.rsa, claims), true},
+		{"PS256", signTestJWT(t, "PS256", "rsa", keys.rsa, claims), true},
+		{"ES256", signTestJWT(t, "ES256", "ec", keys.ec, claims), true},
+		{"EdDSA", signTestJWT(t, "EdDSA", "ed", keys.ed, claims), true},
+		{"RS256 without kid", signTestJWT(t, "RS256", "", keys.rsa, claims), true},
+		{"kid of another key", signTestJWT(t, "RS256", "ec", keys.rsa, claims), false},
+		{"unknown signer", signTestJWT(t, "RS256", "rsa", otherRSA, claims), false},
+		{"wrong secret", signTestJWT(t, "HS256", "", []byte("another secret of at least 32 bytes"), claims), false},
+		{"alg none", unsigned, false},
+		{"malformed", "abc.def", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyJWT(tt.token, all)
+			if tt.valid && (err != nil || got["sub"] != "alice") {
+				t.Errorf("expected valid token, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected token to be rejected")
+			}
+		})
+	}
+
+	// An HMAC token must not verify against a public key used as secret
+	if _, err := verifyJWT(signTestJWT(t, "HS256", "", secret, claims), jwks); err == nil {
+		t.Errorf("expected HS256 token to be rejected without a secret")
+	}
+}
+
+func TestBearerAuthMiddleware(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(jwksFile, keys.jwksDoc, 0o644)
+
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	config := &BearerAuthConfig{
+		Enabled:        true,
+		JWKSFile:       jwksFile,
+		Issuer:         "https://issuer.example",
+		Audience:       "dashboards",
+		RequiredClaims: map[string]string{"email_verified": "true"},
+		Cookie:         "session",
+	}
+	var identity *Identity
+	handler := BearerAuthMiddleware(config, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = AuthenticatedIdentity(r)
+	}))
+
+	now := time.Now().Unix()
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"sub": "alice", "iss": "https://issuer.example", "aud": []string{"other", "dashboards"},
+			"exp": now + 60, "email_verified": true, "groups": []string{"ops", "dev"},
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		cookie bool
+		status int
+	}{
+		{"valid", claims(nil), false, http.StatusOK},
+		{"valid cookie", claims(nil), true, http.StatusOK},
+		{"expired", claims(map[string]any{"exp": now - 10}), false, http.StatusUnauthorized},
+		{"no expiry", claims(map[string]any{"exp": nil}), false, http.StatusUnauthorized},
+		{"not yet valid", claims(map[string]any{"nbf": now + 600}), false, http.StatusUnauthorized},
+		{"wrong issuer", claims(map[string]any{"iss": "https://evil.example"}), false, http.StatusUnauthorized},
+		{"wrong audience", claims(map[string]any{"aud": "other"}), false, http.StatusUnauthorized},
+		{"missing required claim", claims(map[string]any{"email_verified": nil}), false, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity = nil
+			token := signTestJWT(t, "ES256", "ec", keys.ec, tt.claims)
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: "session", Value: token})
+			} else {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Bearer realm="Restricted", error="invalid_token"` {
+				t.Errorf("unexpected challenge %q", w.Header().Get("WWW-Authenticate"))
+			}
+			if w.Code == http.StatusOK && (identity.User != "alice" || !identity.InGroup("ops") || identity.Claims["iss"] != config.Issuer) {
+				t.Errorf("unexpected identity %+v", identity)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="Restricted"` {
+		t.Errorf("expected plain challenge without a token, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// Keys rotate through the JWKS file
+	rotated := newTestJWTKeys(t)
+	later := time.Now().Add(time.Hour)
+	os.WriteFile(jwksFile, rotated.jwksDoc, 0o644)
+	os.Chtimes(jwksFile, later, later)
+	time.Sleep(watchedFileCheckInterval + 50*time.Millisecond)
+
+	for key, want := range map[*ecdsa.PrivateKey]int{keys.ec: http.StatusUnauthorized, rotated.ec: http.StatusOK} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestJWT(t, "ES256", "ec", key, claims(nil)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("expected %d after key rotation, got %d", want, w.Code)
+		}
+	}
+}
+
+func TestAuthRulesWithBearerClaims(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := AuthMiddleware(&SecurityConfig{
+		BasicAuth:  &BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+		BearerAuth: &BearerAuthConfig{Secret: secret},
+		AuthRules: []AuthRuleConfig{
+			{Paths: []string{"/api/write/**"}, Auth: AuthBearer, Claims: map[string]string{"scope": "write"}},
+			{Paths: []string{"/api/**"}, Auth: AuthBearer},
+		},
+	}, logger)(testHandler())
+
+	token := func(scope string) string {
+		return signTestJWT(t, "HS256", "", []byte(secret), map[string]any{
+			"sub": "svc", "scope": scope, "exp": time.Now().Add(time.Minute).Unix(),
+		})
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"read token on read path", "/api/items", token("read"), http.StatusOK},
+		{"read token on write path", "/api/write/items", token("read"), http.StatusForbidden},
+		{"write scope among others", "/api/write/items", token("read write"), http.StatusOK},
+		{"basic credentials on bearer path", "/api/items", "", http.StatusUnauthorized},
+		{"bearer token on default path", "/index.html", token("read"), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			} else {
+				req.SetBasicAuth("admin", "secret")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("expected %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -71,8 +72,8 @@ func (l *Logger) formatTime() string {
 	return time.Now().Format("2006-01-02 15:04:05")
 }
 
-// Access records an access log entry
-func (l *Logger) Access(method, path string, status int, duration time.Duration, remoteAddr string) {
+// Access records an access log entry; user is empty for anonymous requests
+func (l *Logger) Access(method, path string, status int, duration time.Duration, remoteAddr, user string) {
 	if !l.config.Enabled || !l.config.AccessLog {
 		return
 	}
@@ -91,6 +92,10 @@ func (l *Logger) Access(method, path string, status int, duration time.Duration,
 	durationStr := l.colorize(colorGray, duration.String())
 	remoteStr := l.colorize(colorGray, remoteAddr)
 
+	if user != "" {
+		remoteStr += " - " + l.colorize(colorPurple, user)
+	}
+
 	l.accessLog.Printf("[%s] %s %s - %s - %s - %s\n",
 		timestamp, methodStr, pathStr, statusStr, durationStr, remoteStr)
 }
@@ -171,7 +176,8 @@ func (l *Logger) PrintBanner(config *Config) {
 	fmt.Println(l.colorize(colorCyan, banner))
 
 	protocol := "HTTP"
-	if config.Security.EnableHTTPS {
+	tlsConfig := config.GetTLS()
+	if tlsConfig.Enabled {
 		protocol = "HTTPS"
 	}
 
@@ -179,13 +185,59 @@ func (l *Logger) PrintBanner(config *Config) {
 	l.Info("Protocol: %s", protocol)
 	l.Info("Host: %s", config.Server.Host)
 	l.Info("Port: %d", config.Server.Port)
+	if tlsConfig.Enabled {
+		certs := fmt.Sprint(len(tlsConfig.Certificates))
+		if tlsConfig.CertDir != "" {
+			certs += " + " + tlsConfig.CertDir
+		}
+		if tlsConfig.AutoCert != "" {
+			certs += " + " + tlsConfig.AutoCert
+		}
+		l.Info("TLS Certificates: %s", certs)
+		if tlsConfig.ClientAuth != nil {
+			l.Info("Client Certificates: %s", tlsConfig.ClientAuth.Mode)
+		}
+	}
 	l.Info("Root Directory: %s", config.Server.RootDir)
+	if config.Server.Preload {
+		l.Info("Preload: Enabled (limit %d bytes)", config.Server.GetPreloadMaxSize())
+	}
 	l.Info("Directory Listing: %v", config.Features.DirectoryListing)
 	l.Info("SPA Mode: %v", config.Features.SPAMode)
+	if config.Features.SiteFiles {
+		l.Info("Site Files: _redirects, _headers")
+	}
+
+	for _, mount := range config.Mounts {
+		l.Info("Mount: %s/ -> %s", mount.CleanPrefix(), mount.RootDir)
+	}
+	for _, proxy := range config.Proxies {
+		l.Info("Proxy: %s/ -> %s", proxy.CleanPrefix(), strings.Join(proxy.Upstreams, ", "))
+	}
+	for pattern, host := range config.Hosts {
+		if host != nil && host.RootDir != "" {
+			l.Info("Virtual Host: %s -> %s", pattern, host.RootDir)
+		}
+	}
 
 	if config.Security.BasicAuth != nil && config.Security.BasicAuth.Enabled {
 		l.Info("Basic Auth: Enabled")
 	}
+	if config.Security.BearerAuth != nil && config.Security.BearerAuth.Enabled {
+		l.Info("Bearer Auth: Enabled")
+	}
+	if config.Security.OIDC != nil && config.Security.OIDC.Enabled {
+		l.Info("OIDC Login: %s", config.Security.OIDC.Issuer)
+	}
+	if config.Security.SignedURLs != nil && config.Security.SignedURLs.Enabled {
+		l.Info("Signed URLs: %s", strings.Join(config.Security.SignedURLs.Paths, ", "))
+	}
+	if config.Security.ForwardAuth != nil && config.Security.ForwardAuth.Enabled {
+		l.Info("Forward Auth: %s", config.Security.ForwardAuth.URL)
+	}
+	if len(config.Security.AuthRules) > 0 {
+		l.Info("Auth Rules: %d", len(config.Security.AuthRules))
+	}
 
 	if config.Security.CORS != nil && config.Security.CORS.Enabled {
 		l.Info("CORS: Enabled")
@@ -195,6 +247,10 @@ func (l *Logger) PrintBanner(config *Config) {
 		l.Info("Rate Limit: %d req/min", config.Security.RateLimit.RequestsPerIP)
 	}
 
+	if cache := config.Performance.MemoryCache; cache != nil && cache.Enabled {
+		l.Info("Memory Cache: Enabled")
+	}
+
 	if config.Performance.EnableCompression {
 		l.Info("Compression: Enabled (level %d)", config.Performance.CompressionLevel)
 	}
@@ -0,0 +1,228 @@
+package koryxserv
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// memFS is a read-only in-memory filesystem.
+// It backs archive roots and is safe for concurrent reads once built.
+type memFS struct {
+	entries map[string]*memEntry
+}
+
+// memEntry is a file or directory stored in a memFS
+type memEntry struct {
+	name     string // base name ("." for the root)
+	data     []byte
+	mode     fs.FileMode
+	modTime  time.Time
+	children map[string]*memEntry
+}
+
+func newMemFS() *memFS {
+	root := &memEntry{name: ".", mode: fs.ModeDir | 0o755, children: make(map[string]*memEntry)}
+	return &memFS{entries: map[string]*memEntry{".": root}}
+}
+
+// addFile stores a regular file, creating parent directories as needed
+func (m *memFS) addFile(name string, data []byte, mode fs.FileMode, modTime time.Time) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if name == "." || !fs.ValidPath(name) {
+		return
+	}
+	parent := m.mkdirAll(path.Dir(name), modTime)
+	entry := &memEntry{
+		name:    path.Base(name),
+		data:    data,
+		mode:    mode.Perm(),
+		modTime: modTime,
+	}
+	parent.children[entry.name] = entry
+	m.entries[name] = entry
+}
+
+// addDir stores a directory, creating parents as needed
+func (m *memFS) addDir(name string, modTime time.Time) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if !fs.ValidPath(name) {
+		return
+	}
+	dir := m.mkdirAll(name, modTime)
+	if !modTime.IsZero() {
+		dir.modTime = modTime
+	}
+}
+
+func (m *memFS) mkdirAll(name string, modTime time.Time) *memEntry {
+	if entry, ok := m.entries[name]; ok && entry.mode.IsDir() {
+		return entry
+	}
+	parent := m.mkdirAll(path.Dir(name), modTime)
+	entry := &memEntry{
+		name:     path.Base(name),
+		mode:     fs.ModeDir | 0o755,
+		modTime:  modTime,
+		children: make(map[string]*memEntry),
+	}
+	parent.children[entry.name] = entry
+	m.entries[name] = entry
+	return entry
+}
+
+// Open implements fs.FS
+func (m *memFS) Open(name string) (fs.File, error) {
+	entry, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{entry: entry, reader: bytes.NewReader(entry.data)}, nil
+}
+
+// Stat implements fs.StatFS
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.info(), nil
+}
+
+// ReadFile implements fs.ReadFileFS
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	entry, err := m.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return bytes.Clone(entry.data), nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return entry.dirEntries(), nil
+}
+
+func (m *memFS) lookup(op, name string) (*memEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := m.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+// sharedData returns the stored bytes of a file without copying them.
+// Callers must not modify the slice.
+func (m *memFS) sharedData(name string) ([]byte, bool) {
+	entry, ok := m.entries[name]
+	if !ok || entry.mode.IsDir() {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// size returns the total number of file bytes held
+func (m *memFS) size() int64 {
+	var total int64
+	for _, entry := range m.entries {
+		total += int64(len(entry.data))
+	}
+	return total
+}
+
+func (e *memEntry) info() fs.FileInfo {
+	return memFileInfo{entry: e}
+}
+
+func (e *memEntry) dirEntries() []fs.DirEntry {
+	names := make([]string, 0, len(e.children))
+	for name := range e.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fs.FileInfoToDirEntry(e.children[name].info()))
+	}
+	return entries
+}
+
+// memFileInfo implements fs.FileInfo for a memEntry
+type memFileInfo struct {
+	entry *memEntry
+}
+
+func (i memFileInfo) Name() string       { return i.entry.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.entry.data)) }
+func (i memFileInfo) Mode() fs.FileMode  { return i.entry.mode }
+func (i memFileInfo) ModTime() time.Time { return i.entry.modTime }
+func (i memFileInfo) IsDir() bool        { return i.entry.mode.IsDir() }
+func (i memFileInfo) Sys() any           { return nil }
+
+// memFile is an open memFS entry; it is seekable so http.ServeContent can use it
+type memFile struct {
+	entry   *memEntry
+	reader  *bytes.Reader
+	dirRead []fs.DirEntry
+	dirPos  int
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.entry.info(), nil }
+func (f *memFile) Close() error               { return nil }
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.entry.mode.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.entry.name, Err: fs.ErrInvalid}
+	}
+	return f.reader.Read(p)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.reader.ReadAt(p, off)
+}
+
+// ReadDir implements fs.ReadDirFile
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.entry.name, Err: fs.ErrInvalid}
+	}
+	if f.dirRead == nil {
+		f.dirRead = f.entry.dirEntries()
+	}
+
+	remaining := f.dirRead[f.dirPos:]
+	if n <= 0 {
+		f.dirPos = len(f.dirRead)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	f.dirPos += n
+	return remaining[:n], nil
+}
@@ -1,10 +1,8 @@
 package koryxserv
 
 import (
-	"compress/gzip"
-	"crypto/subtle"
+	"context"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"path/filepath"
@@ -34,14 +32,26 @@ func LoggingMiddleware(logger *Logger) Middleware {
 			// Wrapper to capture the status code
 			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
+			// Filled in by authentication further down the chain
+			entry := &accessEntry{}
+			r = r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry))
+
 			next.ServeHTTP(wrapped, r)
 
 			duration := time.Since(start)
-			logger.Access(r.Method, r.URL.Path, wrapped.statusCode, duration, r.RemoteAddr)
+			logger.Access(r.Method, r.URL.Path, wrapped.statusCode, duration, ClientIP(r), entry.user)
 		})
 	}
 }
 
+// accessEntry collects what inner handlers learn about a request for the
+// access log
+type accessEntry struct {
+	user string
+}
+
+type accessEntryKey struct{}
+
 // responseWriter wraps ResponseWriter to capture the status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -53,6 +63,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// proxied WebSocket upgrades can hijack the connection
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // SecurityHeadersMiddleware adds security headers
 func SecurityHeadersMiddleware() Middleware {
 	return func(next http.Handler) http.Handler {
@@ -102,30 +118,43 @@ func PathTraversalMiddleware(rootDir string) Middleware {
 	}
 }
 
-// BasicAuthMiddleware adds basic authentication
-func BasicAuthMiddleware(config *BasicAuthConfig) Middleware {
+// PathAccessMiddleware enforces allowed/blocked path patterns.
+// Blocked patterns always win over allowed ones. When allowed patterns are
+// configured, paths that match none of them are denied as well.
+func PathAccessMiddleware(allowed, blocked []string, denyStatus int, logger *Logger) Middleware {
+	// An invalid pattern fails closed: ignoring it could expose its paths
+	allowedGlobs, err := compilePathGlobs(allowed)
+	if err != nil {
+		logger.Error("Denying every request, invalid allowed_paths: %v", err)
+		return denyAll
+	}
+	blockedGlobs, err := compilePathGlobs(blocked)
+	if err != nil {
+		logger.Error("Denying every request, invalid blocked_paths: %v", err)
+		return denyAll
+	}
+
+	if denyStatus != http.StatusNotFound {
+		denyStatus = http.StatusForbidden
+	}
+	denyBody := fmt.Sprintf("%d %s", denyStatus, http.StatusText(denyStatus))
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !config.Enabled {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			username, password, ok := r.BasicAuth()
-			if !ok {
-				w.Header().Set("WWW-Authenticate", `Basic realm="`+config.Realm+`"`)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			if rule := matchFirstGlob(blockedGlobs, r.URL.Path); rule != nil {
+				logger.Debug("Path %s denied by blocked_paths rule %q", r.URL.Path, rule)
+				http.Error(w, denyBody, denyStatus)
 				return
 			}
 
-			// Use constant-time comparison to avoid timing attacks
-			usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(config.Username)) == 1
-			passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(config.Password)) == 1
-
-			if !usernameMatch || !passwordMatch {
-				w.Header().Set("WWW-Authenticate", `Basic realm="`+config.Realm+`"`)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
+			if len(allowedGlobs) > 0 {
+				rule := matchFirstGlob(allowedGlobs, r.URL.Path)
+				if rule == nil {
+					logger.Debug("Path %s denied: no allowed_paths rule matched", r.URL.Path)
+					http.Error(w, denyBody, denyStatus)
+					return
+				}
+				logger.Debug("Path %s allowed by allowed_paths rule %q", r.URL.Path, rule)
 			}
 
 			next.ServeHTTP(w, r)
@@ -280,7 +309,7 @@ func RateLimitMiddleware(limiter *RateLimiter) Middleware {
 				return
 			}
 
-			ip := clientIP(r.RemoteAddr)
+			ip := ClientIP(r)
 
 			if !limiter.allow(ip) {
 				http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
@@ -292,40 +321,6 @@ func RateLimitMiddleware(limiter *RateLimiter) Middleware {
 	}
 }
 
-// IPFilterMiddleware filters IPs based on whitelist/blacklist
-func IPFilterMiddleware(whitelist, blacklist []string) Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := clientIP(r.RemoteAddr)
-
-			// Check blacklist first
-			for _, blocked := range blacklist {
-				if ip == blocked {
-					http.Error(w, "403 Forbidden", http.StatusForbidden)
-					return
-				}
-			}
-
-			// If whitelist exists, check whether IP is allowed
-			if len(whitelist) > 0 {
-				allowed := false
-				for _, allowed_ip := range whitelist {
-					if ip == allowed_ip {
-						allowed = true
-						break
-					}
-				}
-				if !allowed {
-					http.Error(w, "403 Forbidden", http.StatusForbidden)
-					return
-				}
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
 func clientIP(remoteAddr string) string {
 	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
@@ -334,40 +329,6 @@ func clientIP(remoteAddr string) string {
 	return host
 }
 
-// CompressionMiddleware adds gzip compression
-func CompressionMiddleware(level int) Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Check whether the client accepts gzip
-			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			w.Header().Set("Content-Encoding", "gzip")
-
-			gz, err := gzip.NewWriterLevel(w, level)
-			if err != nil {
-				next.ServeHTTP(w, r)
-				return
-			}
-			defer gz.Close()
-
-			gzw := &gzipResponseWriter{ResponseWriter: w, Writer: gz}
-			next.ServeHTTP(gzw, r)
-		})
-	}
-}
-
-type gzipResponseWriter struct {
-	http.ResponseWriter
-	io.Writer
-}
-
-func (w *gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
-
 // CustomHeadersMiddleware adds custom headers
 func CustomHeadersMiddleware(headers map[string]string) Middleware {
 	return func(next http.Handler) http.Handler {
@@ -379,15 +340,3 @@ func CustomHeadersMiddleware(headers map[string]string) Middleware {
 		})
 	}
 }
-
-// CacheMiddleware adds cache headers
-func CacheMiddleware(maxAge int) Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if maxAge > 0 {
-				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
-			}
-			next.ServeHTTP(w, r)
-		})
-	}
-}
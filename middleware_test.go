@@ -97,6 +97,74 @@ func TestPathTraversalMiddleware(t *testing.T) {
 	// escaping the root directory. The middleware normalizes paths using filepath.Clean.
 }
 
+func TestPathAccessMiddleware(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+
+	t.Run("BlockedOnly", func(t *testing.T) {
+		middleware := PathAccessMiddleware(nil, []string{"/private/**", "*.map"}, 0, logger)
+		handler := middleware(testHandler())
+
+		tests := []struct {
+			path           string
+			expectedStatus int
+		}{
+			{"/index.html", http.StatusOK},
+			{"/private", http.StatusForbidden},
+			{"/private/report.pdf", http.StatusForbidden},
+			{"/assets/app.js.map", http.StatusForbidden},
+			{"/assets/app.js", http.StatusOK},
+			{"/assets/%2e%2e/private/report.pdf", http.StatusForbidden},
+		}
+
+		for _, test := range tests {
+			req := httptest.NewRequest("GET", test.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Path %s: expected status %d, got %d", test.path, test.expectedStatus, w.Code)
+			}
+		}
+	})
+
+	t.Run("DenyWins", func(t *testing.T) {
+		middleware := PathAccessMiddleware(
+			[]string{"/public/**"},
+			[]string{"/public/drafts/**"},
+			http.StatusNotFound,
+			logger,
+		)
+		handler := middleware(testHandler())
+
+		tests := []struct {
+			path           string
+			expectedStatus int
+		}{
+			{"/public/index.html", http.StatusOK},
+			{"/public/drafts/post.html", http.StatusNotFound},
+			{"/other/file.txt", http.StatusNotFound},
+		}
+
+		for _, test := range tests {
+			req := httptest.NewRequest("GET", test.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Path %s: expected status %d, got %d", test.path, test.expectedStatus, w.Code)
+			}
+		}
+	})
+
+	t.Run("InvalidPatternFailsClosed", func(t *testing.T) {
+		handler := PathAccessMiddleware(nil, []string{"/private/**", "/bad/[x"}, 0, logger)(testHandler())
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected 403 with an invalid blocked_paths pattern, got %d", w.Code)
+		}
+	})
+}
+
 func TestBasicAuthMiddleware(t *testing.T) {
 	config := &BasicAuthConfig{
 		Enabled:  true,
@@ -104,8 +172,9 @@ func TestBasicAuthMiddleware(t *testing.T) {
 		Password: "secret",
 		Realm:    "Test",
 	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
 
-	middleware := BasicAuthMiddleware(config)
+	middleware := BasicAuthMiddleware(config, logger)
 	handler := middleware(testHandler())
 
 	// Test without auth
@@ -154,7 +223,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 		disabledConfig := &BasicAuthConfig{
 			Enabled: false,
 		}
-		disabledMiddleware := BasicAuthMiddleware(disabledConfig)
+		disabledMiddleware := BasicAuthMiddleware(disabledConfig, logger)
 		disabledHandler := disabledMiddleware(testHandler())
 
 		req := httptest.NewRequest("GET", "/", nil)
@@ -321,10 +390,12 @@ func TestRateLimitMiddlewareRespectsInitialBurstSize(t *testing.T) {
 }
 
 func TestIPFilterMiddleware(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+
 	// Test with whitelist
 	t.Run("Whitelist", func(t *testing.T) {
 		whitelist := []string{"192.168.1.100"}
-		middleware := IPFilterMiddleware(whitelist, nil)
+		middleware := IPFilterMiddleware(&SecurityConfig{IPWhitelist: whitelist}, logger)
 		handler := middleware(testHandler())
 
 		// Allowed IP
@@ -349,7 +420,7 @@ func TestIPFilterMiddleware(t *testing.T) {
 	// Test with blacklist
 	t.Run("Blacklist", func(t *testing.T) {
 		blacklist := []string{"192.168.1.100"}
-		middleware := IPFilterMiddleware(nil, blacklist)
+		middleware := IPFilterMiddleware(&SecurityConfig{IPBlacklist: blacklist}, logger)
 		handler := middleware(testHandler())
 
 		// Blacklisted IP
@@ -373,8 +444,9 @@ func TestIPFilterMiddleware(t *testing.T) {
 }
 
 func TestIPFilterMiddlewareWithoutPortInRemoteAddr(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
 	whitelist := []string{"192.168.1.100"}
-	middleware := IPFilterMiddleware(whitelist, nil)
+	middleware := IPFilterMiddleware(&SecurityConfig{IPWhitelist: whitelist}, logger)
 	handler := middleware(testHandler())
 
 	req := httptest.NewRequest("GET", "/", nil)
@@ -433,7 +505,8 @@ func TestCustomHeadersMiddleware(t *testing.T) {
 }
 
 func TestCacheMiddleware(t *testing.T) {
-	middleware := CacheMiddleware(3600)
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	middleware := CacheMiddleware(&PerformanceConfig{CacheMaxAge: 3600}, logger)
 	handler := middleware(testHandler())
 
 	req := httptest.NewRequest("GET", "/", nil)
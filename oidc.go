@@ -0,0 +1,514 @@
+package koryxserv
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcLoginTimeout bounds the round trip through the provider
+	oidcLoginTimeout = 10 * time.Minute
+	// oidcLeeway allows for clock skew between the provider and the server
+	oidcLeeway = 30 * time.Second
+	// oidcKeyRefreshInterval limits refetching provider keys on unknown signatures
+	oidcKeyRefreshInterval = time.Minute
+	// oidcMaxCookieSize is the largest cookie (name and value) browsers keep
+	oidcMaxCookieSize = 4096
+)
+
+// oidcProvider holds the endpoints of a discovered OpenID provider
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcLogin is kept in a short-lived cookie between the redirect to the
+// provider and the callback
+type oidcLogin struct {
+	State       string `json:"state"`
+	Nonce       string `json:"nonce"`
+	Verifier    string `json:"verifier"`
+	RedirectURI string `json:"redirect_uri"`
+	Return      string `json:"return"`
+}
+
+// oidcSession is the content of the session cookie: the claims identities
+// and auth rules need, not the whole ID token, which may not fit a cookie
+type oidcSession struct {
+	Claims map[string]any `json:"claims"`
+}
+
+// oidcAuthenticator logs browsers in through an OpenID provider and
+// authenticates later requests with an encrypted session cookie. The
+// provider is discovered on first use, so it may be down at startup.
+type oidcAuthenticator struct {
+	config        *OIDCConfig
+	logger        *Logger
+	client        *http.Client
+	codec         *sessionCodec
+	callbackPath  string
+	sessionClaims []string // claims kept in the session cookie
+
+	mu          sync.Mutex
+	provider    *oidcProvider
+	keys        []jwtKey
+	keysFetched time.Time
+}
+
+// newOIDCAuthenticator creates the authenticator; ruleClaims are the claims
+// auth rules check, kept in the session along with the user and groups
+func newOIDCAuthenticator(config *OIDCConfig, logger *Logger, ruleClaims []string) *oidcAuthenticator {
+	callbackPath := config.GetRedirectURL()
+	if u, err := url.Parse(callbackPath); err == nil {
+		callbackPath = u.Path
+	}
+	return &oidcAuthenticator{
+		config:        config,
+		logger:        logger,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		codec:         newSessionCodec(config.CookieSecret),
+		callbackPath:  callbackPath,
+		sessionClaims: append([]string{config.GetUserClaim(), config.GetGroupsClaim()}, ruleClaims...),
+	}
+}
+
+func (a *oidcAuthenticator) loginCookie() string {
+	return a.config.GetCookieName() + "_login"
+}
+
+func (a *oidcAuthenticator) authenticate(r *http.Request) (*Identity, bool) {
+	cookie, err := r.Cookie(a.config.GetCookieName())
+	if err != nil {
+		return nil, false
+	}
+	var session oidcSession
+	if err := a.codec.open(a.config.GetCookieName(), cookie.Value, &session); err != nil {
+		a.logger.Debug("Rejected session cookie: %v", err)
+		return nil, false
+	}
+	return &Identity{
+		User:   claimString(session.Claims[a.config.GetUserClaim()]),
+		Groups: claimStrings(session.Claims[a.config.GetGroupsClaim()]),
+		Method: AuthOIDC,
+		Claims: session.Claims,
+	}, true
+}
+
+// challenge sends browsers to the provider's login page; other clients,
+// which cannot follow the login, get 401
+func (a *oidcAuthenticator) challenge(w http.ResponseWriter, r *http.Request) {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	provider, err := a.discover(r.Context())
+	if err != nil {
+		a.logger.Error("OIDC discovery failed: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	login := oidcLogin{
+		State:       randomToken(),
+		Nonce:       randomToken(),
+		Verifier:    randomToken(),
+		RedirectURI: a.redirectURI(r),
+		Return:      r.URL.RequestURI(),
+	}
+	value, err := a.codec.seal(a.loginCookie(), login, time.Now().Add(oidcLoginTimeout))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	a.setCookie(w, r, a.loginCookie(), value, oidcLoginTimeout)
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	target, _ := url.Parse(provider.AuthorizationEndpoint)
+	query := target.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", a.config.ClientID)
+	query.Set("redirect_uri", login.RedirectURI)
+	query.Set("scope", strings.Join(a.config.GetScopes(), " "))
+	query.Set("state", login.State)
+	query.Set("nonce", login.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// serveAuthRoute handles the login callback and logout paths
+func (a *oidcAuthenticator) serveAuthRoute(w http.ResponseWriter, r *http.Request) bool {
+	switch r.URL.Path {
+	case a.callbackPath:
+		a.handleCallback(w, r)
+	case a.config.GetLogoutPath():
+		a.handleLogout(w, r)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleCallback completes a login: it checks the state, redeems the code
+// with the PKCE verifier, verifies the ID token and starts the session
+func (a *oidcAuthenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
+	var login oidcLogin
+	cookie, err := r.Cookie(a.loginCookie())
+	if err == nil {
+		err = a.codec.open(a.loginCookie(), cookie.Value, &login)
+	}
+	query := r.URL.Query()
+	if err != nil || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	a.clearCookie(w, r, a.loginCookie())
+
+	if reason := query.Get("error"); reason != "" {
+		a.logger.Warn("OIDC login failed: %s %s", reason, query.Get("error_description"))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	provider, err := a.discover(r.Context())
+	if err != nil {
+		a.logger.Error("OIDC discovery failed: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	idToken, err := a.exchange(r.Context(), provider, query.Get("code"), &login)
+	if err != nil {
+		a.logger.Error("OIDC code exchange failed: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	claims, err := a.verifyIDToken(r.Context(), provider, idToken, login.Nonce)
+	if err != nil {
+		a.logger.Warn("Rejected ID token: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user := claimString(claims[a.config.GetUserClaim()])
+
+	ttl := a.config.GetSessionTTL()
+	name := a.config.GetCookieName()
+	value, err := a.codec.seal(name, oidcSession{Claims: a.keepClaims(claims)}, time.Now().Add(ttl))
+	if err == nil && len(name)+1+len(value) > oidcMaxCookieSize {
+		// Browsers would drop the cookie and send the user back to login
+		err = fmt.Errorf("session cookie of %d bytes exceeds the %d bytes browsers keep", len(name)+1+len(value), oidcMaxCookieSize)
+	}
+	if err != nil {
+		a.logger.Error("OIDC session for %s: %v", user, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	a.setCookie(w, r, name, value, ttl)
+	a.logger.Info("OIDC login: %s", user)
+
+	http.Redirect(w, r, localRedirect(login.Return), http.StatusFound)
+}
+
+// keepClaims returns the claims of an ID token the session keeps
+func (a *oidcAuthenticator) keepClaims(claims map[string]any) map[string]any {
+	kept := make(map[string]any, len(a.sessionClaims))
+	for _, name := range a.sessionClaims {
+		if value, ok := claims[name]; ok {
+			kept[name] = value
+		}
+	}
+	return kept
+}
+
+// handleLogout ends the session, and the provider's session when it
+// supports RP-initiated logout
+func (a *oidcAuthenticator) handleLogout(w http.ResponseWriter, r *http.Request) {
+	a.clearCookie(w, r, a.config.GetCookieName())
+
+	target := a.config.GetLogoutRedirectURL()
+	if provider, err := a.discover(r.Context()); err == nil && provider.EndSessionEndpoint != "" {
+		if end, err := url.Parse(provider.EndSessionEndpoint); err == nil {
+			query := end.Query()
+			query.Set("client_id", a.config.ClientID)
+			query.Set("post_logout_redirect_uri", a.absoluteURL(r, target))
+			end.RawQuery = query.Encode()
+			target = end.String()
+		}
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// exchange redeems an authorization code for an ID token
+func (a *oidcAuthenticator) exchange(ctx context.Context, provider *oidcProvider, code string, login *oidcLogin) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.RedirectURI},
+		"code_verifier": {login.Verifier},
+	}
+	if a.config.ClientSecret == "" {
+		form.Set("client_id", a.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+	}
+
+	var response struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := a.fetchJSON(req, &response); err != nil {
+		return "", err
+	}
+	if response.IDToken == "" {
+		return "", errors.New("no id_token in token response")
+	}
+	return response.IDToken, nil
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and nonce. Keys are refetched once when a signature does not verify, to
+// follow provider key rotation. The issuer is the one of the discovery
+// document, which discover matched against the configured one with or
+// without a trailing slash.
+func (a *oidcAuthenticator) verifyIDToken(ctx context.Context, provider *oidcProvider, token, nonce string) (map[string]any, error) {
+	claims, err := verifyJWT(token, a.providerKeys())
+	if err != nil && a.refreshKeys(ctx) {
+		claims, err = verifyJWT(token, a.providerKeys())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTokenClaims(claims, provider.Issuer, a.config.ClientID, nil, oidcLeeway, time.Now()); err != nil {
+		return nil, err
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce does not match")
+	}
+	return claims, nil
+}
+
+// discover fetches the provider metadata and keys once
+func (a *oidcAuthenticator) discover(ctx context.Context) (*oidcProvider, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.provider != nil {
+		return a.provider, nil
+	}
+
+	endpoint := strings.TrimSuffix(a.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	var provider oidcProvider
+	if err := a.fetchJSON(req, &provider); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(a.config.Issuer, "/") {
+		return nil, fmt.Errorf("discovery document is for issuer %q", provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks endpoints")
+	}
+
+	keys, err := a.fetchKeys(ctx, provider.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	a.provider, a.keys, a.keysFetched = &provider, keys, time.Now()
+	a.logger.Info("OIDC provider discovered: %s", provider.Issuer)
+	return a.provider, nil
+}
+
+func (a *oidcAuthenticator) providerKeys() []jwtKey {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.keys
+}
+
+// refreshKeys refetches the provider keys, at most once per interval, and
+// reports whether new keys were loaded
+func (a *oidcAuthenticator) refreshKeys(ctx context.Context) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.provider == nil || time.Since(a.keysFetched) < oidcKeyRefreshInterval {
+		return false
+	}
+	a.keysFetched = time.Now()
+	keys, err := a.fetchKeys(ctx, a.provider.JWKSURI)
+	if err != nil {
+		a.logger.Error("Keeping previous OIDC keys: %v", err)
+		return false
+	}
+	a.keys = keys
+	return true
+}
+
+func (a *oidcAuthenticator) fetchKeys(ctx context.Context, jwksURI string) ([]jwtKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var document json.RawMessage
+	if err := a.fetchJSON(req, &document); err != nil {
+		return nil, err
+	}
+	return parseJWKS(document)
+}
+
+// fetchJSON sends a request to the provider and decodes a JSON response
+func (a *oidcAuthenticator) fetchJSON(req *http.Request, v any) error {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// redirectURI returns the absolute callback URL for the request
+func (a *oidcAuthenticator) redirectURI(r *http.Request) string {
+	return a.absoluteURL(r, a.config.GetRedirectURL())
+}
+
+// absoluteURL resolves a path against the URL the client used
+func (a *oidcAuthenticator) absoluteURL(r *http.Request, target string) string {
+	if strings.Contains(target, "://") {
+		return target
+	}
+	return requestScheme(r) + "://" + r.Host + target
+}
+
+func (a *oidcAuthenticator) setCookie(w http.ResponseWriter, r *http.Request, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl / time.Second),
+		Secure:   requestScheme(r) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *oidcAuthenticator) clearCookie(w http.ResponseWriter, r *http.Request, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   requestScheme(r) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// requestScheme returns the scheme the client used, trusting
+// X-Forwarded-Proto only from trusted proxies
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if forwardedByTrustedProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// localRedirect keeps redirects after login on this site
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// randomToken returns 32 random bytes, base64url encoded
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ValidateOIDC checks the OIDC settings without contacting the provider
+func ValidateOIDC(config *OIDCConfig) error {
+	issuer, err := url.Parse(config.Issuer)
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+		return fmt.Errorf("oidc issuer must be an http or https URL: %q", config.Issuer)
+	}
+	if config.ClientID == "" {
+		return fmt.Errorf("oidc enabled but no client_id specified")
+	}
+	if len(config.CookieSecret) < 32 {
+		return fmt.Errorf("oidc cookie_secret must be at least 32 bytes")
+	}
+	redirect, err := url.Parse(config.GetRedirectURL())
+	if err != nil || !strings.HasPrefix(redirect.Path, "/") {
+		return fmt.Errorf("invalid oidc redirect_url: %q", config.RedirectURL)
+	}
+	if !strings.HasPrefix(config.GetLogoutPath(), "/") {
+		return fmt.Errorf("invalid oidc logout_path: %q", config.LogoutPath)
+	}
+	if config.SessionTTL < 0 {
+		return fmt.Errorf("invalid oidc session_ttl: %d", config.SessionTTL)
+	}
+	return nil
+}
+
+// OIDCMiddleware requires an OpenID Connect login on every request and
+// serves the callback and logout paths. The identity and ID token claims
+// are stored on the request context; AuthMiddleware applies it per path
+// instead.
+func OIDCMiddleware(config *OIDCConfig, logger *Logger) Middleware {
+	auth := newOIDCAuthenticator(config, logger, nil)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !config.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if auth.serveAuthRoute(w, r) {
+				return
+			}
+
+			id, ok := auth.authenticate(r)
+			if !ok {
+				auth.challenge(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, withIdentity(r, id))
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package koryxserv
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OpenID provider: it issues one code per
+// authorization request and checks the PKCE verifier when it is redeemed
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	keys   *testJWTKeys
+	claims map[string]any
+
+	codes map[string]url.Values // code -> authorization request
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	m := &mockIssuer{t: t, keys: newTestJWTKeys(t), codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+			"end_session_endpoint":   m.server.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(m.keys.jwksDoc)
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := randomToken()
+		m.codes[code] = query
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		request, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		clientID, secret, _ := r.BasicAuth()
+		if !ok || clientID != "site" || secret != "site-secret" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != request.Get("code_challenge") ||
+			r.PostForm.Get("redirect_uri") != request.Get("redirect_uri") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := map[string]any{
+			"iss": m.server.URL, "aud": "site", "sub": "alice", "nonce": request.Get("nonce"),
+			"exp": time.Now().Add(time.Hour).Unix(), "groups": []string{"staff"},
+		}
+		for name, value := range m.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "opaque",
+			"id_token":     signTestJWT(m.t, "RS256", "rsa", m.keys.rsa, claims),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	config := &SecurityConfig{
+		OIDC: &OIDCConfig{
+			Enabled:      true,
+			Issuer:       issuer.server.URL,
+			ClientID:     "site",
+			ClientSecret: "site-secret",
+			CookieSecret: "0123456789abcdef0123456789abcdef",
+		},
+		AuthRules: []AuthRuleConfig{{Paths: []string{"/admin/**"}, Auth: AuthOIDC, Groups: []string{"admins"}}},
+	}
+	var identity *Identity
+	site := httptest.NewServer(AuthMiddleware(config, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = AuthenticatedIdentity(r)
+	})))
+	defer site.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(target string, cookies []*http.Cookie, accept string) *http.Response {
+		req, _ := http.NewRequest("GET", target, nil)
+		req.Header.Set("Accept", accept)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", target, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	const html = "text/html,application/xhtml+xml"
+
+	// API clients cannot follow a login
+	if resp := get(site.URL+"/docs/", nil, "application/json"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for API client, got %d", resp.StatusCode)
+	}
+
+	// Browsers are sent to the provider with PKCE
+	resp := get(site.URL+"/docs/?page=2", nil, html)
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location.String(), issuer.server.URL+"/authorize") {
+		t.Fatalf("expected redirect to provider, got %d %s", resp.StatusCode, location)
+	}
+	if location.Query().Get("code_challenge_method") != "S256" || location.Query().Get("redirect_uri") != site.URL+"/oauth2/callback" {
+		t.Errorf("unexpected authorization request %s", location.RawQuery)
+	}
+	loginCookies := resp.Cookies()
+
+	// The provider sends the browser back with a code
+	callback := get(location.String(), nil, html).Header.Get("Location")
+
+	// A callback without the login cookie is refused
+	if resp := get(callback, nil, html); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without login state, got %d", resp.StatusCode)
+	}
+
+	resp = get(callback, loginCookies, html)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/docs/?page=2" {
+		t.Fatalf("expected redirect back to the page, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	var session []*http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "koryx_session" && cookie.Value != "" {
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Errorf("expected HttpOnly, SameSite=Lax session cookie")
+			}
+			session = append(session, cookie)
+		}
+	}
+	if len(session) != 1 {
+		t.Fatalf("expected a session cookie, got %v", resp.Cookies())
+	}
+
+	// The session authenticates later requests
+	if resp := get(site.URL+"/docs/", session, "application/json"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with session, got %d", resp.StatusCode)
+	}
+	if identity == nil || identity.User != "alice" || identity.Method != AuthOIDC || !identity.InGroup("staff") {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	// Group claims feed auth rules
+	if resp := get(site.URL+"/admin/", session, html); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 outside the admins group, got %d", resp.StatusCode)
+	}
+
+	// A tampered session starts a new login
+	tampered := &http.Cookie{Name: "koryx_session", Value: session[0].Value[:len(session[0].Value)-2] + "AA"}
+	if resp := get(site.URL+"/docs/", []*http.Cookie{tampered}, html); resp.StatusCode != http.StatusFound {
+		t.Errorf("expected login redirect for tampered session, got %d", resp.StatusCode)
+	}
+
+	// Logout clears the cookie and ends the provider session
+	resp = get(site.URL+"/oauth2/logout", session, html)
+	if !strings.HasPrefix(resp.Header.Get("Location"), issuer.server.URL+"/logout?") {
+		t.Errorf("expected redirect to end_session_endpoint, got %s", resp.Header.Get("Location"))
+	}
+	if cookies := resp.Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected the session cookie to be cleared, got %v", cookies)
+	}
+}
+
+// oidcCallback logs in through OIDCMiddleware and returns the answer to
+// the provider's callback
+func oidcCallback(t *testing.T, config *OIDCConfig) *httptest.ResponseRecorder {
+	t.Helper()
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := OIDCMiddleware(config, logger)(testHandler())
+
+	req := httptest.NewRequest("GET", "http://site.test/", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	authorize, _ := http.NewRequest("GET", w.Header().Get("Location"), nil)
+	resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}).Do(authorize)
+	if err != nil {
+		t.Fatalf("authorize failed: %v", err)
+	}
+	resp.Body.Close()
+
+	req = httptest.NewRequest("GET", resp.Header.Get("Location"), nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func testOIDCConfig(issuer *mockIssuer) *OIDCConfig {
+	return &OIDCConfig{
+		Enabled:      true,
+		Issuer:       issuer.server.URL,
+		ClientID:     "site",
+		ClientSecret: "site-secret",
+		CookieSecret: "0123456789abcdef0123456789abcdef",
+	}
+}
+
+func TestOIDCRejectsWrongAudience(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = map[string]any{"aud": "another-client"}
+	if w := oidcCallback(t, testOIDCConfig(issuer)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for ID token of another client, got %d", w.Code)
+	}
+}
+
+func TestOIDCIssuerTrailingSlash(t *testing.T) {
+	issuer := newMockIssuer(t)
+	config := testOIDCConfig(issuer)
+	config.Issuer += "/"
+	if w := oidcCallback(t, config); w.Code != http.StatusFound {
+		t.Errorf("expected login with a trailing slash in the issuer, got %d", w.Code)
+	}
+}
+
+func TestOIDCSessionClaims(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = map[string]any{"picture": strings.Repeat("p", 8000), "email": "alice@example.com"}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	auth := newOIDCAuthenticator(testOIDCConfig(issuer), logger, []string{"email"})
+
+	// Only the user, groups and rule claims are kept, so the cookie fits
+	w := oidcCallback(t, testOIDCConfig(issuer))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected login despite a large unused claim, got %d", w.Code)
+	}
+	kept := auth.keepClaims(map[string]any{"sub": "alice", "groups": []string{"staff"}, "email": "a@b", "picture": "p"})
+	if len(kept) != 3 || kept["picture"] != nil {
+		t.Errorf("expected sub, groups and email to be kept, got %v", kept)
+	}
+
+	// A groups claim too large for a cookie fails the login instead of
+	// looping through it
+	groups := make([]string, 400)
+	for i := range groups {
+		groups[i] = "department-group-" + strings.Repeat("x", 10)
+	}
+	issuer.claims = map[string]any{"groups": groups}
+	w = oidcCallback(t, testOIDCConfig(issuer))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for an oversized session, got %d", w.Code)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "koryx_session" {
+			t.Errorf("expected no session cookie, got %d bytes", len(cookie.Value))
+		}
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	for target, want := range map[string]string{
+		"/docs/?a=1":           "/docs/?a=1",
+		"//evil.example/":      "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+	} {
+		if got := localRedirect(target); got != want {
+			t.Errorf("localRedirect(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package koryxserv
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes understood by basic auth. Values that do not start with
+// "$" are plaintext passwords.
+//
+//	bcrypt      $2a$, $2b$, $2y$
+//	SHA-crypt   $5$ (SHA-256), $6$ (SHA-512), optional rounds=N
+//	argon2id    $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+//	MD5-crypt   $apr1$ (htpasswd default), $1$
+var errUnsupportedHash = errors.New("unsupported password hash")
+
+// ValidatePasswordHash checks that a stored password is plaintext or a
+// well-formed hash of a supported scheme, without the cost of hashing
+func ValidatePasswordHash(stored string) error {
+	var err error
+	switch {
+	case !strings.HasPrefix(stored, "$"):
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		if _, err = bcrypt.Cost([]byte(stored)); err != nil {
+			err = fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+	case strings.HasPrefix(stored, "$5$"):
+		_, err = parseSHACrypt(stored, "$5$", 43)
+	case strings.HasPrefix(stored, "$6$"):
+		_, err = parseSHACrypt(stored, "$6$", 86)
+	case strings.HasPrefix(stored, "$argon2id$"):
+		_, err = parseArgon2id(stored)
+	case strings.HasPrefix(stored, "$apr1$"):
+		_, err = parseMD5Crypt(stored, "$apr1$")
+	case strings.HasPrefix(stored, "$1$"):
+		_, err = parseMD5Crypt(stored, "$1$")
+	default:
+		err = errUnsupportedHash
+	}
+	return err
+}
+
+// verifyPassword reports whether password matches the stored value
+func verifyPassword(stored, password string) bool {
+	ok, err := checkPassword(stored, password)
+	return err == nil && ok
+}
+
+// checkPassword compares password with a stored plaintext or hash. The
+// error reports a malformed or unsupported hash.
+func checkPassword(stored, password string) (bool, error) {
+	switch {
+	case !strings.HasPrefix(stored, "$"):
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, nil
+
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(stored)); err != nil {
+			return false, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, nil
+
+	case strings.HasPrefix(stored, "$5$"):
+		return checkSHACrypt(stored, password, "$5$", 43, sha256.New, sha256CryptOrder)
+	case strings.HasPrefix(stored, "$6$"):
+		return checkSHACrypt(stored, password, "$6$", 86, sha512.New, sha512CryptOrder)
+
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return checkArgon2id(stored, password)
+
+	case strings.HasPrefix(stored, "$apr1$"):
+		return checkMD5Crypt(stored, password, "$apr1$")
+	case strings.HasPrefix(stored, "$1$"):
+		return checkMD5Crypt(stored, password, "$1$")
+	}
+	return false, errUnsupportedHash
+}
+
+// cryptAlphabet is the base64 alphabet of crypt(3)
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptEncode encodes digest bytes in the order crypt(3) prescribes: each
+// group of three indexes yields four characters, a short last group fewer
+func cryptEncode(digest []byte, order [][]int) string {
+	var out strings.Builder
+	for _, group := range order {
+		var w uint
+		for _, i := range group {
+			w = w<<8 | uint(digest[i])
+		}
+		for n := len(group) + 1; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return out.String()
+}
+
+var sha256CryptOrder = [][]int{
+	{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+	{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	{31, 30},
+}
+
+var sha512CryptOrder = [][]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41}, {63},
+}
+
+var md5CryptOrder = [][]int{
+	{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}, {11},
+}
+
+// SHA-crypt rounds (Drepper's specification)
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+)
+
+// shaCryptHash is a parsed $5$/$6$ hash
+type shaCryptHash struct {
+	rounds         int
+	explicitRounds bool
+	salt           string
+}
+
+func parseSHACrypt(stored, prefix string, digestLen int) (*shaCryptHash, error) {
+	rest := strings.TrimPrefix(stored, prefix)
+	parsed := &shaCryptHash{rounds: shaCryptDefaultRounds}
+	if strings.HasPrefix(rest, "rounds=") {
+		value, after, ok := strings.Cut(strings.TrimPrefix(rest, "rounds="), "$")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid SHA-crypt rounds")
+		}
+		parsed.rounds = min(max(n, shaCryptMinRounds), shaCryptMaxRounds)
+		parsed.explicitRounds = true
+		rest = after
+	}
+	salt, digest, ok := strings.Cut(rest, "$")
+	if !ok || len(digest) != digestLen {
+		return nil, fmt.Errorf("invalid SHA-crypt hash")
+	}
+	parsed.salt = salt[:min(len(salt), 16)]
+	return parsed, nil
+}
+
+// checkSHACrypt verifies a $5$/$6$ hash
+func checkSHACrypt(stored, password, prefix string, digestLen int, newHash func() hash.Hash, order [][]int) (bool, error) {
+	parsed, err := parseSHACrypt(stored, prefix, digestLen)
+	if err != nil {
+		return false, err
+	}
+
+	expected := prefix
+	if parsed.explicitRounds {
+		expected += "rounds=" + strconv.Itoa(parsed.rounds) + "$"
+	}
+	expected += parsed.salt + "$" + shaCrypt([]byte(password), []byte(parsed.salt), parsed.rounds, newHash, order)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(stored)) == 1, nil
+}
+
+func shaCrypt(password, salt []byte, rounds int, newHash func() hash.Hash, order [][]int) string {
+	h := newHash()
+
+	// Digest B
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	// Digest A
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	writeRepeated(h, b, len(password))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	// Byte sequences P and S
+	h.Reset()
+	for range password {
+		h.Write(password)
+	}
+	p := repeatTo(h.Sum(nil), len(password))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeatTo(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(c[:0:0])
+	}
+	return cryptEncode(c, order)
+}
+
+// writeRepeated writes data repeatedly until n bytes were written
+func writeRepeated(h hash.Hash, data []byte, n int) {
+	for ; n > len(data); n -= len(data) {
+		h.Write(data)
+	}
+	h.Write(data[:n])
+}
+
+// repeatTo returns data repeated to a length of n bytes
+func repeatTo(data []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, data[:min(len(data), n-len(out))]...)
+	}
+	return out
+}
+
+// parseMD5Crypt returns the salt of an $apr1$/$1$ hash
+func parseMD5Crypt(stored, magic string) (string, error) {
+	salt, digest, ok := strings.Cut(strings.TrimPrefix(stored, magic), "$")
+	if !ok || len(digest) != 22 {
+		return "", fmt.Errorf("invalid MD5-crypt hash")
+	}
+	return salt[:min(len(salt), 8)], nil
+}
+
+// checkMD5Crypt verifies an $apr1$/$1$ hash
+func checkMD5Crypt(stored, password, magic string) (bool, error) {
+	salt, err := parseMD5Crypt(stored, magic)
+	if err != nil {
+		return false, err
+	}
+	pw := []byte(password)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(salt))
+	h.Write(pw)
+	final := h.Sum(nil)
+
+	h.Reset()
+	h.Write(pw)
+	h.Write([]byte(magic))
+	h.Write([]byte(salt))
+	writeRepeated(h, final, len(pw))
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final = h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(pw)
+		}
+		final = h.Sum(final[:0:0])
+	}
+
+	expected := magic + salt + "$" + cryptEncode(final, md5CryptOrder)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(stored)) == 1, nil
+}
+
+// argon2idHash is a parsed PHC-format argon2id hash
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(stored string) (*argon2idHash, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 || parts[2] != "v=19" {
+		return nil, fmt.Errorf("invalid argon2id hash")
+	}
+	parsed := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.time, &parsed.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	var err error
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(parsed.key) == 0 || parsed.memory == 0 || parsed.time == 0 || parsed.threads == 0 {
+		return nil, fmt.Errorf("invalid argon2id hash")
+	}
+	return parsed, nil
+}
+
+// checkArgon2id verifies a PHC-format argon2id hash
+func checkArgon2id(stored, password string) (bool, error) {
+	parsed, err := parseArgon2id(stored)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(password), parsed.salt, parsed.time, parsed.memory, parsed.threads, uint32(len(parsed.key)))
+	return subtle.ConstantTimeCompare(computed, parsed.key) == 1, nil
+}
@@ -0,0 +1,53 @@
+package koryxserv
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	salt := []byte("0123456789abcdef")
+	argonHash := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("s3cret"), salt, 1, 1024, 1, 32)))
+
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+	}{
+		{"plaintext", "s3cret", "s3cret"},
+		{"bcrypt", string(bcryptHash), "s3cret"},
+		// Vectors produced by openssl passwd
+		{"sha256-crypt", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"},
+		{"sha256-crypt rounds", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", "Hello world!"},
+		{"sha512-crypt", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!"},
+		{"apr1", "$apr1$r31.....$G/cElGhD0cboYkZN5h5Ne/", "secret"},
+		{"md5-crypt", "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "password"},
+		{"argon2id", argonHash, "s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePasswordHash(tt.stored); err != nil {
+				t.Fatalf("ValidatePasswordHash failed: %v", err)
+			}
+			if !verifyPassword(tt.stored, tt.password) {
+				t.Errorf("expected password to match")
+			}
+			if verifyPassword(tt.stored, tt.password+"x") {
+				t.Errorf("expected wrong password to be rejected")
+			}
+		})
+	}
+
+	for _, stored := range []string{"$3$unknown", "$5$salt$short", "$2y$bad", "$argon2id$v=19$m=x$salt$key", "$apr1$salt"} {
+		if err := ValidatePasswordHash(stored); err == nil {
+			t.Errorf("expected %q to be rejected", stored)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package koryxserv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Proxy defaults
+const (
+	defaultProxyConnectTimeout = 10 // seconds
+	defaultProxyMaxFails       = 1
+	defaultProxyFailTimeout    = 10 // seconds
+)
+
+// proxyRoute forwards requests under a prefix to its upstreams
+type proxyRoute struct {
+	server      *Server
+	prefix      string
+	config      ProxyConfig
+	upstreams   []*proxyUpstream
+	next        atomic.Uint64
+	maxFails    int
+	failTimeout time.Duration
+}
+
+// proxyUpstream is one backend of a route with its passive health state
+type proxyUpstream struct {
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+// proxyHeaderKey carries the response headers set by earlier middlewares,
+// so that headers sent by the upstream replace them instead of doubling up
+type proxyHeaderKey struct{}
+
+// ValidateProxies checks proxy prefixes and upstream URLs. Prefixes must be
+// unique and must not collide with a mount.
+func ValidateProxies(proxies []ProxyConfig, mounts []MountConfig) error {
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		seen[mount.CleanPrefix()] = true
+	}
+	for _, proxy := range proxies {
+		prefix := proxy.CleanPrefix()
+		if prefix == "" {
+			return fmt.Errorf("proxy prefix %q must not be the root path", proxy.Prefix)
+		}
+		if seen[prefix] {
+			return fmt.Errorf("duplicate proxy or mount prefix: %s", prefix)
+		}
+		seen[prefix] = true
+
+		if len(proxy.Upstreams) == 0 {
+			return fmt.Errorf("proxy %s: no upstreams", prefix)
+		}
+		for _, upstream := range proxy.Upstreams {
+			if _, err := parseUpstream(upstream); err != nil {
+				return fmt.Errorf("proxy %s: %w", prefix, err)
+			}
+		}
+		if proxy.ConnectTimeout < 0 || proxy.ResponseTimeout < 0 || proxy.MaxFails < 0 || proxy.FailTimeout < 0 {
+			return fmt.Errorf("proxy %s: timeouts and max_fails must not be negative", prefix)
+		}
+	}
+	return nil
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", upstream, err)
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: must be an absolute http or https URL", upstream)
+	}
+	if target.RawQuery != "" || target.Fragment != "" {
+		return nil, fmt.Errorf("invalid upstream %q: query and fragment are not supported", upstream)
+	}
+	return target, nil
+}
+
+// newProxyRoute builds the handler for a proxy entry
+func (s *Server) newProxyRoute(config ProxyConfig) (*proxyRoute, error) {
+	route := &proxyRoute{
+		server:      s,
+		prefix:      config.CleanPrefix(),
+		config:      config,
+		maxFails:    config.MaxFails,
+		failTimeout: time.Duration(config.FailTimeout) * time.Second,
+	}
+	if route.maxFails <= 0 {
+		route.maxFails = defaultProxyMaxFails
+	}
+	if route.failTimeout <= 0 {
+		route.failTimeout = defaultProxyFailTimeout * time.Second
+	}
+
+	connectTimeout := config.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultProxyConnectTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   time.Duration(connectTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = time.Duration(config.ResponseTimeout) * time.Second
+
+	for _, upstream := range config.Upstreams {
+		target, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		u := &proxyUpstream{target: target}
+		u.proxy = &httputil.ReverseProxy{
+			Rewrite:        route.rewrite(target),
+			Transport:      transport,
+			ModifyResponse: route.modifyResponse(u),
+			ErrorHandler:   route.handleError(u),
+		}
+		route.upstreams = append(route.upstreams, u)
+	}
+	return route, nil
+}
+
+// ServeHTTP implements http.Handler
+func (p *proxyRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), proxyHeaderKey{}, w.Header())
+	p.pick().proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// pick returns the next healthy upstream in round-robin order. When every
+// upstream is down the next one is tried anyway.
+func (p *proxyRoute) pick() *proxyUpstream {
+	start := p.next.Add(1) - 1
+	now := time.Now()
+	for i := range p.upstreams {
+		u := p.upstreams[(start+uint64(i))%uint64(len(p.upstreams))]
+		if u.healthy(now) {
+			return u
+		}
+	}
+	return p.upstreams[start%uint64(len(p.upstreams))]
+}
+
+// rewrite builds the upstream request: prefix stripping, target URL,
+// X-Forwarded-* headers and configured request headers
+func (p *proxyRoute) rewrite(target *url.URL) func(*httputil.ProxyRequest) {
+	return func(pr *httputil.ProxyRequest) {
+		if p.config.StripPrefix {
+			pr.Out.URL.Path = stripProxyPrefix(pr.Out.URL.Path, p.prefix)
+			if pr.Out.URL.RawPath != "" {
+				pr.Out.URL.RawPath = stripProxyPrefix(pr.Out.URL.RawPath, p.prefix)
+			}
+		}
+		pr.SetURL(target)
+
+		// Behind trusted proxies that set X-Forwarded-For the incoming
+		// chain is extended; otherwise it came from the client
+		if forwardedHeader(pr.In) == HeaderXForwardedFor {
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+		}
+		pr.SetXForwarded()
+		if forwardedByTrustedProxy(pr.In) {
+			for _, name := range []string{"X-Forwarded-Host", "X-Forwarded-Proto"} {
+				if value := pr.In.Header.Get(name); value != "" {
+					pr.Out.Header.Set(name, value)
+				}
+			}
+		}
+		if p.config.PreserveHost {
+			pr.Out.Host = pr.In.Host
+		}
+		applyHeaders(pr.Out.Header, p.config.RequestHeaders)
+	}
+}
+
+func stripProxyPrefix(urlPath, prefix string) string {
+	stripped := strings.TrimPrefix(urlPath, prefix)
+	if !strings.HasPrefix(stripped, "/") {
+		stripped = "/" + stripped
+	}
+	return stripped
+}
+
+// modifyResponse marks the upstream healthy and applies response headers
+func (p *proxyRoute) modifyResponse(u *proxyUpstream) func(*http.Response) error {
+	return func(res *http.Response) error {
+		u.succeed()
+
+		// Headers from the upstream win over the ones set by middlewares
+		if preset, ok := res.Request.Context().Value(proxyHeaderKey{}).(http.Header); ok {
+			for name := range res.Header {
+				preset.Del(name)
+			}
+		}
+		applyHeaders(res.Header, p.config.ResponseHeaders)
+		return nil
+	}
+}
+
+// handleError answers 502 (504 on timeouts) and counts the failure against
+// the upstream unless the client went away
+func (p *proxyRoute) handleError(u *proxyUpstream) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if r.Context().Err() != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		if u.fail(p.maxFails, p.failTimeout) {
+			p.server.logger.Warn("Proxy upstream %s marked down for %s: %v", u.target, p.failTimeout, err)
+		} else {
+			p.server.logger.Error("Proxy error for %s: %v", u.target, err)
+		}
+
+		status := http.StatusBadGateway
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			status = http.StatusGatewayTimeout
+		}
+		p.server.serveError(w, r, status)
+	}
+}
+
+func (u *proxyUpstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !now.Before(u.downUntil)
+}
+
+func (u *proxyUpstream) succeed() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+	u.downUntil = time.Time{}
+}
+
+// fail records a failure and reports whether the upstream went down
+func (u *proxyUpstream) fail(maxFails int, timeout time.Duration) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails++
+	if u.fails < maxFails {
+		return false
+	}
+	u.fails = 0
+	u.downUntil = time.Now().Add(timeout)
+	return true
+}
+
+// applyHeaders sets each header, removing those with an empty value
+func applyHeaders(header http.Header, values map[string]string) {
+	for name, value := range values {
+		if value == "" {
+			header.Del(name)
+		} else {
+			header.Set(name, value)
+		}
+	}
+}
+
+// proxyMiddlewares returns the chain for proxy routes: the access controls
+// of the file routes, without the file-specific path, rule, compression and
+// cache handling (the upstream owns its paths and responses)
+func (s *Server) proxyMiddlewares(config *Config) []Middleware {
+	middlewares := s.accessMiddlewares(config)
+
+	if len(config.Security.AllowedPaths) > 0 || len(config.Security.BlockedPaths) > 0 {
+		middlewares = append(middlewares, PathAccessMiddleware(
+			config.Security.AllowedPaths,
+			config.Security.BlockedPaths,
+			config.Security.PathDenyStatus,
+			s.logger,
+		))
+	}
+	return middlewares
+}
@@ -0,0 +1,173 @@
+package koryxserv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newProxyTestServer(t *testing.T, proxies ...ProxyConfig) http.Handler {
+	t.Helper()
+	config := DefaultConfig()
+	config.Server.RootDir = t.TempDir()
+	config.Proxies = proxies
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	return handler
+}
+
+func TestProxyForwarding(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("X-Internal", "secret")
+		fmt.Fprintf(w, "%s %s|%s|%s|%s|%s", r.Method, r.URL.RequestURI(), r.Host,
+			r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Host"), r.Header.Get("X-Api-Key"))
+	}))
+	defer upstream.Close()
+
+	handler := newProxyTestServer(t, ProxyConfig{
+		Prefix:          "/api",
+		Upstreams:       []string{upstream.URL + "/v1"},
+		StripPrefix:     true,
+		RequestHeaders:  map[string]string{"X-Api-Key": "k1", "Cookie": ""},
+		ResponseHeaders: map[string]string{"X-Internal": ""},
+	})
+
+	req := httptest.NewRequest("GET", "http://app.example.com/api/users/?page=2", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("Cookie", "session=1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	want := "GET /v1/users/?page=2|" + upstreamHost + "|203.0.113.7|app.example.com|k1"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Fatalf("expected %q, got %d %q", want, w.Code, w.Body.String())
+	}
+	if got := w.Header().Values("X-Frame-Options"); len(got) != 1 || got[0] != "SAMEORIGIN" {
+		t.Errorf("expected upstream header to replace the security header, got %v", got)
+	}
+	if w.Header().Get("X-Internal") != "" {
+		t.Errorf("expected response header to be removed")
+	}
+
+	// The bare prefix is proxied instead of redirected
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api", nil))
+	if !strings.HasPrefix(w.Body.String(), "POST /v1/|") {
+		t.Errorf("expected bare prefix to be proxied, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestProxyRoundRobinAndHealth(t *testing.T) {
+	hits := map[string]int{}
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			io.WriteString(w, name)
+		}))
+	}
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	defer b.Close()
+
+	// Nothing listens on a closed server's address
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	handler := newProxyTestServer(t, ProxyConfig{
+		Prefix:      "/api",
+		Upstreams:   []string{a.URL, down.URL, b.URL},
+		FailTimeout: 60,
+	})
+
+	statuses := map[int]int{}
+	for i := 0; i < 7; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/ping", nil))
+		statuses[w.Code]++
+	}
+	// Only the first request reaching the dead upstream fails
+	if statuses[http.StatusBadGateway] != 1 || statuses[http.StatusOK] != 6 {
+		t.Errorf("expected one 502 then healthy upstreams only, got %v", statuses)
+	}
+	if hits["a"] < 2 || hits["b"] < 2 {
+		t.Errorf("expected requests spread over healthy upstreams, got %v", hits)
+	}
+}
+
+func TestProxyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	handler := newProxyTestServer(t, ProxyConfig{Prefix: "/slow", Upstreams: []string{upstream.URL}, ResponseTimeout: 1})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/slow/report", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d", w.Code)
+	}
+}
+
+func TestProxyWebSocketUpgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.URL.Path != "/socket" {
+			http.Error(w, "upgrade expected", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo " + line)
+		rw.Flush()
+	}))
+	defer upstream.Close()
+
+	front := httptest.NewServer(newProxyTestServer(t, ProxyConfig{
+		Prefix:      "/ws",
+		Upstreams:   []string{upstream.URL},
+		StripPrefix: true,
+	}))
+	defer front.Close()
+
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(front.URL, "http://"), time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /ws/socket HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read upgrade response: %v", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", res.StatusCode)
+	}
+
+	io.WriteString(conn, "hello\n")
+	if line, _ := reader.ReadString('\n'); line != "echo hello\n" {
+		t.Errorf("expected echoed frame, got %q", line)
+	}
+}
@@ -0,0 +1,359 @@
+package koryxserv
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule types
+const (
+	RuleRedirect = "redirect"
+	RuleRewrite  = "rewrite"
+)
+
+// rewriteRule is a compiled RuleConfig
+type rewriteRule struct {
+	pattern *regexp.Regexp
+	to      string
+	kind    string
+	status  int  // redirect status, or a status override for rewrites from _redirects
+	force   bool // _redirects "!": apply even when the path resolves to a file
+	query   map[string]string
+	host    string
+	headers map[string]*regexp.Regexp // nil value: header must be present
+}
+
+// placeholderPattern matches ":name" segments in from/to patterns
+var placeholderPattern = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// ValidateRules checks that every rule compiles
+func ValidateRules(rules []RuleConfig) error {
+	_, err := compileRules(rules)
+	return err
+}
+
+func compileRules(rules []RuleConfig) ([]*rewriteRule, error) {
+	compiled := make([]*rewriteRule, 0, len(rules))
+	for i, rule := range rules {
+		r, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+func compileRule(rule RuleConfig) (*rewriteRule, error) {
+	r := &rewriteRule{
+		to:     rule.To,
+		kind:   rule.Type,
+		status: rule.Status,
+		query:  rule.Query,
+		host:   normalizeHostPattern(rule.Host),
+	}
+
+	switch {
+	case rule.From != "" && rule.Regex != "":
+		return nil, fmt.Errorf("from and regex are mutually exclusive")
+	case rule.Regex != "":
+		pattern, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", rule.Regex, err)
+		}
+		r.pattern = pattern
+	case rule.From != "":
+		pattern, err := compileFromPattern(rule.From)
+		if err != nil {
+			return nil, err
+		}
+		r.pattern = pattern
+	default:
+		return nil, fmt.Errorf("from or regex is required")
+	}
+
+	if rule.To == "" {
+		return nil, fmt.Errorf("to is required")
+	}
+
+	if r.kind == "" {
+		r.kind = RuleRedirect
+	}
+	switch r.kind {
+	case RuleRedirect:
+		if r.status == 0 {
+			r.status = http.StatusMovedPermanently
+		}
+		switch r.status {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("invalid redirect status %d (must be 301, 302, 307 or 308)", r.status)
+		}
+	case RuleRewrite:
+		if !strings.HasPrefix(rule.To, "/") {
+			return nil, fmt.Errorf("rewrite target %q must be a local path", rule.To)
+		}
+		if r.status != 0 {
+			return nil, fmt.Errorf("status is only valid for redirects")
+		}
+	default:
+		return nil, fmt.Errorf("invalid type %q (must be %q or %q)", rule.Type, RuleRedirect, RuleRewrite)
+	}
+
+	if r.host != "" {
+		if _, err := path.Match(r.host, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", rule.Host, err)
+		}
+	}
+
+	if len(rule.Headers) > 0 {
+		r.headers = make(map[string]*regexp.Regexp, len(rule.Headers))
+		for name, expr := range rule.Headers {
+			if expr == "" {
+				r.headers[name] = nil
+				continue
+			}
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid header pattern for %s: %w", name, err)
+			}
+			r.headers[name] = pattern
+		}
+	}
+
+	return r, nil
+}
+
+// compileFromPattern turns a path pattern such as "/blog/:year/:slug" or
+// "/old-blog/*" into an anchored regexp. ":name" matches one path segment
+// and a trailing "*" matches the rest of the path as "splat".
+func compileFromPattern(from string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(from, "/") {
+		return nil, fmt.Errorf("from pattern %q must start with /", from)
+	}
+
+	rest, splat := from, ""
+	if strings.HasSuffix(rest, "/*") {
+		// "/old-blog/*" also matches "/old-blog" itself
+		rest, splat = strings.TrimSuffix(rest, "/*"), `(?:/(?P<splat>.*))?`
+	} else if strings.HasSuffix(rest, "*") {
+		rest, splat = strings.TrimSuffix(rest, "*"), `(?P<splat>.*)`
+	}
+	if strings.Contains(rest, "*") {
+		return nil, fmt.Errorf("from pattern %q: * is only allowed at the end", from)
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringIndex(rest, -1) {
+		expr.WriteString(regexp.QuoteMeta(rest[last:loc[0]]))
+		expr.WriteString(`(?P<` + rest[loc[0]+1:loc[1]] + `>[^/]+)`)
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(rest[last:]))
+	expr.WriteString(splat)
+	expr.WriteString("$")
+
+	pattern, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid from pattern %q: %w", from, err)
+	}
+	return pattern, nil
+}
+
+// match reports whether the rule applies to the request and returns the
+// captured values (named groups, numbered groups and query placeholders)
+func (rule *rewriteRule) match(r *http.Request) (map[string]string, bool) {
+	groups := rule.pattern.FindStringSubmatch(r.URL.Path)
+	if groups == nil {
+		return nil, false
+	}
+
+	if rule.host != "" {
+		if ok, _ := path.Match(rule.host, requestHostname(r.Host)); !ok {
+			return nil, false
+		}
+	}
+	for name, pattern := range rule.headers {
+		values, present := r.Header[http.CanonicalHeaderKey(name)]
+		if !present {
+			return nil, false
+		}
+		if pattern != nil && !pattern.MatchString(strings.Join(values, ", ")) {
+			return nil, false
+		}
+	}
+
+	captures := patternCaptures(rule.pattern, groups)
+	if len(rule.query) > 0 {
+		query := r.URL.Query()
+		for key, want := range rule.query {
+			if !query.Has(key) {
+				return nil, false
+			}
+			got := query.Get(key)
+			switch {
+			case strings.HasPrefix(want, ":"):
+				// A value from the client must not climb out of its segment
+				if rule.inTargetPath(want[1:]) && !validPathSegment(got) {
+					return nil, false
+				}
+				captures[want[1:]] = got
+			case want != "" && want != got:
+				return nil, false
+			}
+		}
+	}
+	return captures, true
+}
+
+// patternCaptures maps the groups of a pattern match by number and by name
+func patternCaptures(pattern *regexp.Regexp, groups []string) map[string]string {
+	captures := make(map[string]string, len(groups))
+	for i, name := range pattern.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+		captures[fmt.Sprint(i)] = groups[i]
+		if name != "" {
+			captures[name] = groups[i]
+		}
+	}
+	return captures
+}
+
+// target expands the rule destination. The original query string is
+// carried over unless the destination sets its own.
+func (rule *rewriteRule) target(r *http.Request, captures map[string]string) string {
+	to := expandPlaceholders(rule.to, captures)
+	if !strings.Contains(to, "?") && r.URL.RawQuery != "" && len(rule.query) == 0 {
+		to += "?" + r.URL.RawQuery
+	}
+	return to
+}
+
+// inTargetPath reports whether a placeholder is used in the path of the
+// destination, rather than in its query string
+func (rule *rewriteRule) inTargetPath(name string) bool {
+	to, _, _ := strings.Cut(rule.to, "?")
+	for _, token := range expandPattern.FindAllString(to, -1) {
+		if placeholderName(token) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectStaysLocal reports whether an expanded redirect destination is
+// still local when the configured one is: captured values must not turn
+// "/:splat" into "//host" or "/\host", which browsers follow to another site
+func (rule *rewriteRule) redirectStaysLocal(to string) bool {
+	if strings.Contains(rule.to, "://") || strings.HasPrefix(rule.to, "//") {
+		return true
+	}
+	if target, _, _ := strings.Cut(to, "?"); strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+		return false
+	}
+	u, err := url.Parse(to)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// validPathSegment reports whether a captured value stays a single path
+// segment when it is substituted
+func validPathSegment(value string) bool {
+	return !strings.ContainsAny(value, "/\\") && !strings.Contains(value, "..")
+}
+
+// expandPattern matches $1, ${name} and :name tokens in a destination
+var expandPattern = regexp.MustCompile(`\$\{[A-Za-z0-9_]+\}|\$[0-9]+|:[A-Za-z_][A-Za-z0-9_]*`)
+
+// placeholderName returns the capture name of an expandPattern token
+func placeholderName(token string) string {
+	name := strings.TrimPrefix(token, "$")
+	name = strings.TrimPrefix(name, ":")
+	return strings.Trim(name, "{}")
+}
+
+// expandPlaceholders substitutes captured values into a destination.
+// Unknown :name tokens are left untouched so URLs such as "https://x:8080" survive.
+func expandPlaceholders(to string, captures map[string]string) string {
+	return expandPattern.ReplaceAllStringFunc(to, func(token string) string {
+		name := placeholderName(token)
+		if value, ok := captures[name]; ok {
+			return value
+		}
+		if strings.HasPrefix(token, "$") {
+			return ""
+		}
+		return token
+	})
+}
+
+// RulesMiddleware applies the ordered redirect and rewrite rules. The first
+// matching rule wins: redirects answer immediately, rewrites change the
+// path (and optionally the query) seen by the rest of the chain. The
+// target of a rewrite goes through checks first, so that a rewrite cannot
+// reach a path the access checks would refuse.
+func RulesMiddleware(rules []RuleConfig, logger *Logger, checks ...Middleware) Middleware {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		logger.Error("Ignoring rules: %v", err)
+		compiled = nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(compiled) == 0 {
+			return next
+		}
+		rewritten := Chain(next, checks...)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, rule := range compiled {
+				captures, ok := rule.match(r)
+				if !ok {
+					continue
+				}
+				to := rule.target(r, captures)
+
+				if rule.kind == RuleRedirect {
+					if !rule.redirectStaysLocal(to) {
+						logger.Warn("Ignoring redirect %s -> %s: it leaves the site", r.URL.Path, to)
+						continue
+					}
+					logger.Debug("Redirect %s -> %s (%d)", r.URL.Path, to, rule.status)
+					http.Redirect(w, r, to, rule.status)
+					return
+				}
+
+				logger.Debug("Rewrite %s -> %s", r.URL.Path, to)
+				rewritten.ServeHTTP(w, rewriteRequest(r, to))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rewriteRequest returns a shallow copy of r pointing at a new local URL.
+// The path is cleaned, so captured ".." segments cannot climb out of it.
+func rewriteRequest(r *http.Request, to string) *http.Request {
+	target, err := url.Parse(to)
+	if err != nil {
+		return r
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = cleanURLPath(target.Path)
+	r2.URL.RawPath = ""
+	if target.RawQuery != "" || strings.Contains(to, "?") {
+		r2.URL.RawQuery = target.RawQuery
+	}
+	return r2
+}
@@ -0,0 +1,156 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// echoPathHandler writes the path and query it receives
+func echoPathHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + "?" + r.URL.RawQuery))
+	})
+}
+
+func TestRulesMiddleware(t *testing.T) {
+	rules := []RuleConfig{
+		{From: "/old-blog/*", To: "/blog/:splat"},
+		{From: "/posts/:year/:slug", To: "/blog/:year-:slug", Status: http.StatusFound},
+		{Regex: `^/u/([0-9]+)$`, To: "/users/$1", Status: http.StatusTemporaryRedirect},
+		{From: "/search", To: "/find?q=:term", Query: map[string]string{"q": ":term"}, Status: http.StatusPermanentRedirect},
+		{From: "/app/*", To: "/app/index.html", Type: RuleRewrite},
+		{From: "/docs/*", To: "https://docs.example.com/:splat", Host: "*.example.com"},
+		{From: "/beta", To: "/beta/index.html", Type: RuleRewrite, Headers: map[string]string{"X-Beta": "^(1|yes)$"}},
+		{From: "/moved/*", To: "/:splat", Status: http.StatusFound},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := RulesMiddleware(rules, logger)(echoPathHandler())
+
+	tests := []struct {
+		name     string
+		target   string
+		host     string
+		header   string
+		status   int
+		location string
+		body     string
+	}{
+		{"splat redirect", "/old-blog/2024/post", "", "", http.StatusMovedPermanently, "/blog/2024/post", ""},
+		{"splat keeps query", "/old-blog/a?x=1", "", "", http.StatusMovedPermanently, "/blog/a?x=1", ""},
+		{"splat matches base", "/old-blog", "", "", http.StatusMovedPermanently, "/blog/", ""},
+		{"named placeholders", "/posts/2024/hello", "", "", http.StatusFound, "/blog/2024-hello", ""},
+		{"regex capture", "/u/42", "", "", http.StatusTemporaryRedirect, "/users/42", ""},
+		{"regex no match", "/u/abc", "", "", http.StatusOK, "", "/u/abc?"},
+		{"query capture", "/search?q=go", "", "", http.StatusPermanentRedirect, "/find?q=go", ""},
+		{"query missing", "/search", "", "", http.StatusOK, "", "/search?"},
+		{"rewrite", "/app/settings?tab=1", "", "", http.StatusOK, "", "/app/index.html?tab=1"},
+		{"host condition", "/docs/intro", "www.example.com", "", http.StatusMovedPermanently, "https://docs.example.com/intro", ""},
+		{"host mismatch", "/docs/intro", "other.org", "", http.StatusOK, "", "/docs/intro?"},
+		{"header condition", "/beta", "", "yes", http.StatusOK, "", "/beta/index.html?"},
+		{"header mismatch", "/beta", "", "no", http.StatusOK, "", "/beta?"},
+		{"local splat", "/moved/about?x=\\", "", "", http.StatusFound, "/about?x=\\", ""},
+		{"splat backslash stays local", "/moved/%5Cevil.com", "", "", http.StatusOK, "", "/moved/\\evil.com?"},
+		{"splat slash stays local", "/moved/%2Fevil.com", "", "", http.StatusOK, "", "/moved//evil.com?"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.target, nil)
+			if test.host != "" {
+				req.Host = test.host
+			}
+			if test.header != "" {
+				req.Header.Set("X-Beta", test.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != test.location {
+				t.Errorf("expected Location %q, got %q", test.location, got)
+			}
+			if test.body != "" && w.Body.String() != test.body {
+				t.Errorf("expected body %q, got %q", test.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRulesMiddleware_FirstMatchWins(t *testing.T) {
+	rules := []RuleConfig{
+		{From: "/a", To: "/first"},
+		{From: "/a", To: "/second"},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := RulesMiddleware(rules, logger)(echoPathHandler())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/a", nil))
+	if got := w.Header().Get("Location"); got != "/first" {
+		t.Errorf("expected first rule to win, got %q", got)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  RuleConfig
+		valid bool
+	}{
+		{"from redirect", RuleConfig{From: "/a/*", To: "/b/:splat"}, true},
+		{"regex rewrite", RuleConfig{Regex: "^/a/(.*)$", To: "/b/$1", Type: RuleRewrite}, true},
+		{"missing from", RuleConfig{To: "/b"}, false},
+		{"missing to", RuleConfig{From: "/a"}, false},
+		{"from and regex", RuleConfig{From: "/a", Regex: "^/a$", To: "/b"}, false},
+		{"bad regex", RuleConfig{Regex: "([", To: "/b"}, false},
+		{"relative from", RuleConfig{From: "a", To: "/b"}, false},
+		{"inner star", RuleConfig{From: "/a/*/b", To: "/b"}, false},
+		{"bad status", RuleConfig{From: "/a", To: "/b", Status: http.StatusOK}, false},
+		{"external rewrite", RuleConfig{From: "/a", To: "https://x.test/", Type: RuleRewrite}, false},
+		{"rewrite status", RuleConfig{From: "/a", To: "/b", Type: RuleRewrite, Status: http.StatusFound}, false},
+		{"bad type", RuleConfig{From: "/a", To: "/b", Type: "proxy"}, false},
+		{"bad header", RuleConfig{From: "/a", To: "/b", Headers: map[string]string{"X": "("}}, false},
+	}
+
+	for _, test := range tests {
+		err := ValidateRules([]RuleConfig{test.rule})
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid=%v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestRulesMiddlewareRewriteChecks(t *testing.T) {
+	rules := []RuleConfig{
+		{From: "/raw/*", To: "/files/:splat", Type: RuleRewrite},
+		{From: "/get", Query: map[string]string{"f": ":f"}, To: "/files/:f?from=:f", Type: RuleRewrite},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	blocked := PathAccessMiddleware(nil, []string{"/admin/**"}, 0, logger)
+	handler := RulesMiddleware(rules, logger, blocked)(echoPathHandler())
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/raw/a/b.txt", http.StatusOK, "/files/a/b.txt?"},
+		{"/raw/%2e%2e/admin/secret.txt", http.StatusForbidden, ""},
+		{"/get?f=a.txt", http.StatusOK, "/files/a.txt?from=a.txt"},
+		{"/get?f=../admin/secret.txt", http.StatusOK, "/get?f=../admin/secret.txt"},
+		{"/get?f=a%5Cb", http.StatusOK, "/get?f=a%5Cb"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.target, tt.status, w.Code)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.target, tt.body, w.Body.String())
+		}
+	}
+}
@@ -2,15 +2,21 @@ package koryxserv
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
+	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Server represents the HTTP server
@@ -18,7 +24,19 @@ type Server struct {
 	config     *Config
 	logger     *Logger
 	mux        *http.ServeMux
+	handler    http.Handler // root handler (mux or virtual host router)
 	httpServer *http.Server
+	fsys       fs.FS        // files are served from here
+	etags      sync.Map     // fs name -> etagEntry with a cached content hash
+	limiter    *RateLimiter // shared by every route and by hosts inheriting security
+	urlPrefix  string       // mount prefix stripped before file lookup
+	siteFiles  *siteFiles   // _redirects and _headers, when enabled
+	cache      *fileCache   // in-memory files, shared with mounts and hosts
+
+	compression *compressionSettings // encodes cached files once per coding
+
+	tlsMu sync.Mutex // guards certs between Start and ReloadTLS
+	certs *certStore // HTTPS certificates, reloaded on change
 }
 
 // NewServer creates a new server instance
@@ -30,29 +48,54 @@ func NewServer(config *Config, logger *Logger) *Server {
 	}
 }
 
+// NewServerFS creates a server that serves files from fsys instead of
+// Server.RootDir (e.g. an embed.FS, an archive or an overlay).
+func NewServerFS(config *Config, logger *Logger, fsys fs.FS) *Server {
+	server := NewServer(config, logger)
+	server.fsys = fsys
+	return server
+}
+
 // NewHandler creates a reusable HTTP handler with all configured koryx-serv features.
 func NewHandler(config *Config, logger *Logger) (http.Handler, error) {
 	server := NewServer(config, logger)
-	return server.Handler(), nil
+	if err := server.setupHandlers(); err != nil {
+		return nil, err
+	}
+	return server.handler, nil
+}
+
+// NewHandlerFS creates a reusable HTTP handler that serves files from fsys.
+func NewHandlerFS(config *Config, logger *Logger, fsys fs.FS) (http.Handler, error) {
+	server := NewServerFS(config, logger, fsys)
+	if err := server.setupHandlers(); err != nil {
+		return nil, err
+	}
+	return server.handler, nil
 }
 
 // Handler returns the configured HTTP handler without starting a dedicated HTTP server.
 func (s *Server) Handler() http.Handler {
-	s.setupHandlers()
-	return s.mux
+	if err := s.setupHandlers(); err != nil {
+		s.logger.Error("Error configuring handlers: %v", err)
+		return s.mux
+	}
+	return s.handler
 }
 
 // Start starts the server
 func (s *Server) Start() error {
 	// Configure the main handler
-	s.setupHandlers()
+	if err := s.setupHandlers(); err != nil {
+		return err
+	}
 
 	// Create the HTTP server
 	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port)
 
 	server := &http.Server{
 		Addr:         addr,
-		Handler:      s.mux,
+		Handler:      s.handler,
 		ReadTimeout:  s.config.Server.GetReadTimeout(),
 		WriteTimeout: s.config.Server.GetWriteTimeout(),
 	}
@@ -62,11 +105,17 @@ func (s *Server) Start() error {
 	s.logger.PrintBanner(s.config)
 
 	// Start serving
-	if s.config.Security.EnableHTTPS {
-		err := server.ListenAndServeTLS(
-			s.config.Security.CertFile,
-			s.config.Security.KeyFile,
-		)
+	if s.config.GetTLS().Enabled {
+		tlsConfig, err := s.loadTLS()
+		if err != nil {
+			return err
+		}
+		if server.TLSConfig, err = newTLSConfig(tlsConfig, s.certs.GetCertificate); err != nil {
+			return err
+		}
+		server.Protocols = tlsProtocols(tlsConfig.GetALPN())
+
+		err = server.ListenAndServeTLS("", "")
 		if err != nil && err != http.ErrServerClosed {
 			return err
 		}
@@ -80,8 +129,42 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// loadTLS loads the HTTPS certificates. ReloadTLS waits for it, so a reload
+// requested meanwhile reads the files after they were first loaded.
+func (s *Server) loadTLS() (*TLSConfig, error) {
+	s.tlsMu.Lock()
+	defer s.tlsMu.Unlock()
+
+	tlsConfig, err := resolveAutoCert(s.config, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	if s.certs, err = newCertStore(tlsConfig, s.logger); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// ReloadTLS rereads the HTTPS certificate and key files and rescans
+// cert_dir, e.g. on SIGHUP. Changes are also picked up on their own; an
+// invalid pair is reported and its current certificate stays in use.
+// Before Start there is nothing to reload: Start reads the files as they
+// are then.
+func (s *Server) ReloadTLS() error {
+	s.tlsMu.Lock()
+	defer s.tlsMu.Unlock()
+
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.reload()
+}
+
 // Shutdown gracefully stops the HTTP server.
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.cache != nil {
+		s.cache.close()
+	}
 	if s.httpServer == nil {
 		return nil
 	}
@@ -89,92 +172,341 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 // setupHandlers configures handlers and middleware
-func (s *Server) setupHandlers() {
+func (s *Server) setupHandlers() error {
+	// Resolve the filesystem to serve from
+	if s.fsys == nil {
+		fsys, err := OpenRootFS(&s.config.Server)
+		if err != nil {
+			return err
+		}
+		s.fsys = fsys
+	} else if s.config.Server.Preload {
+		fsys, err := SnapshotFS(s.fsys, s.config.Server.GetPreloadMaxSize())
+		if err != nil {
+			return err
+		}
+		s.fsys = fsys
+	}
+
+	// Memory cache (created once, then shared with mounts and hosts).
+	// A preloaded snapshot never changes, so its cache holds every file.
+	if s.cache == nil {
+		if s.config.Server.Preload {
+			s.cache = newSnapshotCache()
+		} else if s.config.Performance.MemoryCache != nil && s.config.Performance.MemoryCache.Enabled {
+			s.cache = newFileCache(s.config.Performance.MemoryCache)
+		}
+	}
+	s.setupCompression()
+	if s.config.Server.Preload {
+		if err := s.warmPreload(); err != nil {
+			return err
+		}
+	}
+
+	// Rate limiting state (created once, then shared by every route, mount
+	// and host that inherits security)
+	if s.limiter == nil && s.config.Security.RateLimit != nil && s.config.Security.RateLimit.Enabled {
+		s.limiter = NewRateLimiter(s.config.Security.RateLimit)
+	}
+
+	// Redirect and rewrite rules (RulesMiddleware would drop them all)
+	if err := ValidateRules(s.config.Rules); err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+
+	// Mount points (ServeMux routes to the longest matching prefix)
+	for _, mount := range s.config.Mounts {
+		mountServer, err := s.newMountServer(mount)
+		if err != nil {
+			return fmt.Errorf("mount %s: %w", mount.Prefix, err)
+		}
+		handler := http.StripPrefix(mountServer.urlPrefix, mountServer.createFileHandler())
+		s.mux.Handle(mountServer.urlPrefix+"/", Chain(handler, mountServer.middlewares(mountServer.config)...))
+		s.logger.Info("Mount enabled: %s/ -> %s", mountServer.urlPrefix, mount.RootDir)
+	}
+
+	// Reverse proxy routes (the exact prefix is registered too, so that
+	// "/api" is proxied rather than redirected to "/api/")
+	if err := ValidateProxies(s.config.Proxies, s.config.Mounts); err != nil {
+		return err
+	}
+	for _, proxy := range s.config.Proxies {
+		route, err := s.newProxyRoute(proxy)
+		if err != nil {
+			return fmt.Errorf("proxy %s: %w", proxy.CleanPrefix(), err)
+		}
+		handler := Chain(route, s.proxyMiddlewares(s.config)...)
+		s.mux.Handle(route.prefix, handler)
+		s.mux.Handle(route.prefix+"/", handler)
+		s.logger.Info("Proxy enabled: %s/ -> %s", route.prefix, strings.Join(proxy.Upstreams, ", "))
+	}
+
+	// Runtime config route (if enabled, must be registered before the main
+	// handler; behind the access checks, so auth rules cover it)
+	if s.config.RuntimeConfig != nil && s.config.RuntimeConfig.Enabled {
+		route := s.config.RuntimeConfig.Route
+		if route == "" {
+			route = "/runtime-config.js"
+		}
+		s.mux.Handle(route, Chain(http.HandlerFunc(s.handleRuntimeConfig), s.accessMiddlewares(s.config)...))
+		s.logger.Info("Runtime Config enabled at: %s", route)
+	}
+
+	// Memory cache admin route (behind the main middleware chain and its
+	// own token)
+	if cache := s.config.Performance.MemoryCache; s.cache != nil && cache != nil && cache.AdminRoute != "" {
+		if cache.AdminToken == "" {
+			s.logger.Error("Ignoring memory_cache admin_route: admin_token is not set")
+		} else {
+			s.mux.Handle(cache.AdminRoute, Chain(http.HandlerFunc(s.handleCacheAdmin), s.middlewares(s.config)...))
+			s.logger.Info("Memory cache admin enabled at: %s", cache.AdminRoute)
+		}
+	}
+
+	// Netlify-style _redirects and _headers in the served root
+	if s.config.Features.SiteFiles {
+		s.siteFiles = newSiteFiles(s.fsys, s.logger)
+	}
+
 	// Main handler
-	var handler http.Handler = s.createFileHandler()
+	s.mux.Handle("/", Chain(s.createFileHandler(), s.middlewares(s.config)...))
+	s.handler = s.mux
+
+	// Virtual hosts: each host gets its own routes and middleware chain,
+	// unknown hosts fall through to the main configuration
+	if len(s.config.Hosts) > 0 {
+		router := newHostRouter(s.mux)
+		for pattern, host := range s.config.Hosts {
+			if host == nil {
+				continue
+			}
+			hostServer := s.newHostServer(host)
+			hostServer.cache = s.cache
+			if host.Security == nil {
+				hostServer.limiter = s.limiter
+			}
+			if err := hostServer.setupHandlers(); err != nil {
+				return fmt.Errorf("host %s: %w", pattern, err)
+			}
+			if err := router.add(pattern, hostServer.handler); err != nil {
+				return err
+			}
+			s.logger.Info("Virtual host enabled: %s -> %s", pattern, hostServer.config.Server.RootDir)
+		}
+		s.handler = router
+	}
+	return nil
+}
+
+// setupCompression prepares encoding of cached files when both the memory
+// cache and live compression are enabled
+func (s *Server) setupCompression() {
+	if s.cache != nil && s.config.Performance.EnableCompression {
+		s.compression = newCompressionSettings(&s.config.Performance)
+	}
+}
+
+// middlewares returns the middleware chain for a route, in order.
+// config carries the route's own settings (mounts override hidden-file and
+// cache policy); state such as the rate limiter and site files comes from s.
+func (s *Server) middlewares(config *Config) []Middleware {
+	middlewares := s.accessMiddlewares(config)
+	checks := s.pathMiddlewares(config)
+	middlewares = append(middlewares, checks...)
+
+	// Rewritten paths go through authentication and the path checks again
+	rewriteChecks := append(s.authMiddlewares(config), checks...)
+
+	// Redirect and rewrite rules (just before the file handler, so
+	// redirects are not cached or compressed)
+	if len(config.Rules) > 0 {
+		middlewares = append(middlewares, RulesMiddleware(config.Rules, s.logger, rewriteChecks...))
+	}
+
+	// _redirects file (after the configured rules, which take precedence)
+	if s.siteFiles != nil {
+		middlewares = append(middlewares, s.siteRedirectsMiddleware(rewriteChecks...))
+	}
+
+	// Compression
+	if config.Performance.EnableCompression {
+		middlewares = append(middlewares, CompressionMiddleware(&config.Performance))
+	}
+
+	// Cache headers
+	if config.Performance.EnableCache && (config.Performance.CacheMaxAge > 0 || len(config.Performance.CacheRules) > 0) {
+		middlewares = append(middlewares, CacheMiddleware(&config.Performance, s.logger))
+	}
 
-	// Apply middleware in the correct order
+	// _headers file (last, so it overrides custom and cache headers)
+	if s.siteFiles != nil {
+		middlewares = append(middlewares, s.siteHeadersMiddleware())
+	}
+
+	return middlewares
+}
+
+// pathMiddlewares returns the checks that decide from the request path
+// whether it may be served
+func (s *Server) pathMiddlewares(config *Config) []Middleware {
 	var middlewares []Middleware
 
-	// Logging (first to capture everything)
+	// Path traversal protection
+	middlewares = append(middlewares, PathTraversalMiddleware(config.Server.RootDir))
+
+	// Allowed/blocked path rules
+	if len(config.Security.AllowedPaths) > 0 || len(config.Security.BlockedPaths) > 0 {
+		middlewares = append(middlewares, PathAccessMiddleware(
+			config.Security.AllowedPaths,
+			config.Security.BlockedPaths,
+			config.Security.PathDenyStatus,
+			s.logger,
+		))
+	}
+
+	// Block hidden files
+	if config.Security.BlockHiddenFiles {
+		middlewares = append(middlewares, BlockHiddenFilesMiddleware(config.Server.RootDir))
+	}
+
+	return middlewares
+}
+
+// accessMiddlewares returns the start of every chain: logging, response
+// headers and the checks that decide whether a client may be served
+func (s *Server) accessMiddlewares(config *Config) []Middleware {
+	var middlewares []Middleware
+
+	// Real client IP behind trusted proxies (before logging, so the
+	// access log, IP filter and rate limiter all see it)
+	if len(config.Security.TrustedProxies) > 0 {
+		middlewares = append(middlewares, RealIPMiddleware(config.Security.TrustedProxies, config.Security.ClientIPHeader, s.logger))
+	}
+
+	// Logging (early, to capture everything)
 	middlewares = append(middlewares, LoggingMiddleware(s.logger))
 
 	// Security headers
 	middlewares = append(middlewares, SecurityHeadersMiddleware())
 
 	// Custom headers
-	if len(s.config.Performance.CustomHeaders) > 0 {
-		middlewares = append(middlewares, CustomHeadersMiddleware(s.config.Performance.CustomHeaders))
+	if len(config.Performance.CustomHeaders) > 0 {
+		middlewares = append(middlewares, CustomHeadersMiddleware(config.Performance.CustomHeaders))
 	}
 
 	// IP filtering
-	if len(s.config.Security.IPWhitelist) > 0 || len(s.config.Security.IPBlacklist) > 0 {
-		middlewares = append(middlewares, IPFilterMiddleware(
-			s.config.Security.IPWhitelist,
-			s.config.Security.IPBlacklist,
-		))
+	if len(config.Security.IPWhitelist) > 0 || len(config.Security.IPBlacklist) > 0 ||
+		config.Security.IPWhitelistFile != "" || config.Security.IPBlacklistFile != "" {
+		middlewares = append(middlewares, IPFilterMiddleware(&config.Security, s.logger))
 	}
 
 	// Rate limiting
-	if s.config.Security.RateLimit != nil && s.config.Security.RateLimit.Enabled {
-		limiter := NewRateLimiter(s.config.Security.RateLimit)
-		middlewares = append(middlewares, RateLimitMiddleware(limiter))
+	if s.limiter != nil {
+		middlewares = append(middlewares, RateLimitMiddleware(s.limiter))
 	}
 
-	// Basic auth
-	if s.config.Security.BasicAuth != nil && s.config.Security.BasicAuth.Enabled {
-		middlewares = append(middlewares, BasicAuthMiddleware(s.config.Security.BasicAuth))
-	}
+	// Authentication
+	middlewares = append(middlewares, s.authMiddlewares(config)...)
 
 	// CORS
-	if s.config.Security.CORS != nil && s.config.Security.CORS.Enabled {
-		middlewares = append(middlewares, CORSMiddleware(s.config.Security.CORS))
+	if config.Security.CORS != nil && config.Security.CORS.Enabled {
+		middlewares = append(middlewares, CORSMiddleware(config.Security.CORS))
 	}
 
-	// Path traversal protection
-	middlewares = append(middlewares, PathTraversalMiddleware(s.config.Server.RootDir))
+	return middlewares
+}
 
-	// Block hidden files
-	if s.config.Security.BlockHiddenFiles {
-		middlewares = append(middlewares, BlockHiddenFilesMiddleware(s.config.Server.RootDir))
+// authMiddlewares returns the checks that authenticate and authorize a
+// request
+func (s *Server) authMiddlewares(config *Config) []Middleware {
+	var middlewares []Middleware
+
+	// Client certificate identity (for the access log and auth rules)
+	if tlsConfig := config.GetTLS(); tlsConfig.Enabled && tlsConfig.ClientAuth != nil {
+		middlewares = append(middlewares, ClientCertMiddleware())
 	}
 
-	// Compression
-	if s.config.Performance.EnableCompression {
-		middlewares = append(middlewares, CompressionMiddleware(s.config.Performance.CompressionLevel))
+	// Forward auth (an external service decides, before the built-in schemes)
+	if config.Security.ForwardAuth != nil && config.Security.ForwardAuth.Enabled {
+		middlewares = append(middlewares, ForwardAuthMiddleware(&config.Security, s.logger))
 	}
 
-	// Cache headers
-	if s.config.Performance.EnableCache && s.config.Performance.CacheMaxAge > 0 {
-		middlewares = append(middlewares, CacheMiddleware(s.config.Performance.CacheMaxAge))
+	// Authentication (basic auth and per-path auth rules)
+	if config.Security.AuthEnabled() {
+		middlewares = append(middlewares, AuthMiddleware(&config.Security, s.logger))
 	}
 
-	// Apply middleware chain
-	handler = Chain(handler, middlewares...)
+	return middlewares
+}
 
-	// Runtime config route (if enabled, must be registered before the main handler)
-	if s.config.RuntimeConfig != nil && s.config.RuntimeConfig.Enabled {
-		route := s.config.RuntimeConfig.Route
-		if route == "" {
-			route = "/runtime-config.js"
-		}
-		s.mux.HandleFunc(route, s.handleRuntimeConfig)
-		s.logger.Info("Runtime Config enabled at: %s", route)
+// newMountServer builds the file server for a mount point. Settings the
+// mount leaves unset are inherited from the main configuration.
+func (s *Server) newMountServer(mount MountConfig) (*Server, error) {
+	derived := *s.config
+	derived.Mounts = nil
+	derived.Server.RootDir = mount.RootDir
+	derived.Server.Layers = nil
+
+	if mount.IndexFiles != nil {
+		derived.Features.IndexFiles = mount.IndexFiles
+	}
+	if mount.SPAMode != nil {
+		derived.Features.SPAMode = *mount.SPAMode
+	}
+	if mount.SPAIndex != "" {
+		derived.Features.SPAIndex = mount.SPAIndex
+	}
+	if mount.DirectoryListing != nil {
+		derived.Features.DirectoryListing = *mount.DirectoryListing
+	}
+	if mount.BlockHiddenFiles != nil {
+		derived.Security.BlockHiddenFiles = *mount.BlockHiddenFiles
+	}
+	if mount.CacheMaxAge != nil {
+		derived.Performance.CacheMaxAge = *mount.CacheMaxAge
+	}
+
+	fsys, err := OpenRootFS(&derived.Server)
+	if err != nil {
+		return nil, err
 	}
 
-	s.mux.Handle("/", handler)
+	mountServer := &Server{
+		config:    &derived,
+		logger:    s.logger,
+		fsys:      fsys,
+		limiter:   s.limiter,
+		cache:     s.cache,
+		urlPrefix: mount.CleanPrefix(),
+	}
+	mountServer.setupCompression()
+	if derived.Server.Preload {
+		if err := mountServer.warmPreload(); err != nil {
+			return nil, err
+		}
+	}
+	return mountServer, nil
 }
 
 // createFileHandler creates the file-serving handler
 func (s *Server) createFileHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Resolve file path
-		path := filepath.Join(s.config.Server.RootDir, filepath.Clean(r.URL.Path))
+		// Resolve file name inside the served filesystem
+		name := fsName(r.URL.Path)
+
+		// Cached files skip the filesystem (sidecars need it, so not with precompressed)
+		if s.cache != nil && !s.config.Performance.Precompressed {
+			if entry, ok := s.cache.get(s, name); ok {
+				s.serveCachedFile(w, r, entry)
+				return
+			}
+		}
 
 		// Check whether the file exists
-		info, err := os.Stat(path)
+		info, err := fs.Stat(s.fsys, name)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if errors.Is(err, fs.ErrNotExist) {
 				// SPA mode: redirect to index.html
 				if s.config.Features.SPAMode {
 					s.serveSPAIndex(w, r)
@@ -183,36 +515,36 @@ func (s *Server) createFileHandler() http.Handler {
 				s.serveError(w, r, http.StatusNotFound)
 				return
 			}
-			s.logger.Error("Error accessing path %s: %v", path, err)
+			s.logger.Error("Error accessing path %s: %v", name, err)
 			s.serveError(w, r, http.StatusInternalServerError)
 			return
 		}
 
 		// If path is a directory
 		if info.IsDir() {
-			s.serveDirectory(w, r, path)
+			s.serveDirectory(w, r, name)
 			return
 		}
 
 		// Serve file
-		s.serveFile(w, r, path, info)
+		s.serveFile(w, r, name, info)
 	})
 }
 
 // serveDirectory serves a directory
-func (s *Server) serveDirectory(w http.ResponseWriter, r *http.Request, path string) {
+func (s *Server) serveDirectory(w http.ResponseWriter, r *http.Request, name string) {
 	// Try to serve index files
 	for _, indexFile := range s.config.Features.IndexFiles {
-		indexPath := filepath.Join(path, indexFile)
-		if info, err := os.Stat(indexPath); err == nil && !info.IsDir() {
-			s.serveFile(w, r, indexPath, info)
+		indexName := path.Join(name, indexFile)
+		if info, err := fs.Stat(s.fsys, indexName); err == nil && !info.IsDir() {
+			s.serveFile(w, r, indexName, info)
 			return
 		}
 	}
 
 	// If directory listing is enabled, render listing
 	if s.config.Features.DirectoryListing {
-		s.serveDirectoryListing(w, r, path)
+		s.serveDirectoryListing(w, r, name)
 		return
 	}
 
@@ -221,41 +553,198 @@ func (s *Server) serveDirectory(w http.ResponseWriter, r *http.Request, path str
 }
 
 // serveFile serves a file
-func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo) {
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	// Prefer a precompressed sidecar (.br/.zst/.gz) when the client accepts it
+	if s.config.Performance.Precompressed && s.servePrecompressed(w, r, name) {
+		return
+	}
+
+	if s.cache != nil {
+		if entry, ok := s.cache.peek(s, name, info); ok {
+			s.serveCachedFile(w, r, entry)
+			return
+		}
+	}
+
 	// Add ETag when enabled
+	etag := ""
 	if s.config.Performance.EnableETags {
-		etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().Unix(), info.Size())
-		w.Header().Set("ETag", etag)
+		etag = s.fileETag(name, info)
+	}
 
-		// Check If-None-Match
-		if match := r.Header.Get("If-None-Match"); match != "" {
-			if match == etag {
-				w.WriteHeader(http.StatusNotModified)
-				return
-			}
+	if s.cache != nil {
+		if entry, ok := s.cache.add(s, name, info, etag); ok {
+			s.serveCachedFile(w, r, entry)
+			return
 		}
 	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	// Conditional headers are answered here; ServeContent only handles Range
+	r, ok := s.evaluatePreconditions(w, r, etag, info.ModTime())
+	if !ok {
+		return
+	}
+
+	content, closeFile, err := openSeekable(s.fsys, name)
+	if err != nil {
+		s.logger.Error("Error opening file %s: %v", name, err)
+		s.serveError(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer closeFile()
 
 	// Serve file
-	http.ServeFile(w, r, path)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// fileETag returns the validator for a file. In the default mode it is
+// derived from the modification time and size; files without a
+// modification time (embed.FS, some archives) and the "content" mode get a
+// content hash so that equally sized files do not share an ETag.
+func (s *Server) fileETag(name string, info fs.FileInfo) string {
+	if s.config.Performance.ETagMode != ETagModeContent && !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().Unix(), info.Size())
+	}
+	return s.contentETag(name, info)
+}
+
+// contentETag returns a strong ETag hashed from the file content. Hashes
+// are cached per file and recomputed when its inode, mtime or size change.
+func (s *Server) contentETag(name string, info fs.FileInfo) string {
+	stamp := etagStamp{modTime: info.ModTime(), size: info.Size(), inode: fileInode(info)}
+	if cached, ok := s.etags.Load(name); ok && cached.(etagEntry).stamp == stamp {
+		return cached.(etagEntry).etag
+	}
+
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return fmt.Sprintf(`"0-%x"`, info.Size())
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Sprintf(`"0-%x"`, info.Size())
+	}
+	etag := fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+	s.etags.Store(name, etagEntry{stamp: stamp, etag: etag})
+	return etag
+}
+
+// etagStamp identifies the file version a cached content ETag belongs to
+type etagStamp struct {
+	modTime time.Time
+	size    int64
+	inode   uint64
+}
+
+type etagEntry struct {
+	stamp etagStamp
+	etag  string
+}
+
+// servePrecompressed serves a precompressed sidecar of name when one exists
+// and the client accepts its encoding. It returns false when the caller
+// should fall back to serving the original file.
+func (s *Server) servePrecompressed(w http.ResponseWriter, r *http.Request, name string) bool {
+	// Content-Type must come from the original name, never from sniffing compressed bytes
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		return false
+	}
+
+	type sidecar struct {
+		coding contentCoding
+		name   string
+		info   fs.FileInfo
+	}
+
+	sidecars := make(map[string]sidecar)
+	var offers []string
+	for _, coding := range s.precompressedCodings() {
+		sidecarName := name + coding.Extension
+		info, err := fs.Stat(s.fsys, sidecarName)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		sidecars[coding.Name] = sidecar{coding: coding, name: sidecarName, info: info}
+		offers = append(offers, coding.Name)
+	}
+	if len(offers) == 0 {
+		return false
+	}
+
+	// The response now depends on Accept-Encoding, whichever variant is chosen
+	addVary(w.Header(), "Accept-Encoding")
+
+	chosen := negotiateEncoding(r.Header.Get("Accept-Encoding"), offers)
+	if chosen == "" {
+		return false
+	}
+	variant := sidecars[chosen]
+
+	content, closeFile, err := openSeekable(s.fsys, variant.name)
+	if err != nil {
+		s.logger.Error("Error opening precompressed file %s: %v", variant.name, err)
+		return false
+	}
+	defer closeFile()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", variant.coding.Name)
+
+	etag := ""
+	if s.config.Performance.EnableETags {
+		// Each encoding is a distinct representation and needs its own strong ETag
+		etag = strings.TrimSuffix(s.fileETag(variant.name, variant.info), `"`) + "-" + variant.coding.Name + `"`
+		w.Header().Set("ETag", etag)
+	}
+
+	r, ok := s.evaluatePreconditions(w, r, etag, variant.info.ModTime())
+	if !ok {
+		return true
+	}
+
+	// ServeContent handles Range, HEAD and If-Modified-Since on the encoded bytes
+	http.ServeContent(w, r, path.Base(name), variant.info.ModTime(), content)
+	return true
+}
+
+// precompressedCodings returns the sidecar codings to look for, in preference order
+func (s *Server) precompressedCodings() []contentCoding {
+	names := s.config.Performance.PrecompressedEncodings
+	if len(names) == 0 {
+		return knownCodings
+	}
+
+	codings := make([]contentCoding, 0, len(names))
+	for _, name := range names {
+		if coding, ok := lookupCoding(name); ok {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
 }
 
 // serveSPAIndex serves index.html in SPA mode
 func (s *Server) serveSPAIndex(w http.ResponseWriter, r *http.Request) {
-	indexPath := filepath.Join(s.config.Server.RootDir, s.config.Features.SPAIndex)
-	info, err := os.Stat(indexPath)
-	if err != nil {
+	indexName := fsName(s.config.Features.SPAIndex)
+	info, err := fs.Stat(s.fsys, indexName)
+	if err != nil || info.IsDir() {
 		s.serveError(w, r, http.StatusNotFound)
 		return
 	}
-	s.serveFile(w, r, indexPath, info)
+	s.serveFile(w, r, indexName, info)
 }
 
 // serveDirectoryListing serves a directory listing
-func (s *Server) serveDirectoryListing(w http.ResponseWriter, r *http.Request, path string) {
-	entries, err := os.ReadDir(path)
+func (s *Server) serveDirectoryListing(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := fs.ReadDir(s.fsys, name)
 	if err != nil {
-		s.logger.Error("Error reading directory %s: %v", path, err)
+		s.logger.Error("Error reading directory %s: %v", name, err)
 		s.serveError(w, r, http.StatusInternalServerError)
 		return
 	}
@@ -288,6 +777,9 @@ func (s *Server) serveDirectoryListing(w http.ResponseWriter, r *http.Request, p
 		ModTime string
 	}
 
+	// Links are built from the full URL path, including any mount prefix
+	urlPath := path.Join("/", s.urlPrefix, r.URL.Path)
+
 	var files []FileInfo
 	for _, entry := range entries {
 		info, err := entry.Info()
@@ -300,12 +792,17 @@ func (s *Server) serveDirectoryListing(w http.ResponseWriter, r *http.Request, p
 			size = formatSize(info.Size())
 		}
 
+		modTime := "-"
+		if !info.ModTime().IsZero() {
+			modTime = info.ModTime().Format("2006-01-02 15:04:05")
+		}
+
 		files = append(files, FileInfo{
 			Name:    entry.Name(),
-			Path:    filepath.Join(r.URL.Path, entry.Name()),
+			Path:    path.Join(urlPath, entry.Name()),
 			IsDir:   entry.IsDir(),
 			Size:    size,
-			ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
+			ModTime: modTime,
 		})
 	}
 
@@ -316,7 +813,7 @@ func (s *Server) serveDirectoryListing(w http.ResponseWriter, r *http.Request, p
 		Path  string
 		Files []FileInfo
 	}{
-		Path:  r.URL.Path,
+		Path:  urlPath,
 		Files: files,
 	}
 
@@ -332,9 +829,17 @@ func (s *Server) serveError(w http.ResponseWriter, r *http.Request, status int)
 	// Check whether a custom error page exists
 	if s.config.Features.CustomErrorPages != nil {
 		if errorPage, ok := s.config.Features.CustomErrorPages[fmt.Sprintf("%d", status)]; ok {
-			errorPath := filepath.Join(s.config.Server.RootDir, errorPage)
-			if _, err := os.Stat(errorPath); err == nil {
-				http.ServeFile(w, r, errorPath)
+			if content, err := fs.ReadFile(s.fsys, fsName(errorPage)); err == nil {
+				contentType := mime.TypeByExtension(path.Ext(errorPage))
+				if contentType == "" {
+					contentType = http.DetectContentType(content)
+				}
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Del("ETag")
+				w.WriteHeader(status)
+				if r.Method != http.MethodHead {
+					w.Write(content)
+				}
 				return
 			}
 		}
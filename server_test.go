@@ -2,14 +2,17 @@ package koryxserv
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -397,6 +400,69 @@ func TestServerStartAndGracefulShutdown(t *testing.T) {
 	}
 }
 
+func TestServerReloadTLSDuringStart(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCert(t, certFile, keyFile, "old.test", time.Now().Add(90*24*time.Hour))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to allocate free port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	config := DefaultConfig()
+	config.Server.Host = "127.0.0.1"
+	config.Server.Port = listener.Addr().(*net.TCPAddr).Port
+	config.TLS = &TLSConfig{Enabled: true, Certificates: []TLSCertificateConfig{{CertFile: certFile, KeyFile: keyFile}}}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	server := NewServer(config, logger)
+
+	// Before Start there is nothing to reload yet
+	if err := server.ReloadTLS(); err != nil {
+		t.Fatalf("ReloadTLS before Start failed: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Start()
+	}()
+	defer server.Shutdown(context.Background())
+
+	served := func() (string, error) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	// Reloads while Start loads the certificates must not race with it
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if err := server.ReloadTLS(); err != nil {
+			t.Fatalf("ReloadTLS during Start failed: %v", err)
+		}
+		if _, err := served(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not become ready in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	writeTestCert(t, certFile, keyFile, "new.test", time.Now().Add(90*24*time.Hour))
+	if err := server.ReloadTLS(); err != nil {
+		t.Fatalf("ReloadTLS failed: %v", err)
+	}
+	if name, err := served(); err != nil || name != "new.test" {
+		t.Errorf("expected the reloaded certificate, got %q (%v)", name, err)
+	}
+}
+
 func TestNewHandler(t *testing.T) {
 	root := t.TempDir()
 	if err := os.WriteFile(root+"/index.html", []byte("ok"), 0o644); err != nil {
@@ -424,3 +490,529 @@ func TestNewHandler(t *testing.T) {
 		t.Fatalf("expected body to contain served content, got %q", w.Body.String())
 	}
 }
+
+func TestServePrecompressedSidecars(t *testing.T) {
+	root := t.TempDir()
+	original := strings.Repeat("console.log('hello');\n", 50)
+	files := map[string]string{
+		"app.js":    original,
+		"app.js.br": "brotli-bytes",
+		"app.js.gz": "gzip-bytes-0123456789",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(root+"/"+name, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Performance.Precompressed = true
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, _ := NewHandler(config, logger)
+
+	t.Run("PrefersBrotli", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Header().Get("Content-Encoding") != "br" {
+			t.Fatalf("expected Content-Encoding br, got %q", w.Header().Get("Content-Encoding"))
+		}
+		if w.Body.String() != "brotli-bytes" {
+			t.Fatalf("expected sidecar body, got %q", w.Body.String())
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+			t.Fatalf("expected javascript Content-Type, got %q", w.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+			t.Fatalf("expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+		}
+		if !strings.HasSuffix(w.Header().Get("ETag"), `-br"`) {
+			t.Fatalf("expected encoding-specific ETag, got %q", w.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("RespectsQValues", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", "br;q=0.1, gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected Content-Encoding gzip, got %q", w.Header().Get("Content-Encoding"))
+		}
+		if w.Body.String() != "gzip-bytes-0123456789" {
+			t.Fatalf("expected gzip sidecar body untouched by live compression, got %q", w.Body.String())
+		}
+	})
+
+	t.Run("RangeAppliesToEncodedBytes", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Range", "bytes=0-3")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("expected 206, got %d", w.Code)
+		}
+		if w.Body.String() != "gzip" {
+			t.Fatalf("expected first 4 encoded bytes, got %q", w.Body.String())
+		}
+	})
+
+	t.Run("IfNoneMatch", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		req2 := httptest.NewRequest("GET", "/app.js", nil)
+		req2.Header.Set("Accept-Encoding", "br")
+		req2.Header.Set("If-None-Match", w.Header().Get("ETag"))
+		w2 := httptest.NewRecorder()
+		handler.ServeHTTP(w2, req2)
+
+		if w2.Code != http.StatusNotModified {
+			t.Fatalf("expected 304, got %d", w2.Code)
+		}
+	})
+
+	t.Run("IdentityFallback", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("expected no Content-Encoding, got %q", w.Header().Get("Content-Encoding"))
+		}
+		if w.Body.String() != original {
+			t.Fatalf("expected original body")
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+			t.Fatalf("expected Vary: Accept-Encoding on identity response too")
+		}
+	})
+}
+
+func TestCompressedFileRevalidation(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(root+"/app.css", []byte(strings.Repeat("body { color: red; }\n", 200)), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, _ := NewHandler(config, logger)
+
+	req := httptest.NewRequest("GET", "/app.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip response, got %q", w.Header().Get("Content-Encoding"))
+	}
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected weak ETag on compressed response, got %q", etag)
+	}
+
+	req2 := httptest.NewRequest("GET", "/app.css", nil)
+	req2.Header.Set("Accept-Encoding", "gzip")
+	req2.Header.Set("If-None-Match", etag)
+	w2 := httptest.NewRecorder()
+	handler.ServeHTTP(w2, req2)
+
+	if w2.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", w2.Code)
+	}
+	if w2.Header().Get("ETag") != etag {
+		t.Fatalf("expected 304 to echo %q, got %q", etag, w2.Header().Get("ETag"))
+	}
+	if w2.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("expected no Content-Encoding on 304")
+	}
+}
+
+func TestNewHandlerFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":      {Data: []byte("<h1>embedded</h1>")},
+		"docs/a.txt":      {Data: []byte("aaaa")},
+		"docs/b.txt":      {Data: []byte("bbbb")},
+		"errors/404.html": {Data: []byte("custom not found")},
+	}
+
+	config := DefaultConfig()
+	config.Server.RootDir = "/does/not/matter"
+	config.Features.DirectoryListing = true
+	config.Features.CustomErrorPages = map[string]string{"404": "errors/404.html"}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+
+	handler, err := NewHandlerFS(config, logger, fsys)
+	if err != nil {
+		t.Fatalf("expected NewHandlerFS to succeed, got error: %v", err)
+	}
+
+	t.Run("Index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "<h1>embedded</h1>" {
+			t.Fatalf("expected embedded index, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("ContentETags", func(t *testing.T) {
+		w1 := httptest.NewRecorder()
+		handler.ServeHTTP(w1, httptest.NewRequest("GET", "/docs/a.txt", nil))
+		w2 := httptest.NewRecorder()
+		handler.ServeHTTP(w2, httptest.NewRequest("GET", "/docs/b.txt", nil))
+
+		etagA, etagB := w1.Header().Get("ETag"), w2.Header().Get("ETag")
+		if etagA == "" || etagA == etagB {
+			t.Fatalf("expected distinct ETags for same-size files without mtime, got %q and %q", etagA, etagB)
+		}
+
+		req := httptest.NewRequest("GET", "/docs/a.txt", nil)
+		req.Header.Set("If-None-Match", etagA)
+		w3 := httptest.NewRecorder()
+		handler.ServeHTTP(w3, req)
+		if w3.Code != http.StatusNotModified {
+			t.Fatalf("expected 304, got %d", w3.Code)
+		}
+	})
+
+	t.Run("DirectoryListing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "a.txt") || !strings.Contains(w.Body.String(), "b.txt") {
+			t.Fatalf("expected listing with a.txt and b.txt, got %d", w.Code)
+		}
+	})
+
+	t.Run("CustomErrorPage", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 status, got %d", w.Code)
+		}
+		if w.Body.String() != "custom not found" {
+			t.Fatalf("expected custom error page, got %q", w.Body.String())
+		}
+	})
+
+	t.Run("SPAFallback", func(t *testing.T) {
+		spaConfig := DefaultConfig()
+		spaConfig.Features.SPAMode = true
+		spaHandler, _ := NewHandlerFS(spaConfig, logger, fsys)
+
+		w := httptest.NewRecorder()
+		spaHandler.ServeHTTP(w, httptest.NewRequest("GET", "/app/route", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "<h1>embedded</h1>" {
+			t.Fatalf("expected SPA index, got %d %q", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestNewHandlerInvalidRoot(t *testing.T) {
+	config := DefaultConfig()
+	config.Server.RootDir = "/does/not/exist/koryx-serv"
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+
+	if _, err := NewHandler(config, logger); err == nil {
+		t.Fatalf("expected NewHandler to fail for a missing root directory")
+	}
+}
+
+func TestMountPoints(t *testing.T) {
+	root := t.TempDir()
+	docs := t.TempDir()
+	static := t.TempDir()
+	os.WriteFile(root+"/index.html", []byte("spa shell"), 0o644)
+	os.WriteFile(docs+"/guide.html", []byte("guide"), 0o644)
+	os.WriteFile(docs+"/.draft.html", []byte("draft"), 0o644)
+	os.Mkdir(static+"/img", 0o755)
+	os.WriteFile(static+"/img/logo.svg", []byte("<svg/>"), 0o644)
+
+	enabled, disabled := true, false
+	immutable := 31536000
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Features.SPAMode = true
+	config.Mounts = []MountConfig{
+		{Prefix: "/docs", RootDir: docs, SPAMode: &disabled, BlockHiddenFiles: &disabled},
+		{Prefix: "/static/", RootDir: static, DirectoryListing: &enabled, CacheMaxAge: &immutable},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("expected NewHandler to succeed, got error: %v", err)
+	}
+
+	tests := []struct {
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"/docs/guide.html", http.StatusOK, "guide"},
+		{"/docs/.draft.html", http.StatusOK, "draft"},
+		{"/docs/missing", http.StatusNotFound, ""},
+		{"/static/img/logo.svg", http.StatusOK, "<svg/>"},
+		// Inherited SPA mode looks for index.html inside the mount's own root
+		{"/static/missing.js", http.StatusNotFound, ""},
+		{"/app/route", http.StatusOK, "spa shell"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.expectedCode, w.Code)
+		}
+		if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+			t.Errorf("%s: expected body %q, got %q", tt.path, tt.expectedBody, w.Body.String())
+		}
+	}
+
+	t.Run("PerMountCachePolicy", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/static/img/logo.svg", nil))
+		if !strings.Contains(w.Header().Get("Cache-Control"), "max-age=31536000") {
+			t.Fatalf("expected mount cache policy, got %q", w.Header().Get("Cache-Control"))
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/docs/guide.html", nil))
+		if !strings.Contains(w.Header().Get("Cache-Control"), "max-age=3600") {
+			t.Fatalf("expected inherited cache policy, got %q", w.Header().Get("Cache-Control"))
+		}
+	})
+
+	t.Run("ListingUsesFullPaths", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/static/img/", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `href="/static/img/logo.svg"`) {
+			t.Fatalf("expected listing link with mount prefix, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestVirtualHosts(t *testing.T) {
+	root := t.TempDir()
+	blog := t.TempDir()
+	tenants := t.TempDir()
+	os.WriteFile(root+"/index.html", []byte("default"), 0o644)
+	os.WriteFile(blog+"/index.html", []byte("blog"), 0o644)
+	os.WriteFile(tenants+"/index.html", []byte("tenant"), 0o644)
+	os.WriteFile(tenants+"/secret.txt", []byte("secret"), 0o644)
+	docs := t.TempDir()
+	os.WriteFile(docs+"/index.html", []byte("docs"), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Mounts = []MountConfig{{Prefix: "/docs", RootDir: docs}}
+	config.Hosts = map[string]*HostConfig{
+		"blog.example.com": {RootDir: blog},
+		"*.example.com": {
+			RootDir:  tenants,
+			Security: &SecurityConfig{BlockedPaths: []string{"/secret.txt"}},
+			Mounts:   []MountConfig{},
+		},
+		"*.eu.example.com": {RootDir: blog},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("expected NewHandler to succeed, got error: %v", err)
+	}
+
+	tests := []struct {
+		host         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"blog.example.com", "/", http.StatusOK, "blog"},
+		{"BLOG.example.com:8080", "/", http.StatusOK, "blog"},
+		{"blog.example.com.", "/", http.StatusOK, "blog"},
+		{"acme.example.com", "/", http.StatusOK, "tenant"},
+		{"acme.example.com", "/secret.txt", http.StatusForbidden, ""},
+		{"shop.eu.example.com", "/", http.StatusOK, "blog"},
+		{"blog.example.com", "/docs/", http.StatusOK, "docs"},
+		{"acme.example.com", "/docs/", http.StatusNotFound, ""},
+		{"example.com", "/", http.StatusOK, "default"},
+		{"other.test", "/", http.StatusOK, "default"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.expectedCode {
+			t.Errorf("%s%s: expected status %d, got %d", tt.host, tt.path, tt.expectedCode, w.Code)
+		}
+		if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+			t.Errorf("%s%s: expected body %q, got %q", tt.host, tt.path, tt.expectedBody, w.Body.String())
+		}
+	}
+
+	config.Hosts = map[string]*HostConfig{"a.*.example.com": {RootDir: blog}}
+	if _, err := NewHandler(config, logger); err == nil {
+		t.Fatalf("expected error for unsupported wildcard position")
+	}
+}
+
+func TestVirtualHostsShareMainState(t *testing.T) {
+	other := t.TempDir()
+	os.WriteFile(other+"/index.html", []byte("other"), 0o644)
+
+	config := DefaultConfig()
+	config.Security.RateLimit = &RateLimitConfig{Enabled: true, RequestsPerIP: 1, BurstSize: 2}
+	config.Hosts = map[string]*HostConfig{
+		"a.test": {},
+		"b.test": {RootDir: other, Security: &SecurityConfig{RateLimit: &RateLimitConfig{Enabled: true, RequestsPerIP: 1, BurstSize: 2}}},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandlerFS(config, logger, fstest.MapFS{"only-in-fs.txt": {Data: []byte("from fs")}})
+	if err != nil {
+		t.Fatalf("expected NewHandlerFS to succeed, got error: %v", err)
+	}
+
+	serve := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// A host without root_dir serves the filesystem given to NewHandlerFS
+	for _, host := range []string{"other.test", "a.test"} {
+		if w := serve(host, "/only-in-fs.txt"); w.Code != http.StatusOK || w.Body.String() != "from fs" {
+			t.Errorf("%s: expected the file from the fs.FS, got %d %q", host, w.Code, w.Body.String())
+		}
+	}
+
+	// Hosts inheriting security share the main rate limit, others have their own
+	if w := serve("a.test", "/only-in-fs.txt"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the shared quota to be used up, got %d", w.Code)
+	}
+	if w := serve("b.test", "/"); w.Code != http.StatusOK {
+		t.Errorf("expected a host with its own security to have its own quota, got %d", w.Code)
+	}
+}
+
+func TestRedirectAndRewriteRules(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(root+"/index.html", []byte("spa shell"), 0o644)
+	os.Mkdir(root+"/app", 0o755)
+	os.WriteFile(root+"/app/shell.html", []byte("app shell"), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Features.SPAMode = true
+	config.Rules = []RuleConfig{
+		{From: "/old-blog/*", To: "/blog/:splat"},
+		{From: "/app/*", To: "/app/shell.html", Type: RuleRewrite},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("expected NewHandler to succeed, got error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/old-blog/hello", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/blog/hello" {
+		t.Fatalf("expected redirect to /blog/hello, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected redirect without cache headers, got %q", w.Header().Get("Cache-Control"))
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/app/settings", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "app shell" {
+		t.Fatalf("expected rewritten app shell, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	if w.Body.String() != "spa shell" {
+		t.Fatalf("expected SPA fallback outside rules, got %q", w.Body.String())
+	}
+
+	// An invalid rule fails setup instead of dropping every rule
+	config.Rules = append(config.Rules, RuleConfig{From: "/app", To: "app.html", Type: RuleRewrite})
+	if _, err := NewHandler(config, logger); err == nil {
+		t.Error("expected an error for an invalid rule")
+	}
+	config.Rules = config.Rules[:2]
+	config.Hosts = map[string]*HostConfig{"a.test": {Rules: []RuleConfig{{From: "/x", To: "/y", Status: http.StatusOK}}}}
+	if _, err := NewHandler(config, logger); err == nil {
+		t.Error("expected an error for an invalid host rule")
+	}
+}
+
+func TestRewriteTargetsAreChecked(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"files/readme.txt":   "readme",
+		"admin/secret.txt":   "SECRET",
+		"private/report.txt": "report",
+		".env":               "DOTENV",
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755)
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0o644)
+	}
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Security.BlockedPaths = []string{"/admin/**"}
+	config.Security.BasicAuth = &BasicAuthConfig{Users: map[string]string{"alice": "alice-pw"}}
+	config.Security.AuthRules = []AuthRuleConfig{{Paths: []string{"/private/**"}, Auth: AuthBasic}}
+	config.Rules = []RuleConfig{
+		{From: "/download", Query: map[string]string{"f": ":f"}, To: "/files/:f", Type: RuleRewrite},
+		{From: "/adm", To: "/admin/secret.txt", Type: RuleRewrite},
+		{From: "/report", To: "/private/report.txt", Type: RuleRewrite},
+	}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("expected NewHandler to succeed, got error: %v", err)
+	}
+
+	tests := []struct {
+		target string
+		user   string
+		status int
+		body   string
+	}{
+		{"/download?f=readme.txt", "", http.StatusOK, "readme"},
+		{"/download?f=../admin/secret.txt", "", http.StatusNotFound, ""},
+		{"/download?f=../.env", "", http.StatusNotFound, ""},
+		{"/download?f=%2e%2e%2f.env", "", http.StatusNotFound, ""},
+		{"/adm", "", http.StatusForbidden, ""},
+		{"/report", "", http.StatusUnauthorized, ""},
+		{"/report", "alice", http.StatusOK, "report"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.user+"-pw")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.target, tt.status, w.Code)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.target, tt.body, w.Body.String())
+		}
+	}
+}
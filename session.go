@@ -0,0 +1,70 @@
+package koryxserv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// sessionCodec seals values into encrypted, tamper-proof cookie values.
+// AES-256-GCM authenticates the cookie name along with the content, so a
+// value sealed for one cookie cannot be replayed as another.
+type sessionCodec struct {
+	aead cipher.AEAD
+}
+
+// sealedValue is the plaintext of a sealed cookie
+type sealedValue struct {
+	Expires int64           `json:"exp"`
+	Value   json.RawMessage `json:"v"`
+}
+
+func newSessionCodec(secret string) *sessionCodec {
+	key := sha256.Sum256([]byte(secret))
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return &sessionCodec{aead: aead}
+}
+
+// seal encrypts v for the named cookie until expires
+func (c *sessionCodec) seal(name string, v any, expires time.Time) (string, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(sealedValue{Expires: expires.Unix(), Value: value})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	rand.Read(nonce)
+	sealed := c.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value sealed for the named cookie into v
+func (c *sessionCodec) open(name, cookie string, v any) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return errors.New("malformed cookie")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return errors.New("invalid cookie")
+	}
+
+	var value sealedValue
+	if err := json.Unmarshal(plaintext, &value); err != nil {
+		return errors.New("invalid cookie")
+	}
+	if time.Now().Unix() > value.Expires {
+		return errors.New("expired cookie")
+	}
+	return json.Unmarshal(value.Value, v)
+}
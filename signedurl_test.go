@@ -0,0 +1,151 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURLMiddleware(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := SignedURLMiddleware(&SignedURLConfig{
+		Enabled: true,
+		Secret:  secret,
+		Paths:   []string{"/downloads/**"},
+	}, logger)(testHandler())
+
+	sign := func(path string, expires time.Time, options *SignURLOptions) string {
+		signed, err := SignURL(path, secret, expires, options)
+		if err != nil {
+			t.Fatalf("SignURL failed: %v", err)
+		}
+		return signed
+	}
+	later := time.Now().Add(time.Hour)
+	valid := sign("/downloads/report.pdf?v=2", later, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		remote string
+		status int
+	}{
+		{"unprotected path", "GET", "/index.html", "", http.StatusOK},
+		{"unsigned", "GET", "/downloads/report.pdf", "", http.StatusForbidden},
+		{"encoded dot segments", "GET", "/public/%2e%2e/downloads/report.pdf", "", http.StatusForbidden},
+		{"signed", "GET", valid, "", http.StatusOK},
+		{"other path", "GET", strings.Replace(valid, "report", "secret", 1), "", http.StatusForbidden},
+		{"extended expiry", "GET", strings.Replace(valid, "expires=", "expires=9", 1), "", http.StatusForbidden},
+		{"expired", "GET", sign("/downloads/report.pdf", time.Now().Add(-time.Second), nil), "", http.StatusForbidden},
+		{"bound method", "HEAD", sign("/downloads/report.pdf", later, &SignURLOptions{Method: "get"}), "", http.StatusOK},
+		{"other method", "DELETE", sign("/downloads/report.pdf", later, &SignURLOptions{Method: "GET"}), "", http.StatusForbidden},
+		{"bound client", "GET", sign("/downloads/report.pdf", later, &SignURLOptions{ClientIP: "::ffff:192.0.2.1"}), "192.0.2.1:1234", http.StatusOK},
+		{"other client", "GET", sign("/downloads/report.pdf", later, &SignURLOptions{ClientIP: "192.0.2.1"}), "192.0.2.2:1234", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.remote != "" {
+				req.RemoteAddr = tt.remote
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("expected %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestSignURL(t *testing.T) {
+	signed, err := SignURL("https://files.example.com/a%20b.txt?sig=old&x=1", "secret", time.Unix(1700000000, 0), nil)
+	if err != nil {
+		t.Fatalf("SignURL failed: %v", err)
+	}
+	u, _ := url.Parse(signed)
+	query := u.Query()
+	if u.Host != "files.example.com" || query.Get("x") != "1" || query.Get("expires") != "1700000000" {
+		t.Errorf("unexpected signed URL %s", signed)
+	}
+	if query.Get("sig") != signedURLSignature("secret", "/a b.txt", "1700000000", "", "") {
+		t.Errorf("expected the old signature to be replaced, got %s", signed)
+	}
+
+	if _, err := SignURL("report.pdf", "secret", time.Now(), nil); err == nil {
+		t.Errorf("expected error for a relative path")
+	}
+	if _, err := SignURL("/report.pdf", "secret", time.Now(), &SignURLOptions{ClientIP: "nope"}); err == nil {
+		t.Errorf("expected error for an invalid client IP")
+	}
+}
+
+func TestAuthRulesWithSignedURLs(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler := AuthMiddleware(&SecurityConfig{
+		BasicAuth:  &BasicAuthConfig{Enabled: true, Username: "admin", Password: "secret"},
+		SignedURLs: &SignedURLConfig{Enabled: true, Secret: secret, Paths: []string{"/downloads/**"}},
+		AuthRules:  []AuthRuleConfig{{Paths: []string{"/downloads/public/**"}, Auth: AuthNone}},
+	}, logger)(testHandler())
+
+	signed, _ := SignURL("/downloads/report.pdf", secret, time.Now().Add(time.Minute), nil)
+	for target, want := range map[string]int{
+		signed:                                http.StatusOK,
+		"/downloads/report.pdf":               http.StatusForbidden,
+		"/downloads/public/readme.txt":        http.StatusOK,
+		"/downloads/public/%2e%2e/report.pdf": http.StatusForbidden,
+		"/index.html":                         http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, w.Code)
+		}
+	}
+}
+
+func TestSignedURLsCleanPaths(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "admin"), 0o755)
+	os.MkdirAll(filepath.Join(root, "files"), 0o755)
+	os.WriteFile(filepath.Join(root, "admin", "secret.txt"), []byte("SECRET"), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Security.SignedURLs = &SignedURLConfig{Enabled: true, Secret: secret, Paths: []string{"/admin/**"}}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+
+	signed, _ := SignURL("/admin/secret.txt", secret, time.Now().Add(time.Minute), nil)
+	for target, want := range map[string]int{
+		signed:                           http.StatusOK,
+		"/files/%2e%2e/admin/secret.txt": http.StatusForbidden,
+		"/files/%2E%2E/admin/secret.txt": http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, w.Code)
+		}
+	}
+
+	// Invalid paths fail closed
+	handler = SignedURLMiddleware(&SignedURLConfig{Enabled: true, Secret: secret, Paths: []string{"/bad/[x"}}, logger)(testHandler())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with invalid signed_urls paths, got %d", w.Code)
+	}
+}
@@ -0,0 +1,457 @@
+package koryxserv
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Netlify/Cloudflare Pages style site files, read from the served root
+const (
+	redirectsFileName = "_redirects"
+	headersFileName   = "_headers"
+)
+
+// siteFilesCheckInterval limits how often the site files are checked for changes
+const siteFilesCheckInterval = time.Second
+
+// siteFiles holds the parsed _redirects and _headers files of a filesystem
+// and reparses them when their size or modification time changes
+type siteFiles struct {
+	fsys   fs.FS
+	logger *Logger
+
+	mu        sync.Mutex
+	checked   time.Time
+	stamps    map[string]fileStamp
+	redirects []*rewriteRule
+	headers   []*headerRule
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// headerRule is a path block from _headers
+type headerRule struct {
+	pattern *regexp.Regexp
+	host    string
+	set     []headerValue
+	detach  []string // "! Name" lines remove a header
+}
+
+type headerValue struct {
+	name  string
+	value string
+}
+
+func newSiteFiles(fsys fs.FS, logger *Logger) *siteFiles {
+	sf := &siteFiles{
+		fsys:   fsys,
+		logger: logger,
+		stamps: make(map[string]fileStamp),
+	}
+	sf.reload(time.Now())
+	return sf
+}
+
+// current returns the active rules, reloading changed files first
+func (sf *siteFiles) current() ([]*rewriteRule, []*headerRule) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if now := time.Now(); now.Sub(sf.checked) >= siteFilesCheckInterval {
+		sf.reload(now)
+	}
+	return sf.redirects, sf.headers
+}
+
+// reload reparses the site files whose stamp changed. Invalid lines are
+// logged and skipped, like Netlify does.
+func (sf *siteFiles) reload(now time.Time) {
+	sf.checked = now
+
+	if data, ok := sf.readIfChanged(redirectsFileName); ok {
+		rules, errs := parseRedirectsFile(data)
+		sf.logErrors(redirectsFileName, errs)
+		sf.redirects = rules
+		sf.logger.Info("Loaded %d rules from %s", len(rules), redirectsFileName)
+	}
+
+	if data, ok := sf.readIfChanged(headersFileName); ok {
+		rules, errs := parseHeadersFile(data)
+		sf.logErrors(headersFileName, errs)
+		sf.headers = rules
+		sf.logger.Info("Loaded %d rules from %s", len(rules), headersFileName)
+	}
+}
+
+// readIfChanged returns the content of a site file when it changed since
+// the last check. A removed file yields empty content.
+func (sf *siteFiles) readIfChanged(name string) (string, bool) {
+	var stamp fileStamp
+	if info, err := fs.Stat(sf.fsys, name); err == nil && info.Mode().IsRegular() {
+		stamp = fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+	}
+	if stamp == sf.stamps[name] {
+		return "", false
+	}
+	sf.stamps[name] = stamp
+
+	if !stamp.exists {
+		return "", true
+	}
+	data, err := fs.ReadFile(sf.fsys, name)
+	if err != nil {
+		sf.logger.Error("Error reading %s: %v", name, err)
+		return "", true
+	}
+	return string(data), true
+}
+
+func (sf *siteFiles) logErrors(name string, errs []error) {
+	for _, err := range errs {
+		sf.logger.Warn("Skipping %s %v", name, err)
+	}
+}
+
+// parseRedirectsFile parses a _redirects file. Each line reads
+// "from [param=value ...] to [status][!]"; a 200 status rewrites, 3xx
+// redirects and 4xx/5xx serve the target with that status.
+func parseRedirectsFile(data string) ([]*rewriteRule, []error) {
+	var rules []*rewriteRule
+	var errs []error
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRedirectLine(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+func parseRedirectLine(line string) (*rewriteRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected \"from to [status]\"")
+	}
+
+	host, pattern, err := compileSitePattern(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	rule := &rewriteRule{
+		pattern: pattern,
+		host:    host,
+		kind:    RuleRedirect,
+		status:  http.StatusMovedPermanently,
+	}
+
+	// Query parameter conditions sit between the source and the target
+	rest := fields[1:]
+	for len(rest) > 0 && !isSiteTarget(rest[0]) && strings.Contains(rest[0], "=") {
+		if rule.query == nil {
+			rule.query = make(map[string]string)
+		}
+		key, value, _ := strings.Cut(rest[0], "=")
+		rule.query[key] = value
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return nil, fmt.Errorf("missing target")
+	}
+	rule.to, rest = rest[0], rest[1:]
+
+	if len(rest) > 0 {
+		code, force := strings.CutSuffix(rest[0], "!")
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", rest[0])
+		}
+		rule.force = force
+		rest = rest[1:]
+
+		switch {
+		case status == http.StatusOK:
+			rule.kind, rule.status = RuleRewrite, 0
+		case status == http.StatusMovedPermanently, status == http.StatusFound, status == http.StatusSeeOther,
+			status == http.StatusTemporaryRedirect, status == http.StatusPermanentRedirect:
+			rule.status = status
+		case status >= 400 && status <= 599:
+			rule.kind, rule.status = RuleRewrite, status
+		default:
+			return nil, fmt.Errorf("unsupported status %d", status)
+		}
+	}
+
+	// Country, Language, Role and similar conditions cannot be evaluated
+	// here; dropping them would apply the rule too broadly
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unsupported condition %q", rest[0])
+	}
+	if rule.kind == RuleRewrite && !strings.HasPrefix(rule.to, "/") {
+		return nil, fmt.Errorf("proxying to %s is not supported", rule.to)
+	}
+	return rule, nil
+}
+
+// parseHeadersFile parses a _headers file: a path line followed by
+// indented "Name: value" lines (or "! Name" to remove a header)
+func parseHeadersFile(data string) ([]*headerRule, []error) {
+	var rules []*headerRule
+	var errs []error
+	var current *headerRule
+	skipping := false
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if isSiteTarget(line) {
+			current, skipping = nil, false
+			host, pattern, err := compileSitePattern(line)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+				skipping = true
+				continue
+			}
+			current = &headerRule{pattern: pattern, host: host}
+			rules = append(rules, current)
+			continue
+		}
+
+		if current == nil {
+			if !skipping {
+				errs = append(errs, fmt.Errorf("line %d: header outside of a path block", i+1))
+			}
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(line, "!"); ok {
+			current.detach = append(current.detach, strings.TrimSpace(name))
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("line %d: expected \"Name: value\"", i+1))
+			continue
+		}
+		current.set = append(current.set, headerValue{
+			name:  http.CanonicalHeaderKey(strings.TrimSpace(name)),
+			value: strings.TrimSpace(value),
+		})
+	}
+	return rules, errs
+}
+
+// isSiteTarget reports whether a token is a path or an absolute URL
+func isSiteTarget(token string) bool {
+	return strings.HasPrefix(token, "/") || strings.HasPrefix(token, "http://") || strings.HasPrefix(token, "https://")
+}
+
+// compileSitePattern compiles a site file source such as "/blog/:slug",
+// "/assets/*" or "https://old.example.com/*". A trailing slash is optional
+// when matching, as on Netlify.
+func compileSitePattern(source string) (string, *regexp.Regexp, error) {
+	host, from := "", source
+	if !strings.HasPrefix(source, "/") {
+		u, err := url.Parse(source)
+		if err != nil || u.Host == "" {
+			return "", nil, fmt.Errorf("invalid source %q", source)
+		}
+		host, from = normalizeHostPattern(u.Host), u.Path
+		if from == "" {
+			from = "/"
+		}
+	}
+
+	trimmed := from
+	if len(trimmed) > 1 {
+		trimmed = strings.TrimSuffix(trimmed, "/")
+	}
+	pattern, err := compileFromPattern(trimmed)
+	if err != nil {
+		return "", nil, err
+	}
+	if trimmed != "/" && !strings.HasSuffix(trimmed, "*") {
+		pattern, err = regexp.Compile(strings.TrimSuffix(pattern.String(), "$") + "/?$")
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return host, pattern, nil
+}
+
+// match reports whether a _headers block applies to the request
+func (rule *headerRule) match(r *http.Request) (map[string]string, bool) {
+	groups := rule.pattern.FindStringSubmatch(r.URL.Path)
+	if groups == nil {
+		return nil, false
+	}
+	if rule.host != "" {
+		if ok, _ := path.Match(rule.host, requestHostname(r.Host)); !ok {
+			return nil, false
+		}
+	}
+	return patternCaptures(rule.pattern, groups), true
+}
+
+// siteRedirectsMiddleware applies the _redirects rules. Unless forced with
+// "!", a rule is shadowed when the requested path resolves to a file.
+// The site files themselves are never served. Rewrite targets go through
+// checks first, like those of RulesMiddleware.
+func (s *Server) siteRedirectsMiddleware(checks ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		rewritten := Chain(next, checks...)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name := fsName(r.URL.Path); name == redirectsFileName || name == headersFileName {
+				s.serveError(w, r, http.StatusNotFound)
+				return
+			}
+
+			redirects, _ := s.siteFiles.current()
+			shadowed := -1 // unknown until a non-forced rule matches
+			for _, rule := range redirects {
+				captures, ok := rule.match(r)
+				if !ok {
+					continue
+				}
+				if !rule.force {
+					if shadowed < 0 {
+						shadowed = 0
+						if s.resolvesToFile(r.URL.Path) {
+							shadowed = 1
+						}
+					}
+					if shadowed == 1 {
+						continue
+					}
+				}
+				to := rule.target(r, captures)
+				if rule.kind == RuleRedirect && !rule.redirectStaysLocal(to) {
+					s.logger.Warn("Ignoring redirect %s -> %s (%s): it leaves the site", r.URL.Path, to, redirectsFileName)
+					continue
+				}
+
+				switch {
+				case rule.kind == RuleRedirect:
+					s.logger.Debug("Redirect %s -> %s (%d, %s)", r.URL.Path, to, rule.status, redirectsFileName)
+					http.Redirect(w, r, to, rule.status)
+				case rule.status != 0:
+					s.logger.Debug("Rewrite %s -> %s (%d, %s)", r.URL.Path, to, rule.status, redirectsFileName)
+					r2 := rewriteRequest(r, to)
+					// The override status has no validators or ranges to honour
+					r2.Header = r.Header.Clone()
+					for _, name := range []string{"If-None-Match", "If-Modified-Since", "Range", "If-Range"} {
+						r2.Header.Del(name)
+					}
+					rewritten.ServeHTTP(&statusOverrideWriter{ResponseWriter: w, status: rule.status}, r2)
+				default:
+					s.logger.Debug("Rewrite %s -> %s (%s)", r.URL.Path, to, redirectsFileName)
+					rewritten.ServeHTTP(w, rewriteRequest(r, to))
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// siteHeadersMiddleware applies the _headers rules matching the served
+// path. It runs after the custom and cache header middlewares so that its
+// values win; a header set by several blocks is joined with ", ".
+func (s *Server) siteHeadersMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, headers := s.siteFiles.current()
+			var applied map[string]bool
+			for _, rule := range headers {
+				captures, ok := rule.match(r)
+				if !ok {
+					continue
+				}
+				if applied == nil {
+					applied = make(map[string]bool)
+				}
+				for _, header := range rule.set {
+					value := expandPlaceholders(header.value, captures)
+					if applied[header.name] {
+						value = w.Header().Get(header.name) + ", " + value
+					}
+					w.Header().Set(header.name, value)
+					applied[header.name] = true
+				}
+				for _, name := range rule.detach {
+					w.Header().Del(name)
+					delete(applied, http.CanonicalHeaderKey(name))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// resolvesToFile reports whether a URL path would be served from a file
+// (directly or through an index file)
+func (s *Server) resolvesToFile(urlPath string) bool {
+	name := fsName(urlPath)
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return false
+	}
+	if !info.IsDir() {
+		return true
+	}
+	for _, indexFile := range s.config.Features.IndexFiles {
+		if info, err := fs.Stat(s.fsys, path.Join(name, indexFile)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// statusOverrideWriter replaces a 200 status with a _redirects status
+type statusOverrideWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusOverrideWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if code == http.StatusOK {
+			code = w.status
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusOverrideWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusOverrideWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
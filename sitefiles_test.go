@@ -0,0 +1,220 @@
+package koryxserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRedirectsFile(t *testing.T) {
+	data := `# comment
+/old /new
+/news/* /blog/:splat 302
+/store id=:id /products/:id 301!
+/* /index.html 200
+/gone /410.html 410
+/geo /us 302 Country=us
+/a /b 999
+/c
+/proxy https://api.example.com/ 200
+`
+	rules, errs := parseRedirectsFile(data)
+	if len(rules) != 5 {
+		t.Fatalf("expected 5 rules, got %d", len(rules))
+	}
+	if len(errs) != 4 {
+		t.Fatalf("expected 4 errors, got %d: %v", len(errs), errs)
+	}
+
+	if rules[0].kind != RuleRedirect || rules[0].status != http.StatusMovedPermanently {
+		t.Errorf("expected default 301 redirect, got %s %d", rules[0].kind, rules[0].status)
+	}
+	if !rules[2].force || rules[2].query["id"] != ":id" {
+		t.Errorf("expected forced rule with query condition, got %+v", rules[2])
+	}
+	if rules[3].kind != RuleRewrite || rules[3].status != 0 {
+		t.Errorf("expected plain rewrite, got %s %d", rules[3].kind, rules[3].status)
+	}
+	if rules[4].kind != RuleRewrite || rules[4].status != http.StatusGone {
+		t.Errorf("expected 410 status override, got %s %d", rules[4].kind, rules[4].status)
+	}
+}
+
+func TestParseHeadersFile(t *testing.T) {
+	data := `/*
+  X-Frame-Options: SAMEORIGIN
+  ! X-XSS-Protection
+
+/assets/*
+  cache-control: public, max-age=31536000, immutable
+/bad/*/path
+  X-Skipped: yes
+  broken line
+`
+	rules, errs := parseHeadersFile(data)
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+	}
+	if len(rules[0].detach) != 1 || rules[0].detach[0] != "X-XSS-Protection" {
+		t.Errorf("expected detached X-XSS-Protection, got %v", rules[0].detach)
+	}
+	if rules[1].set[0].name != "Cache-Control" {
+		t.Errorf("expected canonical header name, got %q", rules[1].set[0].name)
+	}
+}
+
+func TestSiteFiles(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("spa shell"), 0o644)
+	os.WriteFile(filepath.Join(root, "404.html"), []byte("not here"), 0o644)
+	os.Mkdir(filepath.Join(root, "assets"), 0o755)
+	os.WriteFile(filepath.Join(root, "assets", "app.js"), []byte("js"), 0o644)
+	os.WriteFile(filepath.Join(root, "_redirects"), []byte(`
+/old-blog/* /blog/:splat 301
+/assets/app.js /assets/other.js 302
+/forced /index.html 200!
+/removed/* /404.html 404
+/* /index.html 200
+`), 0o644)
+	os.WriteFile(filepath.Join(root, "_headers"), []byte(`
+/*
+  X-Frame-Options: SAMEORIGIN
+/assets/*
+  Cache-Control: public, max-age=31536000, immutable
+  Link: </a.css>; rel=preload
+/assets/app.js
+  Link: </b.css>; rel=preload
+`), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Features.SiteFiles = true
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	server := NewServer(config, logger)
+	if err := server.setupHandlers(); err != nil {
+		t.Fatalf("expected setup to succeed, got error: %v", err)
+	}
+	handler := server.handler
+
+	tests := []struct {
+		path         string
+		expectedCode int
+		expectedBody string
+		location     string
+	}{
+		{"/old-blog/post", http.StatusMovedPermanently, "", "/blog/post"},
+		// Existing files shadow rules that are not forced
+		{"/assets/app.js", http.StatusOK, "js", ""},
+		{"/forced", http.StatusOK, "spa shell", ""},
+		{"/removed/page", http.StatusNotFound, "not here", ""},
+		{"/dashboard/settings", http.StatusOK, "spa shell", ""},
+		{"/_redirects", http.StatusNotFound, "", ""},
+		{"/_headers", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.expectedCode, w.Code)
+		}
+		if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+			t.Errorf("%s: expected body %q, got %q", tt.path, tt.expectedBody, w.Body.String())
+		}
+		if w.Header().Get("Location") != tt.location {
+			t.Errorf("%s: expected Location %q, got %q", tt.path, tt.location, w.Header().Get("Location"))
+		}
+	}
+
+	t.Run("Headers", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/assets/app.js", nil))
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+			t.Errorf("expected _headers to override cache policy, got %q", got)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+			t.Errorf("expected _headers to override security header, got %q", got)
+		}
+		if got := w.Header().Get("Link"); got != "</a.css>; rel=preload, </b.css>; rel=preload" {
+			t.Errorf("expected joined Link header, got %q", got)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		redirects := filepath.Join(root, "_redirects")
+		os.WriteFile(redirects, []byte("/old-blog/* /articles/:splat 302\n"), 0o644)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(redirects, later, later)
+		server.siteFiles.checked = time.Time{}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/old-blog/post", nil))
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/articles/post" {
+			t.Fatalf("expected reloaded redirect, got %d %q", w.Code, w.Header().Get("Location"))
+		}
+	})
+}
+
+func TestSiteRedirectsChecked(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "files"), 0o755)
+	os.Mkdir(filepath.Join(root, "admin"), 0o755)
+	os.WriteFile(filepath.Join(root, "files", "readme.txt"), []byte("readme"), 0o644)
+	os.WriteFile(filepath.Join(root, "admin", "secret.txt"), []byte("SECRET"), 0o644)
+	os.WriteFile(filepath.Join(root, ".env"), []byte("DOTENV"), 0o644)
+	os.WriteFile(filepath.Join(root, "_redirects"), []byte(`
+/dl f=:f /files/:f 200
+/adm /admin/secret.txt 200
+/old/* /:splat 302
+`), 0o644)
+
+	config := DefaultConfig()
+	config.Server.RootDir = root
+	config.Features.SiteFiles = true
+	config.Security.BlockedPaths = []string{"/admin/**"}
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	handler, err := NewHandler(config, logger)
+	if err != nil {
+		t.Fatalf("expected NewHandler to succeed, got error: %v", err)
+	}
+
+	tests := []struct {
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"/dl?f=readme.txt", http.StatusOK, "readme"},
+		{"/dl?f=../.env", http.StatusNotFound, ""},
+		{"/adm", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.expectedCode, w.Code)
+		}
+		if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+			t.Errorf("%s: expected body %q, got %q", tt.path, tt.expectedBody, w.Body.String())
+		}
+	}
+
+	// Captures cannot send a local redirect to another site
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/old/files/readme.txt", nil))
+	if got := w.Header().Get("Location"); got != "/files/readme.txt" {
+		t.Errorf("expected a local redirect, got %q", got)
+	}
+	for _, path := range []string{"/old/%5Cevil.com", "/old/%2Fevil.com"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if got := w.Header().Get("Location"); strings.HasPrefix(got, "//") || strings.HasPrefix(got, "/\\") {
+			t.Errorf("%s: expected no redirect to another site, got %q", path, got)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package koryxserv
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sessionTicketKeyCount is how many session ticket keys are kept when they
+// are rotated: the current one and those still accepted for resumption
+const sessionTicketKeyCount = 3
+
+// tlsVersions are the accepted min_version values
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the accepted curves, keyed by lowercase name without dashes
+var tlsCurves = map[string]tls.CurveID{
+	"x25519mlkem768": tls.X25519MLKEM768,
+	"x25519":         tls.X25519,
+	"p256":           tls.CurveP256,
+	"p384":           tls.CurveP384,
+	"p521":           tls.CurveP521,
+}
+
+// certStore serves the certificate matching a client's SNI name among
+// several reloaded pairs, and the first pair to clients asking for an
+// unknown name or none. Pairs in cert_dir are rescanned when the directory
+// changes or on reload.
+type certStore struct {
+	config *TLSConfig
+	logger *Logger
+
+	pairs   atomic.Pointer[[]*certReloader]
+	checked atomic.Int64 // unix nanoseconds of the last cert_dir check
+
+	mu       sync.Mutex // serializes reloads
+	dirStamp fileStamp
+}
+
+// newCertStore loads every pair; it fails if any pair is invalid
+func newCertStore(config *TLSConfig, logger *Logger) (*certStore, error) {
+	s := &certStore{config: config, logger: logger}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	s.checked.Store(time.Now().UnixNano())
+	return s, nil
+}
+
+// GetCertificate picks the first pair the client supports for its SNI name
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.poll()
+	pairs := *s.pairs.Load()
+	for _, pair := range pairs {
+		pair.poll()
+	}
+
+	if hello.ServerName != "" {
+		for _, pair := range pairs {
+			if cert := pair.cert.Load(); hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return pairs[0].cert.Load(), nil
+}
+
+// poll rescans cert_dir when its modification time changed
+func (s *certStore) poll() {
+	if s.config.CertDir == "" {
+		return
+	}
+	now := time.Now().UnixNano()
+	if now-s.checked.Load() < int64(watchedFileCheckInterval) {
+		return
+	}
+
+	s.mu.Lock()
+	if now-s.checked.Load() < int64(watchedFileCheckInterval) {
+		s.mu.Unlock()
+		return
+	}
+	s.checked.Store(now)
+	changed := s.currentDirStamp() != s.dirStamp
+	s.mu.Unlock()
+
+	if changed {
+		if err := s.reload(); err != nil {
+			s.logger.Error("Reloading TLS certificates: %v", err)
+		}
+	}
+}
+
+func (s *certStore) currentDirStamp() fileStamp {
+	info, err := os.Stat(s.config.CertDir)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// reload rereads every pair and rescans cert_dir. Pairs that fail keep
+// their current certificate, new pairs that fail are left out, and the
+// previous set stays in use if no pair is left.
+func (s *certStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	files := slices.Clone(s.config.Certificates)
+	if s.config.CertDir != "" {
+		s.dirStamp = s.currentDirStamp()
+		found, err := scanCertDir(s.config.CertDir)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		files = append(files, found...)
+	}
+
+	current := make(map[TLSCertificateConfig]*certReloader)
+	if old := s.pairs.Load(); old != nil {
+		for _, pair := range *old {
+			current[TLSCertificateConfig{CertFile: pair.certFile, KeyFile: pair.keyFile}] = pair
+		}
+	}
+
+	var pairs []*certReloader
+	seen := make(map[TLSCertificateConfig]bool)
+	for _, file := range files {
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+
+		if pair, ok := current[file]; ok {
+			if err := pair.reload(); err != nil {
+				errs = append(errs, err)
+			}
+			pairs = append(pairs, pair)
+			continue
+		}
+		pair, err := newCertReloader(file.CertFile, file.KeyFile, s.logger)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+
+	if len(pairs) == 0 {
+		return errors.Join(append(errs, fmt.Errorf("no usable TLS certificate"))...)
+	}
+	s.pairs.Store(&pairs)
+	return errors.Join(errs...)
+}
+
+// scanCertDir finds the pairs in dir: name.crt or name.pem next to
+// name.key, and subdirectories holding tls.crt and tls.key, as mounted
+// Kubernetes secrets do. Hidden entries are skipped.
+func scanCertDir(dir string) ([]TLSCertificateConfig, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	isFile := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode().IsRegular()
+	}
+
+	var pairs []TLSCertificateConfig
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+
+		// Follow symlinks, which secret mounts use
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			pair := TLSCertificateConfig{CertFile: filepath.Join(path, "tls.crt"), KeyFile: filepath.Join(path, "tls.key")}
+			if isFile(pair.CertFile) && isFile(pair.KeyFile) {
+				pairs = append(pairs, pair)
+			}
+			continue
+		}
+
+		ext := filepath.Ext(name)
+		if ext != ".crt" && ext != ".pem" {
+			continue
+		}
+		if key := strings.TrimSuffix(path, ext) + ".key"; isFile(key) {
+			pairs = append(pairs, TLSCertificateConfig{CertFile: path, KeyFile: key})
+		}
+	}
+	return pairs, nil
+}
+
+// sessionTicketKeys rotates a config's session ticket keys every interval,
+// keeping the previous keys so recent tickets still resume. Rotation
+// happens on handshakes, through GetConfigForClient.
+type sessionTicketKeys struct {
+	config   *tls.Config
+	interval time.Duration
+
+	mu      sync.Mutex
+	keys    [][32]byte
+	rotated time.Time
+}
+
+// getConfigForClient rotates the keys when they are due and returns the
+// config they are set on. http.Server serves a clone of its TLSConfig, so
+// keys set on the original after it started only apply this way.
+func (k *sessionTicketKeys) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if time.Since(k.rotated) >= k.interval {
+		var key [32]byte
+		rand.Read(key[:])
+		k.keys = append([][32]byte{key}, k.keys...)
+		if len(k.keys) > sessionTicketKeyCount {
+			k.keys = k.keys[:sessionTicketKeyCount]
+		}
+		k.config.SetSessionTicketKeys(k.keys)
+		k.rotated = time.Now()
+	}
+	return k.config, nil
+}
+
+// newTLSConfig builds the server's tls.Config around a certificate source
+func newTLSConfig(config *TLSConfig, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(config.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	curves, err := parseCurves(config.Curves)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate:         getCertificate,
+		MinVersion:             minVersion,
+		CipherSuites:           cipherSuites,
+		CurvePreferences:       curves,
+		NextProtos:             config.GetALPN(),
+		SessionTicketsDisabled: config.DisableSessionTickets,
+	}
+	if config.ClientAuth != nil {
+		if err := configureClientAuth(tlsConfig, config.ClientAuth); err != nil {
+			return nil, err
+		}
+	}
+	if config.SessionTicketRotation > 0 && !config.DisableSessionTickets {
+		keys := &sessionTicketKeys{config: tlsConfig, interval: time.Duration(config.SessionTicketRotation) * time.Second}
+		tlsConfig.GetConfigForClient = keys.getConfigForClient
+	}
+	return tlsConfig, nil
+}
+
+// tlsProtocols returns the HTTP versions offered through ALPN
+func tlsProtocols(alpn []string) *http.Protocols {
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(slices.Contains(alpn, "http/1.1"))
+	protocols.SetHTTP2(slices.Contains(alpn, "h2"))
+	return protocols
+}
+
+func parseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid min_version %q (must be \"1.2\" or \"1.3\")", name)
+	}
+	return version, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		suite := findCipherSuite(tls.CipherSuites(), name)
+		if suite == nil {
+			if findCipherSuite(tls.InsecureCipherSuites(), name) != nil {
+				return nil, fmt.Errorf("insecure cipher suite %s", name)
+			}
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("cipher suite %s is TLS 1.3 only; TLS 1.3 suites are not configurable", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+func findCipherSuite(suites []*tls.CipherSuite, name string) *tls.CipherSuite {
+	for _, suite := range suites {
+		if suite.Name == name {
+			return suite
+		}
+	}
+	return nil
+}
+
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	var curves []tls.CurveID
+	for _, name := range names {
+		curve, ok := tlsCurves[strings.ReplaceAll(strings.ToLower(name), "-", "")]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		curves = append(curves, curve)
+	}
+	return curves, nil
+}
+
+// ValidateTLS checks the certificate files, the protocol settings and the
+// client certificate CA bundle
+func ValidateTLS(config *TLSConfig) error {
+	switch config.AutoCert {
+	case "", AutoCertSelfSigned:
+	default:
+		return fmt.Errorf("invalid auto_cert %q (must be %q)", config.AutoCert, AutoCertSelfSigned)
+	}
+	if len(config.Certificates) == 0 && config.CertDir == "" && config.AutoCert == "" {
+		return fmt.Errorf("HTTPS enabled but no certificates, cert_dir or auto_cert specified")
+	}
+	for _, cert := range config.Certificates {
+		if cert.CertFile == "" || cert.KeyFile == "" {
+			return fmt.Errorf("certificate needs both cert_file and key_file")
+		}
+		if _, err := os.Stat(cert.CertFile); err != nil {
+			return fmt.Errorf("certificate file not found: %s", cert.CertFile)
+		}
+		if _, err := os.Stat(cert.KeyFile); err != nil {
+			return fmt.Errorf("key file not found: %s", cert.KeyFile)
+		}
+	}
+	if config.CertDir != "" {
+		if info, err := os.Stat(config.CertDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("cert_dir is not a directory: %s", config.CertDir)
+		}
+	}
+
+	minVersion, err := parseTLSVersion(config.MinVersion)
+	if err != nil {
+		return err
+	}
+	cipherSuites, err := parseCipherSuites(config.CipherSuites)
+	if err != nil {
+		return err
+	}
+	if len(cipherSuites) > 0 && minVersion == tls.VersionTLS13 {
+		return fmt.Errorf("cipher_suites have no effect with min_version 1.3")
+	}
+	if _, err := parseCurves(config.Curves); err != nil {
+		return err
+	}
+
+	for _, proto := range config.ALPN {
+		if proto != "h2" && proto != "http/1.1" {
+			return fmt.Errorf("invalid alpn protocol %q (must be \"h2\" or \"http/1.1\")", proto)
+		}
+	}
+	// HTTP/2 refuses to start without one of its required suites
+	if slices.Contains(config.GetALPN(), "h2") && len(cipherSuites) > 0 &&
+		!slices.Contains(cipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+		!slices.Contains(cipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return fmt.Errorf("cipher_suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 for HTTP/2")
+	}
+
+	if config.SessionTicketRotation < 0 {
+		return fmt.Errorf("invalid session_ticket_rotation: %d", config.SessionTicketRotation)
+	}
+	if config.ClientAuth != nil {
+		if err := configureClientAuth(&tls.Config{}, config.ClientAuth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
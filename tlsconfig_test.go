@@ -0,0 +1,144 @@
+package koryxserv
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertStoreSNI(t *testing.T) {
+	dir, certDir := t.TempDir(), t.TempDir()
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	expires := time.Now().Add(90 * 24 * time.Hour)
+
+	writeTestCert(t, filepath.Join(dir, "default.crt"), filepath.Join(dir, "default.key"), "default.test", expires)
+	writeTestCert(t, filepath.Join(certDir, "a.pem"), filepath.Join(certDir, "a.key"), "a.test", expires)
+	os.Mkdir(filepath.Join(certDir, "wildcard"), 0o755)
+	writeTestCert(t, filepath.Join(certDir, "wildcard", "tls.crt"), filepath.Join(certDir, "wildcard", "tls.key"), "*.b.test", expires)
+	os.WriteFile(filepath.Join(certDir, "orphan.crt"), []byte("no key"), 0o644)
+
+	config := &TLSConfig{
+		Enabled:      true,
+		Certificates: []TLSCertificateConfig{{CertFile: filepath.Join(dir, "default.crt"), KeyFile: filepath.Join(dir, "default.key")}},
+		CertDir:      certDir,
+	}
+	certs, err := newCertStore(config, logger)
+	if err != nil {
+		t.Fatalf("newCertStore failed: %v", err)
+	}
+	tlsConfig, err := newTLSConfig(config, certs.GetCertificate)
+	if err != nil {
+		t.Fatalf("newTLSConfig failed: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	served := func(serverName string) string {
+		t.Helper()
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("handshake for %q failed: %v", serverName, err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	for serverName, want := range map[string]string{
+		"a.test":       "a.test",
+		"www.b.test":   "*.b.test",
+		"unknown.test": "default.test",
+		"":             "default.test",
+	} {
+		if got := served(serverName); got != want {
+			t.Errorf("SNI %q: expected %s, got %s", serverName, want, got)
+		}
+	}
+
+	// Pairs added to cert_dir are picked up when the directory changes
+	writeTestCert(t, filepath.Join(certDir, "c.crt"), filepath.Join(certDir, "c.key"), "c.test", expires)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certDir, later, later)
+	certs.checked.Store(0)
+	if got := served("c.test"); got != "c.test" {
+		t.Errorf("expected new pair in cert_dir to be served, got %s", got)
+	}
+}
+
+func TestCertStoreRequiresCertificate(t *testing.T) {
+	logger, _ := NewLogger(&LoggingConfig{Enabled: false})
+	if _, err := newCertStore(&TLSConfig{Enabled: true, CertDir: t.TempDir()}, logger); err == nil {
+		t.Error("expected an empty cert_dir to be rejected")
+	}
+}
+
+func TestSessionTicketRotation(t *testing.T) {
+	tlsConfig, err := newTLSConfig(&TLSConfig{SessionTicketRotation: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &sessionTicketKeys{config: tlsConfig, interval: time.Nanosecond}
+	for i := 0; i < 5; i++ {
+		if config, _ := keys.getConfigForClient(nil); config != tlsConfig {
+			t.Fatal("expected the original config")
+		}
+	}
+	if len(keys.keys) != sessionTicketKeyCount || keys.keys[0] == keys.keys[1] {
+		t.Errorf("expected %d distinct keys, got %d", sessionTicketKeyCount, len(keys.keys))
+	}
+
+	tlsConfig, _ = newTLSConfig(&TLSConfig{}, nil)
+	if tlsConfig.GetConfigForClient != nil {
+		t.Error("expected Go's own key rotation without session_ticket_rotation")
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "site.crt"), filepath.Join(dir, "site.key")
+	writeTestCert(t, certFile, keyFile, "site.test", time.Now().Add(time.Hour))
+	pair := []TLSCertificateConfig{{CertFile: certFile, KeyFile: keyFile}}
+
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		wantErr bool
+	}{
+		{"certificate", TLSConfig{Certificates: pair}, false},
+		{"cert dir", TLSConfig{CertDir: dir}, false},
+		{"modern", TLSConfig{Certificates: pair, MinVersion: "1.3", Curves: []string{"X25519MLKEM768", "X25519", "P-256"}, ALPN: []string{"h2"}}, false},
+		{"cipher suites", TLSConfig{Certificates: pair, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"}}, false},
+		{"no certificate", TLSConfig{}, true},
+		{"missing key", TLSConfig{Certificates: []TLSCertificateConfig{{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}}}, true},
+		{"old version", TLSConfig{Certificates: pair, MinVersion: "1.1"}, true},
+		{"insecure suite", TLSConfig{Certificates: pair, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, true},
+		{"tls 1.3 suite", TLSConfig{Certificates: pair, CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}}, true},
+		{"suites with 1.3", TLSConfig{Certificates: pair, MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, true},
+		{"suites without http/2", TLSConfig{Certificates: pair, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}}, true},
+		{"suites for http/1.1", TLSConfig{Certificates: pair, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}, ALPN: []string{"http/1.1"}}, false},
+		{"unknown curve", TLSConfig{Certificates: pair, Curves: []string{"secp256k1"}}, true},
+		{"unknown protocol", TLSConfig{Certificates: pair, ALPN: []string{"h3"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTLS(&tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package koryxserv
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// hostRouter dispatches requests to a per-host handler based on the Host
+// header. Exact names win over wildcards, longer wildcards win over shorter
+// ones, and unknown hosts go to the fallback handler.
+type hostRouter struct {
+	exact     map[string]http.Handler
+	wildcards []wildcardHost
+	fallback  http.Handler
+}
+
+type wildcardHost struct {
+	suffix  string // ".example.com" for "*.example.com"
+	handler http.Handler
+}
+
+func newHostRouter(fallback http.Handler) *hostRouter {
+	return &hostRouter{
+		exact:    make(map[string]http.Handler),
+		fallback: fallback,
+	}
+}
+
+// add registers a handler for a host pattern ("example.com" or "*.example.com")
+func (hr *hostRouter) add(pattern string, handler http.Handler) error {
+	name := normalizeHostPattern(pattern)
+	if err := ValidateHostPattern(name); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(name, "*.") {
+		hr.wildcards = append(hr.wildcards, wildcardHost{suffix: name[1:], handler: handler})
+		sort.SliceStable(hr.wildcards, func(i, j int) bool {
+			return len(hr.wildcards[i].suffix) > len(hr.wildcards[j].suffix)
+		})
+		return nil
+	}
+	hr.exact[name] = handler
+	return nil
+}
+
+// match returns the handler for a request host
+func (hr *hostRouter) match(host string) http.Handler {
+	name := requestHostname(host)
+	if handler, ok := hr.exact[name]; ok {
+		return handler
+	}
+	for _, wildcard := range hr.wildcards {
+		if strings.HasSuffix(name, wildcard.suffix) && len(name) > len(wildcard.suffix) {
+			return wildcard.handler
+		}
+	}
+	return hr.fallback
+}
+
+func (hr *hostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hr.match(r.Host).ServeHTTP(w, r)
+}
+
+// ValidateHostPattern checks a hosts map key.
+// Only a single leading "*." wildcard label is supported.
+func ValidateHostPattern(pattern string) error {
+	name := normalizeHostPattern(pattern)
+	if name == "" {
+		return fmt.Errorf("empty host name")
+	}
+	rest := strings.TrimPrefix(name, "*.")
+	if rest == "" || strings.Contains(rest, "*") {
+		return fmt.Errorf("invalid host pattern %q (only a leading \"*.\" wildcard is supported)", pattern)
+	}
+	return nil
+}
+
+// normalizeHostPattern lowercases a configured host and drops any port
+func normalizeHostPattern(pattern string) string {
+	return requestHostname(strings.TrimSpace(pattern))
+}
+
+// requestHostname extracts the lowercase host name (without port or
+// trailing dot) from a Host header value
+func requestHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// newHostServer builds the server for a virtual host. Sections the host
+// does not override are inherited from the main configuration.
+func (s *Server) newHostServer(host *HostConfig) *Server {
+	derived := *s.config
+	derived.Hosts = nil
+
+	if host.RootDir != "" {
+		derived.Server.RootDir = host.RootDir
+		derived.Server.Layers = nil
+	}
+	if host.Features != nil {
+		derived.Features = *host.Features
+		// A features block without index settings keeps the defaults
+		if derived.Features.IndexFiles == nil {
+			derived.Features.IndexFiles = s.config.Features.IndexFiles
+		}
+		if derived.Features.SPAIndex == "" {
+			derived.Features.SPAIndex = s.config.Features.SPAIndex
+		}
+	}
+	if host.Security != nil {
+		derived.Security = *host.Security
+	}
+	if host.RuntimeConfig != nil {
+		derived.RuntimeConfig = host.RuntimeConfig
+	}
+	if host.Rules != nil {
+		derived.Rules = host.Rules
+	}
+	if host.Mounts != nil {
+		derived.Mounts = host.Mounts
+	}
+	if host.Proxies != nil {
+		derived.Proxies = host.Proxies
+	}
+
+	hostServer := NewServer(&derived, s.logger)
+	// Without its own root the host serves the main files, which may come
+	// from an fs.FS or a preloaded snapshot rather than root_dir
+	if host.RootDir == "" {
+		hostServer.fsys = s.fsys
+	}
+	return hostServer
+}
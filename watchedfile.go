@@ -0,0 +1,54 @@
+package koryxserv
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// watchedFileCheckInterval limits how often watched files are checked for changes
+const watchedFileCheckInterval = time.Second
+
+// watchedFile rereads a file from disk when its size or modification time
+// changes. Checks happen on use, at most once per interval.
+type watchedFile struct {
+	path    string
+	checked atomic.Int64 // unix nanoseconds of the last check
+
+	mu    sync.Mutex
+	stamp fileStamp
+}
+
+func newWatchedFile(path string) *watchedFile {
+	// An impossible stamp, so the first poll always loads
+	return &watchedFile{path: path, stamp: fileStamp{size: -1}}
+}
+
+// poll calls load with the file content, or the read error, when the file
+// changed since the last check
+func (f *watchedFile) poll(load func(data []byte, err error)) {
+	now := time.Now().UnixNano()
+	if now-f.checked.Load() < int64(watchedFileCheckInterval) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now-f.checked.Load() < int64(watchedFileCheckInterval) {
+		return
+	}
+	f.checked.Store(now)
+
+	var stamp fileStamp
+	if info, err := os.Stat(f.path); err == nil && info.Mode().IsRegular() {
+		stamp = fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+	}
+	if stamp == f.stamp {
+		return
+	}
+	f.stamp = stamp
+
+	data, err := os.ReadFile(f.path)
+	load(data, err)
+}